@@ -0,0 +1,156 @@
+package v1alpha1
+
+import (
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// CutoverPhase is the state of traffic switch from pod endpoints to proxy endpoints.
+// +kubebuilder:validation:Enum=Pending;ProxyReady;CutOver
+type CutoverPhase string
+
+const (
+	// PhasePending means that proxy service exists, but proxy endpoints are not published yet.
+	PhasePending CutoverPhase = "Pending"
+	// PhaseProxyReady means that proxy endpoints are published, pod endpoints are still bound to the service.
+	PhaseProxyReady CutoverPhase = "ProxyReady"
+	// PhaseCutOver means that service selector is removed and traffic goes only through proxy endpoints.
+	PhaseCutOver CutoverPhase = "CutOver"
+)
+
+// Condition types of WebhookProxy.
+const (
+	// ConditionReady is true when at least one ready node endpoint is published.
+	ConditionReady = "Ready"
+	// ConditionDegraded is true when the last proxy ensure failed, some node endpoints are not ready,
+	// or network policy is missing.
+	ConditionDegraded = "Degraded"
+	// ConditionSelectorStashed is true when origin service selector is removed and kept in annotation.
+	ConditionSelectorStashed = "SelectorStashed"
+	// ConditionCertificateValid is true when serving certificates of all webhooks are accepted
+	// through the proxy path, verified against webhook caBundle and service DNS name.
+	ConditionCertificateValid = "CertificateValid"
+)
+
+// WebhookReference points to an object using the service as a webhook backend.
+type WebhookReference struct {
+	// Kind is MutatingWebhookConfiguration, ValidatingWebhookConfiguration or CustomResourceDefinition.
+	Kind string `json:"kind"`
+	Name string `json:"name"`
+	// Webhooks are names of webhook entries in the configuration, empty for CustomResourceDefinition.
+	// +optional
+	Webhooks []string `json:"webhooks,omitempty"`
+}
+
+// NodePort is a service port published on nodes.
+type NodePort struct {
+	// +optional
+	Name     string      `json:"name,omitempty"`
+	Protocol v1.Protocol `json:"protocol"`
+	Port     int32       `json:"port"`
+	NodePort int32       `json:"nodePort"`
+}
+
+// NodeEndpoint is a node address published in proxy endpoint slice.
+type NodeEndpoint struct {
+	// +optional
+	NodeName string `json:"nodeName,omitempty"`
+	Address  string `json:"address"`
+	Ready    bool   `json:"ready"`
+}
+
+// PolicyReference points to the network policy restricting webhook pods.
+type PolicyReference struct {
+	APIVersion string `json:"apiVersion"`
+	Kind       string `json:"kind"`
+	Name       string `json:"name"`
+}
+
+// CertificateStatus is the result of TLS handshake with the webhook through a node endpoint.
+type CertificateStatus struct {
+	// Webhook is <kind>/<name>/<webhook>, or <kind>/<name> for CustomResourceDefinition.
+	Webhook string `json:"webhook"`
+	// Endpoint is the nodeIP:nodePort used for the handshake.
+	// +optional
+	Endpoint string `json:"endpoint,omitempty"`
+	// NotAfter is the expiry of the serving certificate.
+	// +optional
+	NotAfter *metav1.Time `json:"notAfter,omitempty"`
+	Reason   string       `json:"reason"`
+	// +optional
+	Message string `json:"message,omitempty"`
+}
+
+// WebhookProxySpec defines the proxied webhook service.
+type WebhookProxySpec struct {
+	// ServiceName is the name of proxied webhook service in the same namespace.
+	ServiceName string `json:"serviceName"`
+}
+
+// WebhookProxyStatus is the observed state of the proxied webhook service.
+type WebhookProxyStatus struct {
+	// Webhooks are objects referencing the service.
+	// +optional
+	Webhooks []WebhookReference `json:"webhooks,omitempty"`
+	// ProxyService is the name of NodePort proxy service.
+	// +optional
+	ProxyService string `json:"proxyService,omitempty"`
+	// +optional
+	NodePorts []NodePort `json:"nodePorts,omitempty"`
+	// Endpoints are node endpoints published in proxy endpoint slice.
+	// +optional
+	Endpoints []NodeEndpoint `json:"endpoints,omitempty"`
+	// +optional
+	ReadyEndpoints int32 `json:"readyEndpoints"`
+	// +optional
+	TotalEndpoints int32 `json:"totalEndpoints"`
+	// Restricted tells if proxy is published only on nodes running webhook pods.
+	// +optional
+	Restricted bool `json:"restricted"`
+	// NetworkPolicy is set when policy restricting webhook pods exists.
+	// +optional
+	NetworkPolicy *PolicyReference `json:"networkPolicy,omitempty"`
+	// +optional
+	Phase CutoverPhase `json:"phase,omitempty"`
+	// Certificates are results of the last serving certificate checks.
+	// +optional
+	Certificates []CertificateStatus `json:"certificates,omitempty"`
+	// +optional
+	// +listType=map
+	// +listMapKey=type
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
+// +kubebuilder:resource:shortName=wp
+// +kubebuilder:printcolumn:name="Service",type=string,JSONPath=`.spec.serviceName`
+// +kubebuilder:printcolumn:name="Phase",type=string,JSONPath=`.status.phase`
+// +kubebuilder:printcolumn:name="Ready",type=string,JSONPath=`.status.conditions[?(@.type=="Ready")].status`
+// +kubebuilder:printcolumn:name="Degraded",type=string,JSONPath=`.status.conditions[?(@.type=="Degraded")].status`
+// +kubebuilder:printcolumn:name="Endpoints",type=integer,JSONPath=`.status.readyEndpoints`
+// +kubebuilder:printcolumn:name="Total",type=integer,JSONPath=`.status.totalEndpoints`
+// +kubebuilder:printcolumn:name="Restricted",type=boolean,JSONPath=`.status.restricted`
+// +kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
+
+// WebhookProxy reports proxy state of a webhook service, owned by the controller.
+type WebhookProxy struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   WebhookProxySpec   `json:"spec,omitempty"`
+	Status WebhookProxyStatus `json:"status,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// WebhookProxyList contains a list of WebhookProxy.
+type WebhookProxyList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []WebhookProxy `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&WebhookProxy{}, &WebhookProxyList{})
+}
@@ -0,0 +1,81 @@
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// WebhookProxyConfigName is the name of the only WebhookProxyConfig handled by the controller.
+const WebhookProxyConfigName = "default"
+
+// Condition types of WebhookProxyConfig.
+const (
+	// ConditionAccepted is true when configuration is valid and applied.
+	ConditionAccepted = "Accepted"
+)
+
+// WebhookProxyConfigSpec overrides controller configuration, unset fields keep environment defaults.
+// +kubebuilder:validation:XValidation:rule="!has(self.restricted) || !self.restricted || !has(self.allowedCIDRs) || size(self.allowedCIDRs) > 0",message="allowedCIDRs must not be empty in restricted mode, network policy without sources would allow everyone"
+type WebhookProxyConfigSpec struct {
+	// Restricted publishes proxy only on nodes running webhook pods and creates network policies.
+	// +optional
+	Restricted *bool `json:"restricted,omitempty"`
+	// AllowedCIDRs are source CIDRs allowed by network policies.
+	// +optional
+	// +listType=set
+	// +kubebuilder:validation:MaxItems=64
+	// +kubebuilder:validation:items:MaxLength=18
+	// +kubebuilder:validation:XValidation:rule="self.all(c, isCIDR(c) && cidr(c).ip().family() == 4)",message="allowedCIDRs must be IPv4 CIDRs"
+	AllowedCIDRs []string `json:"allowedCIDRs,omitempty"`
+	// NodeSelector limits nodes published in proxy endpoint slices.
+	// +optional
+	NodeSelector *metav1.LabelSelector `json:"nodeSelector,omitempty"`
+	// ExcludedNamespaces are namespaces whose webhook services are never proxied.
+	// +optional
+	// +listType=set
+	ExcludedNamespaces []string `json:"excludedNamespaces,omitempty"`
+	// PolicyBackend is network policy implementation.
+	// +optional
+	// +kubebuilder:validation:Enum=kubernetes;calico
+	PolicyBackend string `json:"policyBackend,omitempty"`
+}
+
+// WebhookProxyConfigStatus is the observed state of the configuration.
+type WebhookProxyConfigStatus struct {
+	// ObservedGeneration is the generation applied by the controller.
+	// +optional
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+	// +optional
+	// +listType=map
+	// +listMapKey=type
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
+// +kubebuilder:resource:scope=Cluster
+// +kubebuilder:validation:XValidation:rule="self.metadata.name == 'default'",message="only WebhookProxyConfig named 'default' is supported"
+// +kubebuilder:printcolumn:name="Accepted",type=string,JSONPath=`.status.conditions[?(@.type=="Accepted")].status`
+// +kubebuilder:printcolumn:name="Backend",type=string,JSONPath=`.spec.policyBackend`
+// +kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
+
+// WebhookProxyConfig is cluster-wide controller configuration, reloaded at runtime.
+type WebhookProxyConfig struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   WebhookProxyConfigSpec   `json:"spec,omitempty"`
+	Status WebhookProxyConfigStatus `json:"status,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// WebhookProxyConfigList contains a list of WebhookProxyConfig.
+type WebhookProxyConfigList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []WebhookProxyConfig `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&WebhookProxyConfig{}, &WebhookProxyConfigList{})
+}
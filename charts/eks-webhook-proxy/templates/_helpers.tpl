@@ -69,4 +69,12 @@ Create the jobs fully-qualified Docker image to use
 */}}
 {{- define "eks-webhook-proxy.jobsFullyQualifiedDockerImage" -}}
 {{- printf "%s:%s" .Values.jobs.image.repository .Values.jobs.image.tag -}}
-{{- end }}
\ No newline at end of file
+{{- end }}
+{{/*
+Validate values, controller refuses to start with the same configuration.
+*/}}
+{{- define "eks-webhook-proxy.validateValues" -}}
+{{- if and .Values.options.webhookRestricted (not .Values.options.webhookAllowedCIDRS) (not .Values.options.webhookAutoCIDRs) }}
+{{- fail "options.webhookRestricted requires options.webhookAllowedCIDRS or options.webhookAutoCIDRs=true, network policy without sources would allow everyone" }}
+{{- end }}
+{{- end }}
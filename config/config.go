@@ -1,11 +1,40 @@
 package config
 
 import (
+	"fmt"
+	"time"
+
 	"github.com/caarlos0/env/v6"
 )
 
+const (
+	// PolicyBackendKubernetes restricts webhooks with networking.k8s.io/v1 NetworkPolicy.
+	PolicyBackendKubernetes = "kubernetes"
+	// PolicyBackendCalico restricts webhooks with projectcalico.org/v3 NetworkPolicy,
+	// allowed CIDRs are shared through a single GlobalNetworkSet.
+	PolicyBackendCalico = "calico"
+
+	// SelectionModeAll proxies every service-backed webhook, selection annotations are ignored.
+	SelectionModeAll = "all"
+	// SelectionModeOptIn proxies only webhooks of objects opted in with annotations.
+	SelectionModeOptIn = "opt-in"
+	// SelectionModeOptOut proxies every webhook, except of objects opted out with annotations.
+	SelectionModeOptOut = "opt-out"
+
+	// TrafficPolicyLocal keeps client address, node ports of restricted webhooks are open only on nodes running webhook pods.
+	TrafficPolicyLocal = "Local"
+	// TrafficPolicyCluster opens node ports of restricted webhooks on every node,
+	// kube-proxy SNATs traffic, so network policy allows node addresses too.
+	TrafficPolicyCluster = "Cluster"
+)
+
 type Config struct {
-	Proxy Proxy `envPrefix:"PROXY_"`
+	Proxy         Proxy         `envPrefix:"PROXY_"`
+	SecurityGroup SecurityGroup `envPrefix:"SECURITY_GROUP_"`
+	Tracing       Tracing       `envPrefix:"TRACING_"`
+	Probe         Probe         `envPrefix:"PROBE_"`
+	CertCheck     CertCheck     `envPrefix:"CERT_CHECK_"`
+	Canary        Canary        `envPrefix:"CANARY_"`
 }
 
 type Proxy struct {
@@ -15,9 +44,83 @@ type Proxy struct {
 	// AllowedSrcCIDRs tells controller to create network policy
 	// with CIDRs allowed. Will be handled only if Restricted set to true.
 	AllowedSrcCIDRs []string `env:"ALLOWED_CIDRS"`
+	// AutoCIDRs tells controller to derive allowed source CIDRs from default/kubernetes
+	// EndpointSlice (EKS control-plane ENIs), merged with AllowedSrcCIDRs.
+	AutoCIDRs bool `env:"AUTO_CIDRS"`
+	// AutoCIDRSupernets are used instead of /32 for derived addresses they contain.
+	AutoCIDRSupernets []string `env:"AUTO_CIDR_SUPERNETS"`
+	// NodeSelector is a label selector limiting nodes published in proxy endpoint slices.
+	NodeSelector string `env:"NODE_SELECTOR"`
+	// ExcludedNamespaces are namespaces whose webhook services are never proxied.
+	ExcludedNamespaces []string `env:"EXCLUDED_NAMESPACES"`
+	// RestrictedTrafficPolicy is externalTrafficPolicy of proxy services of restricted webhooks,
+	// see TrafficPolicy* constants. Unrestricted webhooks always use Cluster.
+	RestrictedTrafficPolicy string `env:"RESTRICTED_TRAFFIC_POLICY" envDefault:"Local"`
+	// PolicyBackend selects the network policy implementation used for restricted webhooks.
+	PolicyBackend string `env:"POLICY_BACKEND" envDefault:"kubernetes"`
+	// SelectionMode tells which webhooks are proxied, see SelectionMode* constants.
+	SelectionMode string `env:"SELECTION_MODE" envDefault:"all"`
+	// ObserveOnly tells controllers to compute the desired state and report it,
+	// without writing anything to the API.
+	ObserveOnly bool `env:"OBSERVE_ONLY"`
+}
+
+type SecurityGroup struct {
+	// Enabled turns on reconciliation of node security group rules for proxy NodePorts.
+	Enabled bool `env:"ENABLED"`
+	// NodeGroupID is the node security group receiving NodePort ingress rules.
+	NodeGroupID string `env:"NODE_GROUP_ID"`
+	// ClusterGroupID is the EKS cluster security group, used as rules source.
+	ClusterGroupID string `env:"CLUSTER_GROUP_ID"`
+	// ReportOnly tells controller to only print rules it would apply.
+	ReportOnly bool `env:"REPORT_ONLY"`
+}
+
+type Tracing struct {
+	// Enabled turns on OpenTelemetry tracing of reconciles, exported with OTLP over HTTP.
+	Enabled bool `env:"ENABLED"`
+	// Endpoint is the OTLP collector address (host:port).
+	Endpoint string `env:"ENDPOINT"`
+	// Insecure disables TLS towards the collector.
+	Insecure bool `env:"INSECURE"`
+	// SampleRatio is the fraction of new traces which are sampled.
+	SampleRatio float64 `env:"SAMPLE_RATIO" envDefault:"1"`
 }
 
-// New creates a new Config.
+type Probe struct {
+	// Enabled turns on periodic TCP dials of published nodeIP:nodePort endpoints.
+	Enabled bool `env:"ENABLED"`
+	// Interval between probe rounds.
+	Interval time.Duration `env:"INTERVAL" envDefault:"10s"`
+	// Timeout of a single dial.
+	Timeout time.Duration `env:"TIMEOUT" envDefault:"2s"`
+	// FailureThreshold is the number of consecutive failures marking endpoint not ready.
+	FailureThreshold int `env:"FAILURE_THRESHOLD" envDefault:"3"`
+	// SuccessThreshold is the number of consecutive successes marking endpoint ready again.
+	SuccessThreshold int `env:"SUCCESS_THRESHOLD" envDefault:"2"`
+}
+
+type CertCheck struct {
+	// Enabled turns on TLS handshakes with webhooks through the proxy path.
+	Enabled bool `env:"ENABLED"`
+	// Interval between checks of the same webhook service.
+	Interval time.Duration `env:"INTERVAL" envDefault:"10m"`
+	// Timeout of a single handshake.
+	Timeout time.Duration `env:"TIMEOUT" envDefault:"5s"`
+	// ExpiryWarning is how long before certificate expiry a warning is reported.
+	ExpiryWarning time.Duration `env:"EXPIRY_WARNING" envDefault:"336h"`
+}
+
+type Canary struct {
+	// Enabled turns on periodic dry-run requests for webhook configurations with canary template annotation.
+	Enabled bool `env:"ENABLED"`
+	// Interval between canary requests.
+	Interval time.Duration `env:"INTERVAL" envDefault:"1m"`
+	// Timeout of a single canary request.
+	Timeout time.Duration `env:"TIMEOUT" envDefault:"15s"`
+}
+
+// New creates a new Config from process environment and validates it.
 func New() (*Config, error) {
 	cfg := &Config{}
 
@@ -25,5 +128,9 @@ func New() (*Config, error) {
 		return nil, err
 	}
 
+	if err := Validate(cfg); err != nil {
+		return nil, fmt.Errorf("invalid configuration:\n%w", err)
+	}
+
 	return cfg, nil
 }
@@ -0,0 +1,33 @@
+package config
+
+import (
+	"sync/atomic"
+)
+
+// Store holds configuration which could be replaced at runtime.
+// Defaults are parsed from environment and never change.
+type Store struct {
+	defaults *Config
+	current  atomic.Pointer[Config]
+}
+
+func NewStore(defaults *Config) *Store {
+	s := &Store{defaults: defaults}
+	s.current.Store(defaults)
+	return s
+}
+
+// Get returns current configuration, it must not be modified.
+func (s *Store) Get() *Config {
+	return s.current.Load()
+}
+
+// Defaults returns configuration parsed from environment.
+func (s *Store) Defaults() *Config {
+	return s.defaults
+}
+
+// Set replaces current configuration.
+func (s *Store) Set(cfg *Config) {
+	s.current.Store(cfg)
+}
@@ -0,0 +1,210 @@
+package config
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+
+	"github.com/caarlos0/env/v6"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// ValidationError describes invalid configuration option.
+type ValidationError struct {
+	// Option is environment variable name.
+	Option string
+	Reason string
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Option, e.Reason)
+}
+
+// Validate checks configuration values and their combinations.
+// All found problems are returned joined, every one is a *ValidationError.
+func Validate(cfg *Config) error {
+	var errs []error
+	invalid := func(option, format string, args ...interface{}) {
+		errs = append(errs, &ValidationError{Option: option, Reason: fmt.Sprintf(format, args...)})
+	}
+
+	proxy := cfg.Proxy
+
+	switch proxy.PolicyBackend {
+	case PolicyBackendKubernetes, PolicyBackendCalico:
+	default:
+		invalid("PROXY_POLICY_BACKEND", "unknown backend %q, expected %q or %q",
+			proxy.PolicyBackend, PolicyBackendKubernetes, PolicyBackendCalico)
+	}
+
+	switch proxy.RestrictedTrafficPolicy {
+	case TrafficPolicyLocal, TrafficPolicyCluster:
+	default:
+		invalid("PROXY_RESTRICTED_TRAFFIC_POLICY", "unknown traffic policy %q, expected %q or %q",
+			proxy.RestrictedTrafficPolicy, TrafficPolicyLocal, TrafficPolicyCluster)
+	}
+
+	switch proxy.SelectionMode {
+	case SelectionModeAll, SelectionModeOptIn, SelectionModeOptOut:
+	default:
+		invalid("PROXY_SELECTION_MODE", "unknown mode %q, expected %q, %q or %q",
+			proxy.SelectionMode, SelectionModeAll, SelectionModeOptIn, SelectionModeOptOut)
+	}
+
+	for _, cidr := range proxy.AllowedSrcCIDRs {
+		if err := ValidateCIDR(cidr); err != nil {
+			invalid("PROXY_ALLOWED_CIDRS", "%v", err)
+		}
+	}
+	for _, cidr := range proxy.AutoCIDRSupernets {
+		if err := ValidateCIDR(cidr); err != nil {
+			invalid("PROXY_AUTO_CIDR_SUPERNETS", "%v", err)
+		}
+	}
+
+	if _, err := labels.Parse(proxy.NodeSelector); err != nil {
+		invalid("PROXY_NODE_SELECTOR", "invalid label selector %q: %v", proxy.NodeSelector, err)
+	}
+
+	if proxy.Restricted && len(proxy.AllowedSrcCIDRs) == 0 && !proxy.AutoCIDRs {
+		invalid("PROXY_ALLOWED_CIDRS",
+			"restricted mode requires allowed CIDRs or PROXY_AUTO_CIDRS=true, network policy without sources would allow everyone")
+	}
+	if len(proxy.AutoCIDRSupernets) > 0 && !proxy.AutoCIDRs {
+		invalid("PROXY_AUTO_CIDR_SUPERNETS", "supernets are set, but PROXY_AUTO_CIDRS is disabled")
+	}
+
+	securityGroup := cfg.SecurityGroup
+	if securityGroup.Enabled {
+		if err := validateSecurityGroupID(securityGroup.NodeGroupID); err != nil {
+			invalid("SECURITY_GROUP_NODE_GROUP_ID", "%v", err)
+		}
+		if err := validateSecurityGroupID(securityGroup.ClusterGroupID); err != nil {
+			invalid("SECURITY_GROUP_CLUSTER_GROUP_ID", "%v", err)
+		}
+		if securityGroup.NodeGroupID != "" && securityGroup.NodeGroupID == securityGroup.ClusterGroupID {
+			invalid("SECURITY_GROUP_CLUSTER_GROUP_ID", "cluster security group must differ from node security group")
+		}
+	}
+
+	tracing := cfg.Tracing
+	if tracing.Enabled && tracing.Endpoint == "" {
+		invalid("TRACING_ENDPOINT", "collector endpoint is required when tracing is enabled")
+	}
+	if tracing.SampleRatio < 0 || tracing.SampleRatio > 1 {
+		invalid("TRACING_SAMPLE_RATIO", "sample ratio %v is out of range [0, 1]", tracing.SampleRatio)
+	}
+
+	probe := cfg.Probe
+	if probe.Enabled {
+		if probe.Interval <= 0 {
+			invalid("PROBE_INTERVAL", "interval must be positive")
+		}
+		if probe.Timeout <= 0 || probe.Timeout > probe.Interval {
+			invalid("PROBE_TIMEOUT", "timeout %s must be positive and not longer than interval %s", probe.Timeout, probe.Interval)
+		}
+		if probe.FailureThreshold < 1 {
+			invalid("PROBE_FAILURE_THRESHOLD", "threshold must be at least 1")
+		}
+		if probe.SuccessThreshold < 1 {
+			invalid("PROBE_SUCCESS_THRESHOLD", "threshold must be at least 1")
+		}
+	}
+
+	certCheck := cfg.CertCheck
+	if certCheck.Enabled {
+		if certCheck.Interval <= 0 {
+			invalid("CERT_CHECK_INTERVAL", "interval must be positive")
+		}
+		if certCheck.Timeout <= 0 {
+			invalid("CERT_CHECK_TIMEOUT", "timeout must be positive")
+		}
+		if certCheck.ExpiryWarning < 0 {
+			invalid("CERT_CHECK_EXPIRY_WARNING", "expiry warning must not be negative")
+		}
+	}
+
+	canary := cfg.Canary
+	if canary.Enabled {
+		if canary.Interval <= 0 {
+			invalid("CANARY_INTERVAL", "interval must be positive")
+		}
+		if canary.Timeout <= 0 || canary.Timeout > canary.Interval {
+			invalid("CANARY_TIMEOUT", "timeout %s must be positive and not longer than interval %s", canary.Timeout, canary.Interval)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// Load parses configuration from environment map and validates it.
+func Load(environment map[string]string) (*Config, error) {
+	cfg := &Config{}
+
+	if err := env.Parse(cfg, env.Options{Environment: environment}); err != nil {
+		return nil, err
+	}
+
+	if err := Validate(cfg); err != nil {
+		return nil, fmt.Errorf("invalid configuration:\n%w", err)
+	}
+
+	return cfg, nil
+}
+
+// LoadFile parses configuration from dotenv file (KEY=VALUE lines) and validates it.
+func LoadFile(path string) (*Config, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	environment := make(map[string]string)
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		key, value, ok := strings.Cut(text, "=")
+		if !ok {
+			return nil, fmt.Errorf("%s:%d: expected KEY=VALUE", path, line)
+		}
+		environment[strings.TrimSpace(key)] = strings.Trim(strings.TrimSpace(value), `"'`)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return Load(environment)
+}
+
+// ValidateCIDR checks that cidr is an IPv4 network without host bits, as node addresses are IPv4 only.
+func ValidateCIDR(cidr string) error {
+	ip, ipNet, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return fmt.Errorf("invalid CIDR %q", cidr)
+	}
+	if ip.To4() == nil {
+		return fmt.Errorf("CIDR %q is not IPv4, only IPv4 node addresses are proxied", cidr)
+	}
+	if !ip.Equal(ipNet.IP) {
+		return fmt.Errorf("CIDR %q has host bits set, did you mean %q", cidr, ipNet.String())
+	}
+	return nil
+}
+
+func validateSecurityGroupID(id string) error {
+	if id == "" {
+		return fmt.Errorf("security group ID is required when security group reconciliation is enabled")
+	}
+	if !strings.HasPrefix(id, "sg-") {
+		return fmt.Errorf("invalid security group ID %q", id)
+	}
+	return nil
+}
@@ -0,0 +1,227 @@
+package config
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+// defaults returns configuration with environment defaults, which is valid.
+func defaults(t *testing.T) *Config {
+	t.Helper()
+	cfg, err := Load(map[string]string{})
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	return cfg
+}
+
+// invalidOptions returns options of validation errors joined in err.
+func invalidOptions(t *testing.T, err error) []string {
+	t.Helper()
+	if err == nil {
+		return nil
+	}
+	joined, ok := err.(interface{ Unwrap() []error })
+	if !ok {
+		t.Fatalf("error %v is not joined", err)
+	}
+
+	var options []string
+	for _, err := range joined.Unwrap() {
+		var validationErr *ValidationError
+		if !errors.As(err, &validationErr) {
+			t.Fatalf("error %v is not *ValidationError", err)
+		}
+		options = append(options, validationErr.Option)
+	}
+	sort.Strings(options)
+	return options
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(cfg *Config)
+		want   []string
+	}{
+		{
+			name:   "defaults",
+			modify: func(cfg *Config) {},
+		},
+		{
+			name: "restricted with allowed CIDRs",
+			modify: func(cfg *Config) {
+				cfg.Proxy.Restricted = true
+				cfg.Proxy.AllowedSrcCIDRs = []string{"10.0.0.0/8"}
+			},
+		},
+		{
+			name: "restricted with auto CIDRs",
+			modify: func(cfg *Config) {
+				cfg.Proxy.Restricted = true
+				cfg.Proxy.AutoCIDRs = true
+				cfg.Proxy.AutoCIDRSupernets = []string{"10.0.0.0/16"}
+			},
+		},
+		{
+			name:   "restricted without sources",
+			modify: func(cfg *Config) { cfg.Proxy.Restricted = true },
+			want:   []string{"PROXY_ALLOWED_CIDRS"},
+		},
+		{
+			name:   "CIDR with host bits",
+			modify: func(cfg *Config) { cfg.Proxy.AllowedSrcCIDRs = []string{"10.0.0.5/8"} },
+			want:   []string{"PROXY_ALLOWED_CIDRS"},
+		},
+		{
+			name:   "IPv6 CIDR",
+			modify: func(cfg *Config) { cfg.Proxy.AllowedSrcCIDRs = []string{"fd00::/8"} },
+			want:   []string{"PROXY_ALLOWED_CIDRS"},
+		},
+		{
+			name:   "supernets without auto CIDRs",
+			modify: func(cfg *Config) { cfg.Proxy.AutoCIDRSupernets = []string{"10.0.0.0/16"} },
+			want:   []string{"PROXY_AUTO_CIDR_SUPERNETS"},
+		},
+		{
+			name: "unknown names",
+			modify: func(cfg *Config) {
+				cfg.Proxy.PolicyBackend = "cilium"
+				cfg.Proxy.RestrictedTrafficPolicy = "Node"
+				cfg.Proxy.SelectionMode = "some"
+			},
+			want: []string{"PROXY_POLICY_BACKEND", "PROXY_RESTRICTED_TRAFFIC_POLICY", "PROXY_SELECTION_MODE"},
+		},
+		{
+			name:   "invalid node selector",
+			modify: func(cfg *Config) { cfg.Proxy.NodeSelector = "a in (" },
+			want:   []string{"PROXY_NODE_SELECTOR"},
+		},
+		{
+			name: "security group",
+			modify: func(cfg *Config) {
+				cfg.SecurityGroup = SecurityGroup{Enabled: true, NodeGroupID: "node", ClusterGroupID: ""}
+			},
+			want: []string{"SECURITY_GROUP_CLUSTER_GROUP_ID", "SECURITY_GROUP_NODE_GROUP_ID"},
+		},
+		{
+			name: "same security groups",
+			modify: func(cfg *Config) {
+				cfg.SecurityGroup = SecurityGroup{Enabled: true, NodeGroupID: "sg-1", ClusterGroupID: "sg-1"}
+			},
+			want: []string{"SECURITY_GROUP_CLUSTER_GROUP_ID"},
+		},
+		{
+			name: "probe timeout longer than interval",
+			modify: func(cfg *Config) {
+				cfg.Probe.Enabled = true
+				cfg.Probe.Timeout = 2 * cfg.Probe.Interval
+			},
+			want: []string{"PROBE_TIMEOUT"},
+		},
+		{
+			name: "disabled sections are not validated",
+			modify: func(cfg *Config) {
+				cfg.Probe.Interval = 0
+				cfg.CertCheck.Interval = 0
+				cfg.Canary.Interval = 0
+			},
+		},
+		{
+			name: "tracing",
+			modify: func(cfg *Config) {
+				cfg.Tracing.Enabled = true
+				cfg.Tracing.SampleRatio = 2
+			},
+			want: []string{"TRACING_ENDPOINT", "TRACING_SAMPLE_RATIO"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := defaults(t)
+			tt.modify(cfg)
+			if got := invalidOptions(t, Validate(cfg)); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Validate() invalid options = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadFile(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    func(cfg *Config)
+		wantErr bool
+	}{
+		{
+			name: "values",
+			content: `# comment
+PROXY_RESTRICTED=true
+PROXY_ALLOWED_CIDRS="10.0.0.0/8,192.168.0.0/16"
+
+PROXY_SELECTION_MODE = 'opt-in'
+`,
+			want: func(cfg *Config) {
+				cfg.Proxy.Restricted = true
+				cfg.Proxy.AllowedSrcCIDRs = []string{"10.0.0.0/8", "192.168.0.0/16"}
+				cfg.Proxy.SelectionMode = SelectionModeOptIn
+			},
+		},
+		{
+			name:    "empty file has defaults",
+			content: "",
+			want:    func(cfg *Config) {},
+		},
+		{
+			name:    "line without value",
+			content: "PROXY_RESTRICTED\n",
+			wantErr: true,
+		},
+		{
+			name:    "invalid configuration",
+			content: "PROXY_RESTRICTED=true\n",
+			wantErr: true,
+		},
+		{
+			name:    "unparsable value",
+			content: "PROBE_INTERVAL=often\n",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "config.env")
+			if err := os.WriteFile(path, []byte(tt.content), 0o600); err != nil {
+				t.Fatal(err)
+			}
+
+			cfg, err := LoadFile(path)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("LoadFile() error = nil, want error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("LoadFile() error = %v", err)
+			}
+
+			want := defaults(t)
+			tt.want(want)
+			if !reflect.DeepEqual(cfg, want) {
+				t.Errorf("LoadFile() = %+v, want %+v", cfg, want)
+			}
+		})
+	}
+
+	if _, err := LoadFile(filepath.Join(t.TempDir(), "missing.env")); err == nil {
+		t.Error("LoadFile() of missing file error = nil, want error")
+	}
+}
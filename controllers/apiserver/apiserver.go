@@ -0,0 +1,87 @@
+package apiserver
+
+import (
+	"context"
+	"slices"
+
+	"github.com/CharlieR-o-o-t/eks-webhook-proxy/config"
+	"github.com/CharlieR-o-o-t/eks-webhook-proxy/pkg/cidrcache"
+	"github.com/CharlieR-o-o-t/eks-webhook-proxy/pkg/debug"
+	"github.com/CharlieR-o-o-t/eks-webhook-proxy/pkg/proxy"
+	"github.com/CharlieR-o-o-t/eks-webhook-proxy/pkg/tracing"
+	"github.com/CharlieR-o-o-t/eks-webhook-proxy/pkg/utils"
+	"github.com/go-logr/logr"
+	discoveryv1 "k8s.io/api/discovery/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+const (
+	ControllerName = "apiserver-controller"
+)
+
+// Controller derives allowed source CIDRs from the kubernetes API endpoints
+// and refreshes all network policies when they are changed.
+type Controller struct {
+	Store     *config.Store
+	Client    client.Client
+	Proxy     *proxy.Proxy
+	CIDRCache *cidrcache.CIDRCache
+	Log       logr.Logger
+
+	// refreshed are the CIDRs of the last successful network policy refresh.
+	// Cache is updated before the refresh, so the refresh is retried until it succeeds.
+	refreshed []string
+}
+
+func (c *Controller) Reconcile(ctx context.Context, req reconcile.Request) (reconcile.Result, error) {
+	log := c.Log.WithValues("name", req.String())
+
+	endpointSlices, err := cidrcache.ListAPIServerEndpointSlices(ctx, c.Client)
+	if err != nil {
+		log.Error(err, "unable to list kubernetes API EndpointSlices")
+		return reconcile.Result{}, err
+	}
+
+	cidrs := cidrcache.FromEndpointSlices(endpointSlices, c.Store.Get().Proxy.AutoCIDRSupernets)
+	if len(cidrs) == 0 {
+		// keep last known CIDRs, empty list would allow everyone.
+		log.Info("no kubernetes API endpoints found, keeping derived CIDRs")
+		return reconcile.Result{}, nil
+	}
+
+	c.CIDRCache.Set(cidrs)
+	if slices.Equal(cidrs, c.refreshed) {
+		return reconcile.Result{}, nil
+	}
+	log.Info("kubernetes API CIDRs have been changed", "cidrs", cidrs)
+
+	if err := c.Proxy.RefreshNetworkPolicies(ctx); err != nil {
+		log.Error(err, "unable to refresh network policies")
+		return reconcile.Result{}, err
+	}
+	c.refreshed = cidrs
+
+	return reconcile.Result{}, nil
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (c *Controller) SetupWithManager(mgr ctrl.Manager) error {
+	predicateKubernetes := predicate.NewPredicateFuncs(func(obj client.Object) bool {
+		return obj.GetNamespace() == cidrcache.KubernetesServiceNamespace &&
+			obj.GetLabels()[utils.LabelEndpointSliceServiceName] == cidrcache.KubernetesServiceName
+	})
+
+	return ctrl.NewControllerManagedBy(mgr).
+		Named(ControllerName).
+		Watches(
+			&discoveryv1.EndpointSlice{},
+			&handler.EnqueueRequestForObject{},
+			builder.WithPredicates(predicateKubernetes),
+		).
+		Complete(debug.Reconciler(ControllerName, tracing.Reconciler(ControllerName, c)))
+}
@@ -2,10 +2,14 @@ package crd
 
 import (
 	"context"
-	"github.com/go-logr/logr"
+	"errors"
 	"github.com/CharlieR-o-o-t/eks-webhook-proxy/config"
+	"github.com/CharlieR-o-o-t/eks-webhook-proxy/pkg/debug"
 	"github.com/CharlieR-o-o-t/eks-webhook-proxy/pkg/proxy"
+	"github.com/CharlieR-o-o-t/eks-webhook-proxy/pkg/tracing"
 	"github.com/CharlieR-o-o-t/eks-webhook-proxy/pkg/utils"
+	"github.com/CharlieR-o-o-t/eks-webhook-proxy/pkg/webhookref"
+	"github.com/go-logr/logr"
 	admissionv1 "k8s.io/api/admissionregistration/v1"
 	apiextv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
@@ -24,7 +28,7 @@ const (
 )
 
 type Controller struct {
-	Config *config.Config
+	Store  *config.Store
 	Client client.Client
 	Proxy  *proxy.Proxy
 	Log    logr.Logger
@@ -32,6 +36,7 @@ type Controller struct {
 
 func (c *Controller) Reconcile(ctx context.Context, req reconcile.Request) (reconcile.Result, error) {
 	log := c.Log.WithValues("name", req.String())
+	tracing.SetAttributes(ctx, tracing.Webhook(webhookref.KindCRD, req.Name))
 
 	var crdObj = new(apiextv1.CustomResourceDefinition)
 	if err := c.Client.Get(ctx, req.NamespacedName, crdObj); err != nil {
@@ -43,7 +48,12 @@ func (c *Controller) Reconcile(ctx context.Context, req reconcile.Request) (reco
 		return reconcile.Result{}, err
 	}
 
-	service := crdObj.Spec.Conversion.Webhook.ClientConfig.Service
+	// Conversion webhook may be removed or deselected after the event was queued.
+	service := webhookref.ConversionService(crdObj)
+	if service == nil || !webhookref.Selected(c.Store.Get().Proxy.SelectionMode, crdObj, "") {
+		log.V(5).Info("conversion webhook is not selected, skipping")
+		return reconcile.Result{}, nil
+	}
 	log = log.WithValues("service", types.NamespacedName{Name: service.Name, Namespace: service.Namespace})
 
 	webhookServiceRef := &admissionv1.ServiceReference{
@@ -56,24 +66,14 @@ func (c *Controller) Reconcile(ctx context.Context, req reconcile.Request) (reco
 		webhookServiceRef.Port = service.Port
 	}
 
-	// Create/update nodePort service for proxy.
-	serviceProxy, err := c.Proxy.EnsureServiceProxy(
-		ctx,
-		webhookServiceRef,
-	)
-	if err != nil {
-		log.Error(err, "unable to create proxy")
-		return reconcile.Result{}, err
-	}
-
-	// Create/update endpoint slice with nodePort endpoints.
-	if err := c.Proxy.EnsureProxyEndpointSlices(ctx, serviceProxy); err != nil {
-		log.Error(err, "unable to create proxy endpoint slices")
-		return reconcile.Result{}, err
-	}
-
-	if err := c.Proxy.UnbindPodEndpoints(ctx, webhookServiceRef); err != nil {
-		log.Error(err, "unable to unbind Pod Endpoints from webhook service")
+	// Create/update nodePort service, endpoint slice with nodePort endpoints
+	// and unbind pod endpoints.
+	if err := c.Proxy.EnsureWebhookService(ctx, webhookServiceRef); err != nil {
+		if errors.Is(err, proxy.ErrServiceNotFound) || errors.Is(err, proxy.ErrServiceNotProxied) {
+			log.V(5).Info("webhook service is not proxied, skipping", "reason", err.Error())
+			return reconcile.Result{}, nil
+		}
+		log.Error(err, "unable to proxy webhook service")
 		return reconcile.Result{}, err
 	}
 
@@ -83,13 +83,11 @@ func (c *Controller) Reconcile(ctx context.Context, req reconcile.Request) (reco
 // SetupWithManager sets up the controller with the Manager.
 func (r *Controller) SetupWithManager(mgr ctrl.Manager) error {
 
-	// Contains conversion webhook proxy.
+	// Contains selected conversion webhook with service.
 	predicateCRD := predicate.NewPredicateFuncs(func(obj client.Object) bool {
 		crd := obj.(*apiextv1.CustomResourceDefinition)
-		return crd.Spec.Conversion != nil &&
-			crd.Spec.Conversion.Webhook != nil &&
-			crd.Spec.Conversion.Webhook.ClientConfig != nil &&
-			crd.Spec.Conversion.Webhook.ClientConfig.Service != nil
+		return webhookref.ConversionService(crd) != nil &&
+			webhookref.Selected(r.Store.Get().Proxy.SelectionMode, crd, "")
 	})
 
 	return ctrl.NewControllerManagedBy(mgr).
@@ -99,5 +97,5 @@ func (r *Controller) SetupWithManager(mgr ctrl.Manager) error {
 			&handler.EnqueueRequestForObject{},
 			builder.WithPredicates(predicateCRD),
 		).
-		Complete(r)
+		Complete(debug.Reconciler(ControllerName, tracing.Reconciler(ControllerName, r)))
 }
@@ -4,7 +4,9 @@ import (
 	"context"
 	"errors"
 	"github.com/CharlieR-o-o-t/eks-webhook-proxy/config"
+	"github.com/CharlieR-o-o-t/eks-webhook-proxy/pkg/debug"
 	"github.com/CharlieR-o-o-t/eks-webhook-proxy/pkg/proxy"
+	"github.com/CharlieR-o-o-t/eks-webhook-proxy/pkg/tracing"
 	"github.com/CharlieR-o-o-t/eks-webhook-proxy/pkg/utils"
 	"github.com/go-logr/logr"
 	v1 "k8s.io/api/core/v1"
@@ -24,7 +26,7 @@ const (
 )
 
 type Controller struct {
-	Config *config.Config
+	Store  *config.Store
 	Client client.Client
 	Proxy  *proxy.Proxy
 	Log    logr.Logger
@@ -89,7 +91,7 @@ func (c *Controller) SetupWithManager(mgr ctrl.Manager) error {
 			&handler.EnqueueRequestForObject{},
 			builder.WithPredicates(predicate),
 		).
-		Complete(c)
+		Complete(debug.Reconciler(ControllerName, tracing.Reconciler(ControllerName, c)))
 }
 
 // getWebhookProxyServices will list all webhook proxy related to endpointSlice (if exists).
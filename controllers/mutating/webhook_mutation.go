@@ -4,10 +4,13 @@ import (
 	"context"
 	"errors"
 
-	"github.com/go-logr/logr"
 	"github.com/CharlieR-o-o-t/eks-webhook-proxy/config"
+	"github.com/CharlieR-o-o-t/eks-webhook-proxy/pkg/debug"
 	"github.com/CharlieR-o-o-t/eks-webhook-proxy/pkg/proxy"
+	"github.com/CharlieR-o-o-t/eks-webhook-proxy/pkg/tracing"
 	"github.com/CharlieR-o-o-t/eks-webhook-proxy/pkg/utils"
+	"github.com/CharlieR-o-o-t/eks-webhook-proxy/pkg/webhookref"
+	"github.com/go-logr/logr"
 	admissionv1 "k8s.io/api/admissionregistration/v1"
 	"k8s.io/utils/ptr"
 
@@ -27,7 +30,7 @@ const (
 )
 
 type Controller struct {
-	Config *config.Config
+	Store  *config.Store
 	Client client.Client
 	Proxy  *proxy.Proxy
 	Log    logr.Logger
@@ -35,6 +38,7 @@ type Controller struct {
 
 func (c *Controller) Reconcile(ctx context.Context, req reconcile.Request) (reconcile.Result, error) {
 	log := c.Log.WithValues("name", req.String())
+	tracing.SetAttributes(ctx, tracing.Webhook(webhookref.KindMutating, req.Name))
 
 	var webhookServiceMap = make(map[*admissionv1.ServiceReference]struct{})
 
@@ -54,6 +58,10 @@ func (c *Controller) Reconcile(ctx context.Context, req reconcile.Request) (reco
 			// Probably webhook url is used, no need for proxy.
 			continue
 		}
+		if !webhookref.Selected(c.Store.Get().Proxy.SelectionMode, webhookObj, webhook.Name) {
+			log.V(5).Info("webhook is not selected, skipping", "webhook", webhook.Name)
+			continue
+		}
 
 		serviceRef := webhook.ClientConfig.Service
 		if serviceRef.Port == nil {
@@ -66,22 +74,16 @@ func (c *Controller) Reconcile(ctx context.Context, req reconcile.Request) (reco
 		serviceKey := types.NamespacedName{Name: webhookServiceRef.Name, Namespace: webhookServiceRef.Namespace}
 		log := log.WithValues("service", serviceKey)
 
-		serviceProxy, err := c.Proxy.EnsureServiceProxy(ctx, webhookServiceRef)
-		if err != nil {
+		if err := c.Proxy.EnsureWebhookService(ctx, webhookServiceRef); err != nil {
 			if errors.Is(err, proxy.ErrServiceNotFound) {
 				log.V(5).Info("webhook service not found, skipping")
 				continue
 			}
-			log.Error(err, "unable to create Proxy")
-			return reconcile.Result{}, err
-		}
-		if err := c.Proxy.EnsureProxyEndpointSlices(ctx, serviceProxy); err != nil {
-			log.Error(err, "unable to create proxy EndpointSlices")
-			return reconcile.Result{}, err
-		}
-
-		if err := c.Proxy.UnbindPodEndpoints(ctx, webhookServiceRef); err != nil {
-			log.Error(err, "unable to unbind Pod Endpoints from webhook service")
+			if errors.Is(err, proxy.ErrServiceNotProxied) {
+				log.V(5).Info("webhook service is not proxied, skipping")
+				continue
+			}
+			log.Error(err, "unable to proxy webhook service")
 			return reconcile.Result{}, err
 		}
 	}
@@ -92,7 +94,7 @@ func (c *Controller) Reconcile(ctx context.Context, req reconcile.Request) (reco
 // SetupWithManager sets up the controller with the Manager.
 func (r *Controller) SetupWithManager(mgr ctrl.Manager) error {
 
-	// Contains webhook service.
+	// Contains selected webhook with service.
 	predicateMutating := predicate.NewPredicateFuncs(func(obj client.Object) bool {
 		webhook := obj.(*admissionv1.MutatingWebhookConfiguration)
 
@@ -101,7 +103,7 @@ func (r *Controller) SetupWithManager(mgr ctrl.Manager) error {
 		}
 
 		for _, mutation := range webhook.Webhooks {
-			if mutation.ClientConfig.Service != nil {
+			if mutation.ClientConfig.Service != nil && webhookref.Selected(r.Store.Get().Proxy.SelectionMode, webhook, mutation.Name) {
 				return true
 			}
 		}
@@ -115,5 +117,5 @@ func (r *Controller) SetupWithManager(mgr ctrl.Manager) error {
 			&handler.EnqueueRequestForObject{},
 			builder.WithPredicates(predicateMutating),
 		).
-		Complete(r)
+		Complete(debug.Reconciler(ControllerName, tracing.Reconciler(ControllerName, r)))
 }
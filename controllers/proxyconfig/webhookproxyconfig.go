@@ -0,0 +1,183 @@
+package proxyconfig
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+
+	"github.com/CharlieR-o-o-t/eks-webhook-proxy/api/v1alpha1"
+	"github.com/CharlieR-o-o-t/eks-webhook-proxy/config"
+	"github.com/CharlieR-o-o-t/eks-webhook-proxy/pkg/debug"
+	"github.com/CharlieR-o-o-t/eks-webhook-proxy/pkg/proxy"
+	"github.com/CharlieR-o-o-t/eks-webhook-proxy/pkg/tracing"
+	"github.com/go-logr/logr"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+const (
+	ControllerName = "webhookproxyconfig-controller"
+)
+
+// Controller applies WebhookProxyConfig on top of environment defaults
+// and re-reconciles all proxies when configuration is changed.
+type Controller struct {
+	Store  *config.Store
+	Client client.Client
+	Proxy  *proxy.Proxy
+	Log    logr.Logger
+
+	// applied is the configuration of the last successful pass over all proxies.
+	// Store is updated before the pass, so the pass is retried until it succeeds.
+	applied *config.Config
+}
+
+func (c *Controller) Reconcile(ctx context.Context, req reconcile.Request) (reconcile.Result, error) {
+	log := c.Log.WithValues("name", req.Name)
+
+	desired := c.Store.Defaults()
+
+	var proxyConfig = new(v1alpha1.WebhookProxyConfig)
+	if err := c.Client.Get(ctx, req.NamespacedName, proxyConfig); err != nil {
+		if !apierrors.IsNotFound(err) {
+			log.Error(err, "unable to get WebhookProxyConfig")
+			return reconcile.Result{}, err
+		}
+		// config deleted, fallback to defaults.
+		proxyConfig = nil
+	}
+
+	if proxyConfig != nil {
+		merged, err := desiredConfig(c.Store.Defaults(), proxyConfig)
+		if err != nil {
+			log.Error(err, "WebhookProxyConfig is invalid, keeping current configuration")
+			return reconcile.Result{}, c.updateStatus(ctx, proxyConfig, metav1.ConditionFalse, "Invalid", err.Error())
+		}
+		desired = merged
+	}
+
+	if !reflect.DeepEqual(desired, c.applied) {
+		c.Store.Set(desired)
+		log.Info("configuration has been changed, reconciling all proxies")
+
+		if err := c.Proxy.EnsureAllWebhookServices(ctx); err != nil {
+			log.Error(err, "unable to reconcile proxies")
+			if proxyConfig != nil {
+				if err := c.updateStatus(ctx, proxyConfig, metav1.ConditionFalse, "ApplyFailed", err.Error()); err != nil {
+					log.Error(err, "unable to update WebhookProxyConfig status")
+				}
+			}
+			return reconcile.Result{}, err
+		}
+		c.applied = desired
+	}
+
+	if proxyConfig == nil {
+		return reconcile.Result{}, nil
+	}
+	return reconcile.Result{}, c.updateStatus(ctx, proxyConfig, metav1.ConditionTrue, "Applied", "configuration is applied")
+}
+
+func (c *Controller) updateStatus(ctx context.Context, proxyConfig *v1alpha1.WebhookProxyConfig, status metav1.ConditionStatus, reason, message string) error {
+	changed := meta.SetStatusCondition(&proxyConfig.Status.Conditions, metav1.Condition{
+		Type:               v1alpha1.ConditionAccepted,
+		Status:             status,
+		Reason:             reason,
+		Message:            message,
+		ObservedGeneration: proxyConfig.Generation,
+	})
+	if !changed && proxyConfig.Status.ObservedGeneration == proxyConfig.Generation {
+		return nil
+	}
+
+	proxyConfig.Status.ObservedGeneration = proxyConfig.Generation
+	if err := c.Client.Status().Update(ctx, proxyConfig); err != nil {
+		return fmt.Errorf("unable to update WebhookProxyConfig status, %w", err)
+	}
+	return nil
+}
+
+// Load applies WebhookProxyConfig to the store before the manager is started,
+// so controllers never reconcile with environment defaults when the configuration exists.
+// Invalid configuration keeps defaults, it is reported in status by the controller.
+func Load(ctx context.Context, reader client.Reader, store *config.Store, log logr.Logger) error {
+	var proxyConfig = new(v1alpha1.WebhookProxyConfig)
+	if err := reader.Get(ctx, types.NamespacedName{Name: v1alpha1.WebhookProxyConfigName}, proxyConfig); err != nil {
+		if apierrors.IsNotFound(err) || meta.IsNoMatchError(err) {
+			return nil
+		}
+		return fmt.Errorf("unable to get WebhookProxyConfig, %w", err)
+	}
+
+	desired, err := desiredConfig(store.Defaults(), proxyConfig)
+	if err != nil {
+		log.Error(err, "WebhookProxyConfig is invalid, starting with defaults")
+		return nil
+	}
+	store.Set(desired)
+	log.Info("WebhookProxyConfig is loaded")
+	return nil
+}
+
+// desiredConfig returns validated defaults overridden by WebhookProxyConfig.
+func desiredConfig(defaults *config.Config, proxyConfig *v1alpha1.WebhookProxyConfig) (*config.Config, error) {
+	merged, err := Apply(defaults, &proxyConfig.Spec)
+	if err != nil {
+		return nil, err
+	}
+	if err := config.Validate(merged); err != nil {
+		return nil, err
+	}
+	return merged, nil
+}
+
+// Apply returns defaults overridden by fields set in spec.
+func Apply(defaults *config.Config, spec *v1alpha1.WebhookProxyConfigSpec) (*config.Config, error) {
+	cfg := *defaults
+
+	if spec.Restricted != nil {
+		cfg.Proxy.Restricted = *spec.Restricted
+	}
+	if spec.AllowedCIDRs != nil {
+		cfg.Proxy.AllowedSrcCIDRs = spec.AllowedCIDRs
+	}
+	if spec.NodeSelector != nil {
+		selector, err := metav1.LabelSelectorAsSelector(spec.NodeSelector)
+		if err != nil {
+			return nil, fmt.Errorf("invalid node selector, %w", err)
+		}
+		cfg.Proxy.NodeSelector = selector.String()
+	}
+	if spec.ExcludedNamespaces != nil {
+		cfg.Proxy.ExcludedNamespaces = spec.ExcludedNamespaces
+	}
+	if spec.PolicyBackend != "" {
+		cfg.Proxy.PolicyBackend = spec.PolicyBackend
+	}
+
+	return &cfg, nil
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (c *Controller) SetupWithManager(mgr ctrl.Manager) error {
+	predicateDefault := predicate.NewPredicateFuncs(func(obj client.Object) bool {
+		return obj.GetName() == v1alpha1.WebhookProxyConfigName
+	})
+
+	return ctrl.NewControllerManagedBy(mgr).
+		Named(ControllerName).
+		Watches(
+			&v1alpha1.WebhookProxyConfig{},
+			&handler.EnqueueRequestForObject{},
+			builder.WithPredicates(predicateDefault, predicate.GenerationChangedPredicate{}),
+		).
+		Complete(debug.Reconciler(ControllerName, tracing.Reconciler(ControllerName, c)))
+}
@@ -0,0 +1,124 @@
+package securitygroup
+
+import (
+	"context"
+	"time"
+
+	"github.com/CharlieR-o-o-t/eks-webhook-proxy/config"
+	"github.com/CharlieR-o-o-t/eks-webhook-proxy/pkg/debug"
+	"github.com/CharlieR-o-o-t/eks-webhook-proxy/pkg/securitygroup"
+	"github.com/CharlieR-o-o-t/eks-webhook-proxy/pkg/tracing"
+	"github.com/CharlieR-o-o-t/eks-webhook-proxy/pkg/utils"
+	"github.com/go-logr/logr"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/util/workqueue"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+)
+
+const (
+	ControllerName = "securitygroup-controller"
+
+	// resyncInterval revokes rules of proxy services deleted while the controller was not running
+	// and restores rules changed outside of the controller.
+	resyncInterval = 10 * time.Minute
+)
+
+// Controller keeps node security group ingress rules in sync with NodePorts of all proxy services.
+// Every proxy service change is folded into a single reconcile request, which is also
+// enqueued on start and resynced periodically.
+type Controller struct {
+	Store    *config.Store
+	Client   client.Client
+	Provider securitygroup.Provider
+	Log      logr.Logger
+}
+
+func (c *Controller) Reconcile(ctx context.Context, req reconcile.Request) (reconcile.Result, error) {
+	cfg := c.Store.Get()
+	sgConfig := cfg.SecurityGroup
+	// Rules are only reported in observe-only mode, EC2 writes are not covered by the API client wrapper.
+	sgConfig.ReportOnly = sgConfig.ReportOnly || cfg.Proxy.ObserveOnly
+	log := c.Log.WithValues("securityGroup", sgConfig.NodeGroupID, "reportOnly", sgConfig.ReportOnly)
+
+	var serviceList = new(v1.ServiceList)
+	if err := c.Client.List(ctx, serviceList,
+		client.MatchingLabels{utils.LabelManagedBy: utils.ControllerName},
+	); err != nil {
+		log.Error(err, "unable to list proxy services")
+		return reconcile.Result{}, err
+	}
+
+	desired := securitygroup.DesiredRules(serviceList.Items, sgConfig.ClusterGroupID)
+
+	current, err := c.Provider.ListIngressRules(ctx, sgConfig.NodeGroupID)
+	if err != nil {
+		log.Error(err, "unable to list security group rules")
+		return reconcile.Result{}, err
+	}
+
+	authorize, revoke := securitygroup.Diff(current, desired)
+
+	if sgConfig.ReportOnly {
+		for _, rule := range desired {
+			log.Info("required ingress rule", "rule", rule.String())
+		}
+		for _, rule := range authorize {
+			log.Info("would authorize ingress rule", "rule", rule.String())
+		}
+		for _, rule := range revoke {
+			log.Info("would revoke ingress rule", "rule", rule.String())
+		}
+		return reconcile.Result{RequeueAfter: resyncInterval}, nil
+	}
+
+	if err := c.Provider.AuthorizeIngress(ctx, sgConfig.NodeGroupID, authorize); err != nil {
+		log.Error(err, "unable to authorize ingress rules")
+		return reconcile.Result{}, err
+	}
+	if err := c.Provider.RevokeIngress(ctx, sgConfig.NodeGroupID, revoke); err != nil {
+		log.Error(err, "unable to revoke ingress rules")
+		return reconcile.Result{}, err
+	}
+
+	log.V(4).Info("security group rules have been reconciled", "authorized", len(authorize), "revoked", len(revoke))
+	return reconcile.Result{RequeueAfter: resyncInterval}, nil
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (c *Controller) SetupWithManager(mgr ctrl.Manager) error {
+	predicateProxy := predicate.NewPredicateFuncs(func(obj client.Object) bool {
+		return obj.GetLabels()[utils.LabelManagedBy] == utils.ControllerName
+	})
+
+	// All proxy services are folded into the same request.
+	enqueueAll := handler.EnqueueRequestsFromMapFunc(func(ctx context.Context, obj client.Object) []reconcile.Request {
+		return []reconcile.Request{c.request()}
+	})
+
+	// Stale rules are revoked on start, even when no proxy service exists.
+	enqueueOnStart := source.Func(func(ctx context.Context, queue workqueue.TypedRateLimitingInterface[reconcile.Request]) error {
+		queue.Add(c.request())
+		return nil
+	})
+
+	return ctrl.NewControllerManagedBy(mgr).
+		Named(ControllerName).
+		Watches(
+			&v1.Service{},
+			enqueueAll,
+			builder.WithPredicates(predicateProxy),
+		).
+		WatchesRawSource(enqueueOnStart).
+		Complete(debug.Reconciler(ControllerName, tracing.Reconciler(ControllerName, c)))
+}
+
+func (c *Controller) request() reconcile.Request {
+	return reconcile.Request{NamespacedName: types.NamespacedName{Name: c.Store.Get().SecurityGroup.NodeGroupID}}
+}
@@ -0,0 +1,188 @@
+package securitygroup
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/CharlieR-o-o-t/eks-webhook-proxy/config"
+	"github.com/CharlieR-o-o-t/eks-webhook-proxy/pkg/securitygroup"
+	"github.com/CharlieR-o-o-t/eks-webhook-proxy/pkg/utils"
+	"github.com/go-logr/logr"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+const (
+	nodeGroupID    = "sg-node"
+	clusterGroupID = "sg-cluster"
+)
+
+var request = reconcile.Request{NamespacedName: types.NamespacedName{Name: nodeGroupID}}
+
+func newService(name string, labels map[string]string, nodePort int32) *v1.Service {
+	return &v1.Service{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "webhooks", Name: name, Labels: labels},
+		Spec: v1.ServiceSpec{
+			Type:  v1.ServiceTypeNodePort,
+			Ports: []v1.ServicePort{{Port: 443, NodePort: nodePort, Protocol: v1.ProtocolTCP}},
+		},
+	}
+}
+
+func newController(cfg *config.Config, provider securitygroup.Provider, objects ...client.Object) *Controller {
+	cfg.SecurityGroup = config.SecurityGroup{
+		Enabled:        true,
+		NodeGroupID:    nodeGroupID,
+		ClusterGroupID: clusterGroupID,
+		ReportOnly:     cfg.SecurityGroup.ReportOnly,
+	}
+	return &Controller{
+		Store:    config.NewStore(cfg),
+		Client:   fake.NewClientBuilder().WithObjects(objects...).Build(),
+		Provider: provider,
+		Log:      logr.Discard(),
+	}
+}
+
+func proxyObjects() []client.Object {
+	managed := map[string]string{utils.LabelManagedBy: utils.ControllerName}
+	return []client.Object{
+		newService("webhook-proxy-a", managed, 30443),
+		newService("webhook-proxy-b", managed, 30100),
+		// Services not managed by the controller do not get rules.
+		newService("other", nil, 30200),
+	}
+}
+
+func TestReconcile(t *testing.T) {
+	ctx := context.Background()
+	provider := securitygroup.NewFake()
+	stale := securitygroup.Rule{Protocol: "TCP", Port: 31000, SourceGroupID: clusterGroupID}
+	if err := provider.AuthorizeIngress(ctx, nodeGroupID, []securitygroup.Rule{stale}); err != nil {
+		t.Fatal(err)
+	}
+
+	c := newController(&config.Config{}, provider, proxyObjects()...)
+	if _, err := c.Reconcile(ctx, request); err != nil {
+		t.Fatalf("Reconcile() error = %v", err)
+	}
+
+	rules, err := provider.ListIngressRules(ctx, nodeGroupID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []securitygroup.Rule{
+		{Protocol: "TCP", Port: 30100, SourceGroupID: clusterGroupID, Owned: true},
+		{Protocol: "TCP", Port: 30443, SourceGroupID: clusterGroupID, Owned: true},
+	}
+	if !reflect.DeepEqual(rules, want) {
+		t.Errorf("rules = %v, want %v", rules, want)
+	}
+
+	// Proxy service is deleted, its rule is revoked.
+	if err := c.Client.Delete(ctx, newService("webhook-proxy-b", nil, 0)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Reconcile(ctx, request); err != nil {
+		t.Fatalf("Reconcile() error = %v", err)
+	}
+	rules, err = provider.ListIngressRules(ctx, nodeGroupID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := want[1:]; !reflect.DeepEqual(rules, want) {
+		t.Errorf("rules after delete = %v, want %v", rules, want)
+	}
+
+	// Last proxy service is deleted, all rules are revoked.
+	if err := c.Client.Delete(ctx, newService("webhook-proxy-a", nil, 0)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Reconcile(ctx, request); err != nil {
+		t.Fatalf("Reconcile() error = %v", err)
+	}
+	rules, err = provider.ListIngressRules(ctx, nodeGroupID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rules) != 0 {
+		t.Errorf("rules after last delete = %v, want none", rules)
+	}
+}
+
+func TestReconcileKeepsRulesNotOwned(t *testing.T) {
+	ctx := context.Background()
+	provider := securitygroup.NewFake()
+	manual := []securitygroup.Rule{
+		// Same rule as required by a proxy service.
+		{Protocol: "TCP", Port: 30443, SourceGroupID: clusterGroupID},
+		{Protocol: "TCP", Port: 31000, SourceGroupID: clusterGroupID},
+	}
+	provider.AddRules(nodeGroupID, manual)
+
+	c := newController(&config.Config{}, provider, proxyObjects()...)
+	if _, err := c.Reconcile(ctx, request); err != nil {
+		t.Fatalf("Reconcile() error = %v", err)
+	}
+
+	rules, err := provider.ListIngressRules(ctx, nodeGroupID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []securitygroup.Rule{
+		{Protocol: "TCP", Port: 30100, SourceGroupID: clusterGroupID, Owned: true},
+		manual[0],
+		manual[1],
+	}
+	if !reflect.DeepEqual(rules, want) {
+		t.Errorf("rules = %v, want %v", rules, want)
+	}
+}
+
+func TestReconcileReportOnly(t *testing.T) {
+	stale := securitygroup.Rule{Protocol: "TCP", Port: 31000, SourceGroupID: clusterGroupID}
+
+	tests := []struct {
+		name string
+		cfg  *config.Config
+	}{
+		{
+			name: "report only",
+			cfg:  &config.Config{SecurityGroup: config.SecurityGroup{ReportOnly: true}},
+		},
+		{
+			name: "observe only",
+			cfg:  &config.Config{Proxy: config.Proxy{ObserveOnly: true}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			provider := securitygroup.NewFake()
+			if err := provider.AuthorizeIngress(ctx, nodeGroupID, []securitygroup.Rule{stale}); err != nil {
+				t.Fatal(err)
+			}
+
+			c := newController(tt.cfg, provider, proxyObjects()...)
+			if _, err := c.Reconcile(ctx, request); err != nil {
+				t.Fatalf("Reconcile() error = %v", err)
+			}
+
+			rules, err := provider.ListIngressRules(ctx, nodeGroupID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			stale := stale
+			stale.Owned = true
+			if want := []securitygroup.Rule{stale}; !reflect.DeepEqual(rules, want) {
+				t.Errorf("rules = %v, want unchanged %v", rules, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,166 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"time"
+
+	"github.com/CharlieR-o-o-t/eks-webhook-proxy/config"
+	"github.com/CharlieR-o-o-t/eks-webhook-proxy/pkg/debug"
+	"github.com/CharlieR-o-o-t/eks-webhook-proxy/pkg/proxy"
+	"github.com/CharlieR-o-o-t/eks-webhook-proxy/pkg/tracing"
+	"github.com/CharlieR-o-o-t/eks-webhook-proxy/pkg/utils"
+	"github.com/CharlieR-o-o-t/eks-webhook-proxy/pkg/webhookref"
+	"github.com/go-logr/logr"
+	admissionv1 "k8s.io/api/admissionregistration/v1"
+	v1 "k8s.io/api/core/v1"
+	apiextv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	"k8s.io/client-go/util/workqueue"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+const (
+	ControllerName = "service-controller"
+
+	// releaseRequeueInterval is the interval of pod endpoints checks of released service.
+	releaseRequeueInterval = 5 * time.Second
+)
+
+// Controller re-ensures webhook service when its proxy settings are changed by Service
+// or Namespace annotations, webhook configurations are not changed in that case.
+// It also releases webhook service when selected webhooks stop calling it: webhook controllers
+// only see selected webhooks, so deselection is handled here.
+// Requests are keyed by the webhook (origin) service.
+type Controller struct {
+	Store  *config.Store
+	Client client.Client
+	Proxy  *proxy.Proxy
+	Log    logr.Logger
+}
+
+func (c *Controller) Reconcile(ctx context.Context, req reconcile.Request) (reconcile.Result, error) {
+	log := c.Log.WithValues("service", req.NamespacedName)
+
+	webhooks, err := webhookref.ListForService(ctx, c.Client, c.Store.Get().Proxy.SelectionMode, req.NamespacedName)
+	if err != nil {
+		log.Error(err, "unable to list webhook references")
+		return reconcile.Result{}, err
+	}
+
+	if len(webhooks) == 0 {
+		if err := c.Proxy.ReleaseWebhookService(ctx, req.NamespacedName); err != nil {
+			if errors.Is(err, proxy.ErrPodEndpointsNotReady) {
+				log.V(3).Info("waiting for pod endpoints of released service")
+				return reconcile.Result{RequeueAfter: releaseRequeueInterval}, nil
+			}
+			log.Error(err, "unable to release webhook service")
+			return reconcile.Result{}, err
+		}
+		return reconcile.Result{}, nil
+	}
+
+	serviceRef := &admissionv1.ServiceReference{Namespace: req.Namespace, Name: req.Name}
+	if err := c.Proxy.EnsureWebhookService(ctx, serviceRef); err != nil {
+		if errors.Is(err, proxy.ErrServiceNotFound) || errors.Is(err, proxy.ErrServiceNotProxied) {
+			log.V(5).Info("webhook service is not proxied, skipping", "reason", err.Error())
+			return reconcile.Result{}, nil
+		}
+		log.Error(err, "unable to proxy webhook service")
+		return reconcile.Result{}, err
+	}
+
+	return reconcile.Result{}, nil
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (c *Controller) SetupWithManager(mgr ctrl.Manager) error {
+	// Webhook services, proxy services are owned by the controller.
+	predicateOrigin := predicate.NewPredicateFuncs(func(obj client.Object) bool {
+		return obj.GetLabels()[utils.LabelManagedBy] != utils.ControllerName
+	})
+
+	// Settings are read from annotations and from the legacy label.
+	predicateSettings := predicate.Funcs{
+		UpdateFunc: func(e event.UpdateEvent) bool {
+			return !reflect.DeepEqual(e.ObjectOld.GetAnnotations(), e.ObjectNew.GetAnnotations()) ||
+				!reflect.DeepEqual(e.ObjectOld.GetLabels(), e.ObjectNew.GetLabels())
+		},
+		DeleteFunc: func(event.DeleteEvent) bool { return false },
+	}
+
+	// Namespace is only watched for annotation changes.
+	predicateNamespace := predicate.Funcs{
+		CreateFunc: func(event.CreateEvent) bool { return false },
+		UpdateFunc: func(e event.UpdateEvent) bool {
+			return !reflect.DeepEqual(e.ObjectOld.GetAnnotations(), e.ObjectNew.GetAnnotations())
+		},
+		DeleteFunc:  func(event.DeleteEvent) bool { return false },
+		GenericFunc: func(event.GenericEvent) bool { return false },
+	}
+
+	return ctrl.NewControllerManagedBy(mgr).
+		Named(ControllerName).
+		Watches(
+			&v1.Service{},
+			&handler.EnqueueRequestForObject{},
+			builder.WithPredicates(predicateOrigin, predicateSettings),
+		).
+		Watches(
+			&v1.Namespace{},
+			handler.EnqueueRequestsFromMapFunc(c.mapNamespace),
+			builder.WithPredicates(predicateNamespace),
+		).
+		Watches(&admissionv1.MutatingWebhookConfiguration{}, c.deselected()).
+		Watches(&admissionv1.ValidatingWebhookConfiguration{}, c.deselected()).
+		Watches(&apiextv1.CustomResourceDefinition{}, c.deselected()).
+		Complete(debug.Reconciler(ControllerName, tracing.Reconciler(ControllerName, c)))
+}
+
+// mapNamespace maps namespace to webhook services in it.
+func (c *Controller) mapNamespace(ctx context.Context, obj client.Object) []reconcile.Request {
+	services, err := webhookref.ListSelected(ctx, c.Client, c.Store.Get().Proxy.SelectionMode)
+	if err != nil {
+		c.Log.Error(err, "unable to list webhook references", "namespace", obj.GetName())
+		return nil
+	}
+
+	var requests []reconcile.Request
+	for key := range services {
+		if key.Namespace == obj.GetName() {
+			requests = append(requests, reconcile.Request{NamespacedName: key})
+		}
+	}
+	return requests
+}
+
+// deselected enqueues services which were called by selected webhooks of the object before
+// the update or delete and are not called any more.
+func (c *Controller) deselected() handler.EventHandler {
+	return handler.Funcs{
+		UpdateFunc: func(ctx context.Context, e event.UpdateEvent, q workqueue.TypedRateLimitingInterface[reconcile.Request]) {
+			mode := c.Store.Get().Proxy.SelectionMode
+			oldServices, newServices := make(webhookref.ServiceMap), make(webhookref.ServiceMap)
+			oldServices.AddSelected(e.ObjectOld, mode)
+			newServices.AddSelected(e.ObjectNew, mode)
+			for key := range oldServices {
+				if _, ok := newServices[key]; !ok {
+					q.Add(reconcile.Request{NamespacedName: key})
+				}
+			}
+		},
+		DeleteFunc: func(ctx context.Context, e event.DeleteEvent, q workqueue.TypedRateLimitingInterface[reconcile.Request]) {
+			services := make(webhookref.ServiceMap)
+			services.AddSelected(e.Object, c.Store.Get().Proxy.SelectionMode)
+			for key := range services {
+				q.Add(reconcile.Request{NamespacedName: key})
+			}
+		},
+	}
+}
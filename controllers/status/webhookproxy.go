@@ -0,0 +1,214 @@
+package status
+
+import (
+	"context"
+
+	"github.com/CharlieR-o-o-t/eks-webhook-proxy/api/v1alpha1"
+	"github.com/CharlieR-o-o-t/eks-webhook-proxy/config"
+	"github.com/CharlieR-o-o-t/eks-webhook-proxy/pkg/debug"
+	"github.com/CharlieR-o-o-t/eks-webhook-proxy/pkg/metrics"
+	"github.com/CharlieR-o-o-t/eks-webhook-proxy/pkg/proxy"
+	"github.com/CharlieR-o-o-t/eks-webhook-proxy/pkg/tracing"
+	"github.com/CharlieR-o-o-t/eks-webhook-proxy/pkg/utils"
+	"github.com/CharlieR-o-o-t/eks-webhook-proxy/pkg/webhookref"
+	"github.com/go-logr/logr"
+	admissionv1 "k8s.io/api/admissionregistration/v1"
+	v1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
+	apiextv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+const (
+	ControllerName = "webhookproxy-status-controller"
+)
+
+// Controller maintains WebhookProxy object for every proxied webhook service.
+// Requests are keyed by the webhook (origin) service.
+type Controller struct {
+	Store  *config.Store
+	Client client.Client
+	Proxy  *proxy.Proxy
+	Log    logr.Logger
+}
+
+func (c *Controller) Reconcile(ctx context.Context, req reconcile.Request) (reconcile.Result, error) {
+	log := c.Log.WithValues("service", req.NamespacedName)
+
+	var serviceOrigin = new(v1.Service)
+	if err := c.Client.Get(ctx, req.NamespacedName, serviceOrigin); err != nil {
+		// service deleted, WebhookProxy is garbage collected by owner reference.
+		if apierrors.IsNotFound(err) {
+			metrics.DeleteService(req.NamespacedName)
+			c.Proxy.ForgetService(req.NamespacedName)
+			return reconcile.Result{}, nil
+		}
+		log.Error(err, "unable to get webhook service")
+		return reconcile.Result{}, err
+	}
+
+	// Proxy service has no WebhookProxy of its own.
+	if serviceOrigin.Labels[utils.LabelManagedBy] == utils.ControllerName {
+		return reconcile.Result{}, nil
+	}
+
+	if serviceOrigin.Spec.Type != v1.ServiceTypeClusterIP {
+		log.V(5).Info("service is not ClusterIP, it is not proxied")
+		return reconcile.Result{}, c.forget(ctx, req.NamespacedName)
+	}
+
+	webhooks, err := webhookref.ListForService(ctx, c.Client, c.Store.Get().Proxy.SelectionMode, req.NamespacedName)
+	if err != nil {
+		log.Error(err, "unable to list webhook references")
+		return reconcile.Result{}, err
+	}
+
+	if len(webhooks) == 0 {
+		log.V(5).Info("service is not referenced by selected webhooks, it is not proxied")
+		return reconcile.Result{}, c.forget(ctx, req.NamespacedName)
+	}
+
+	status, err := c.Proxy.BuildStatus(ctx, serviceOrigin, webhooks)
+	if err != nil {
+		log.Error(err, "unable to build webhook proxy status")
+		return reconcile.Result{}, err
+	}
+
+	if err := c.Proxy.CheckCertificates(ctx, serviceOrigin, status); err != nil {
+		log.Error(err, "unable to check webhook certificates")
+		return reconcile.Result{}, err
+	}
+
+	reportMetrics(req.NamespacedName, status)
+
+	if err := c.Proxy.EnsureWebhookProxy(ctx, serviceOrigin, status); err != nil {
+		log.Error(err, "unable to ensure webhook proxy")
+		return reconcile.Result{}, err
+	}
+
+	// Certificates expire without any object change.
+	if certCheck := c.Store.Get().CertCheck; certCheck.Enabled {
+		return reconcile.Result{RequeueAfter: certCheck.Interval}, nil
+	}
+	return reconcile.Result{}, nil
+}
+
+// forget deletes WebhookProxy, metrics and cached state of webhook service which is not proxied any more.
+func (c *Controller) forget(ctx context.Context, service types.NamespacedName) error {
+	metrics.DeleteService(service)
+	c.Proxy.ForgetService(service)
+
+	if err := c.Proxy.DeleteWebhookProxy(ctx, service); err != nil {
+		c.Log.Error(err, "unable to delete webhook proxy", "service", service)
+		return err
+	}
+	return nil
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (c *Controller) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		Named(ControllerName).
+		Watches(
+			&v1alpha1.WebhookProxy{},
+			handler.EnqueueRequestsFromMapFunc(mapWebhookProxy),
+		).
+		Watches(
+			&v1.Service{},
+			handler.EnqueueRequestsFromMapFunc(mapService),
+		).
+		Watches(
+			&discoveryv1.EndpointSlice{},
+			handler.EnqueueRequestsFromMapFunc(mapProxyEndpointSlice),
+		).
+		Watches(
+			&admissionv1.MutatingWebhookConfiguration{},
+			handler.EnqueueRequestsFromMapFunc(func(ctx context.Context, obj client.Object) []reconcile.Request {
+				services := make(webhookref.ServiceMap)
+				services.AddMutating(obj.(*admissionv1.MutatingWebhookConfiguration))
+				return toRequests(services)
+			}),
+		).
+		Watches(
+			&admissionv1.ValidatingWebhookConfiguration{},
+			handler.EnqueueRequestsFromMapFunc(func(ctx context.Context, obj client.Object) []reconcile.Request {
+				services := make(webhookref.ServiceMap)
+				services.AddValidating(obj.(*admissionv1.ValidatingWebhookConfiguration))
+				return toRequests(services)
+			}),
+		).
+		Watches(
+			&apiextv1.CustomResourceDefinition{},
+			handler.EnqueueRequestsFromMapFunc(func(ctx context.Context, obj client.Object) []reconcile.Request {
+				services := make(webhookref.ServiceMap)
+				services.AddCRD(obj.(*apiextv1.CustomResourceDefinition))
+				return toRequests(services)
+			}),
+		).
+		Complete(debug.Reconciler(ControllerName, tracing.Reconciler(ControllerName, c)))
+}
+
+func reportMetrics(service types.NamespacedName, status *v1alpha1.WebhookProxyStatus) {
+	mode := metrics.ModeUnrestricted
+	if status.Restricted {
+		mode = metrics.ModeRestricted
+	}
+	metrics.SetServiceMode(service, mode)
+
+	metrics.NodeEndpoints.WithLabelValues(service.Namespace, service.Name).Set(float64(status.TotalEndpoints))
+	metrics.ReadyNodeEndpoints.WithLabelValues(service.Namespace, service.Name).Set(float64(status.ReadyEndpoints))
+	metrics.SetCutoverPhase(service, string(status.Phase), []string{
+		string(v1alpha1.PhasePending),
+		string(v1alpha1.PhaseProxyReady),
+		string(v1alpha1.PhaseCutOver),
+	})
+}
+
+func mapWebhookProxy(ctx context.Context, obj client.Object) []reconcile.Request {
+	webhookProxy := obj.(*v1alpha1.WebhookProxy)
+	return []reconcile.Request{
+		{NamespacedName: types.NamespacedName{Namespace: webhookProxy.Namespace, Name: webhookProxy.Spec.ServiceName}},
+	}
+}
+
+// mapService maps both webhook service and its proxy service to the webhook service.
+func mapService(ctx context.Context, obj client.Object) []reconcile.Request {
+	name := obj.GetName()
+	if obj.GetLabels()[utils.LabelManagedBy] == utils.ControllerName {
+		origin, ok := obj.GetLabels()[utils.LabelServiceProxyOf]
+		if !ok {
+			return nil
+		}
+		name = origin
+	}
+	return []reconcile.Request{
+		{NamespacedName: types.NamespacedName{Namespace: obj.GetNamespace(), Name: name}},
+	}
+}
+
+// mapProxyEndpointSlice maps proxy endpoint slice to the webhook service it serves.
+func mapProxyEndpointSlice(ctx context.Context, obj client.Object) []reconcile.Request {
+	if obj.GetLabels()[utils.LabelEdpointSliceManagedBy] != utils.ControllerName {
+		return nil
+	}
+	serviceName, ok := obj.GetLabels()[utils.LabelEndpointSliceServiceName]
+	if !ok {
+		return nil
+	}
+	return []reconcile.Request{
+		{NamespacedName: types.NamespacedName{Namespace: obj.GetNamespace(), Name: serviceName}},
+	}
+}
+
+func toRequests(services webhookref.ServiceMap) []reconcile.Request {
+	requests := make([]reconcile.Request, 0, len(services))
+	for key := range services {
+		requests = append(requests, reconcile.Request{NamespacedName: key})
+	}
+	return requests
+}
@@ -3,9 +3,12 @@ package validating
 import (
 	"context"
 	"errors"
-	"github.com/go-logr/logr"
+	"github.com/CharlieR-o-o-t/eks-webhook-proxy/pkg/debug"
 	"github.com/CharlieR-o-o-t/eks-webhook-proxy/pkg/proxy"
+	"github.com/CharlieR-o-o-t/eks-webhook-proxy/pkg/tracing"
 	"github.com/CharlieR-o-o-t/eks-webhook-proxy/pkg/utils"
+	"github.com/CharlieR-o-o-t/eks-webhook-proxy/pkg/webhookref"
+	"github.com/go-logr/logr"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/utils/ptr"
@@ -27,25 +30,26 @@ const (
 )
 
 type Controller struct {
-	Config *config.Config
+	Store  *config.Store
 	Client client.Client
 	Proxy  *proxy.Proxy
 	Log    logr.Logger
 }
 
-func New(config *config.Config, client client.Client, proxy *proxy.Proxy) *Controller {
+func New(store *config.Store, client client.Client, proxy *proxy.Proxy) *Controller {
 	return &Controller{
-		Config: config,
+		Store:  store,
 		Client: client,
 		Log:    log.Log.WithName("validating-controller"),
 	}
 }
 func (c *Controller) Reconcile(ctx context.Context, req reconcile.Request) (reconcile.Result, error) {
 	log := c.Log.WithValues("name", req.String())
+	tracing.SetAttributes(ctx, tracing.Webhook(webhookref.KindValidating, req.Name))
 
 	var webhookServiceMap = make(map[*admissionv1.ServiceReference]struct{})
 
-	webhookObj := new(admissionv1.MutatingWebhookConfiguration)
+	webhookObj := new(admissionv1.ValidatingWebhookConfiguration)
 	if err := c.Client.Get(ctx, types.NamespacedName{Name: req.Name}, webhookObj); err != nil {
 		// webhook deleted, do nothing.
 		if apierrors.IsNotFound(err) {
@@ -61,6 +65,10 @@ func (c *Controller) Reconcile(ctx context.Context, req reconcile.Request) (reco
 			// Probably webhook url is used, no need for proxy.
 			continue
 		}
+		if !webhookref.Selected(c.Store.Get().Proxy.SelectionMode, webhookObj, webhook.Name) {
+			log.V(5).Info("webhook is not selected, skipping", "webhook", webhook.Name)
+			continue
+		}
 
 		serviceRef := webhook.ClientConfig.Service
 		if serviceRef.Port == nil {
@@ -73,22 +81,16 @@ func (c *Controller) Reconcile(ctx context.Context, req reconcile.Request) (reco
 		serviceKey := types.NamespacedName{Name: webhookServiceRef.Name, Namespace: webhookServiceRef.Namespace}
 		log := log.WithValues("service", serviceKey)
 
-		serviceProxy, err := c.Proxy.EnsureServiceProxy(ctx, webhookServiceRef)
-		if err != nil {
+		if err := c.Proxy.EnsureWebhookService(ctx, webhookServiceRef); err != nil {
 			if errors.Is(err, proxy.ErrServiceNotFound) {
 				log.V(5).Info("webhook service not found, skipping")
 				continue
 			}
-			log.Error(err, "unable to create Proxy")
-			return reconcile.Result{}, err
-		}
-		if err := c.Proxy.EnsureProxyEndpointSlices(ctx, serviceProxy); err != nil {
-			log.Error(err, "unable to create proxy EndpointSlices")
-			return reconcile.Result{}, err
-		}
-
-		if err := c.Proxy.UnbindPodEndpoints(ctx, webhookServiceRef); err != nil {
-			log.Error(err, "unable to unbind Pod Endpoints from webhook service")
+			if errors.Is(err, proxy.ErrServiceNotProxied) {
+				log.V(5).Info("webhook service is not proxied, skipping")
+				continue
+			}
+			log.Error(err, "unable to proxy webhook service")
 			return reconcile.Result{}, err
 		}
 	}
@@ -99,7 +101,7 @@ func (c *Controller) Reconcile(ctx context.Context, req reconcile.Request) (reco
 // SetupWithManager sets up the controller with the Manager.
 func (c *Controller) SetupWithManager(mgr ctrl.Manager) error {
 
-	// Contains webhook service.
+	// Contains selected webhook with service.
 	predicateValidating := predicate.NewPredicateFuncs(func(obj client.Object) bool {
 		webhook := obj.(*admissionv1.ValidatingWebhookConfiguration)
 
@@ -108,7 +110,7 @@ func (c *Controller) SetupWithManager(mgr ctrl.Manager) error {
 		}
 
 		for _, validating := range webhook.Webhooks {
-			if validating.ClientConfig.Service != nil {
+			if validating.ClientConfig.Service != nil && webhookref.Selected(c.Store.Get().Proxy.SelectionMode, webhook, validating.Name) {
 				return true
 			}
 		}
@@ -122,5 +124,5 @@ func (c *Controller) SetupWithManager(mgr ctrl.Manager) error {
 			&handler.EnqueueRequestForObject{},
 			builder.WithPredicates(predicateValidating),
 		).
-		Complete(c)
+		Complete(debug.Reconciler(ControllerName, tracing.Reconciler(ControllerName, c)))
 }
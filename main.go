@@ -2,15 +2,34 @@
 package main
 
 import (
+	"context"
 	"flag"
+	"github.com/CharlieR-o-o-t/eks-webhook-proxy/api/v1alpha1"
+	apiservercontroller "github.com/CharlieR-o-o-t/eks-webhook-proxy/controllers/apiserver"
 	"github.com/CharlieR-o-o-t/eks-webhook-proxy/controllers/endpointslice"
 	"github.com/CharlieR-o-o-t/eks-webhook-proxy/controllers/mutating"
+	"github.com/CharlieR-o-o-t/eks-webhook-proxy/controllers/proxyconfig"
+	sgcontroller "github.com/CharlieR-o-o-t/eks-webhook-proxy/controllers/securitygroup"
+	servicecontroller "github.com/CharlieR-o-o-t/eks-webhook-proxy/controllers/service"
+	statuscontroller "github.com/CharlieR-o-o-t/eks-webhook-proxy/controllers/status"
 	"github.com/CharlieR-o-o-t/eks-webhook-proxy/controllers/validating"
+	"github.com/CharlieR-o-o-t/eks-webhook-proxy/pkg/canary"
+	"github.com/CharlieR-o-o-t/eks-webhook-proxy/pkg/cidrcache"
+	"github.com/CharlieR-o-o-t/eks-webhook-proxy/pkg/cli"
+	"github.com/CharlieR-o-o-t/eks-webhook-proxy/pkg/debug"
 	"github.com/CharlieR-o-o-t/eks-webhook-proxy/pkg/nodecache"
+	"github.com/CharlieR-o-o-t/eks-webhook-proxy/pkg/observe"
+	"github.com/CharlieR-o-o-t/eks-webhook-proxy/pkg/prober"
 	"github.com/CharlieR-o-o-t/eks-webhook-proxy/pkg/proxy"
+	"github.com/CharlieR-o-o-t/eks-webhook-proxy/pkg/readiness"
+	"github.com/CharlieR-o-o-t/eks-webhook-proxy/pkg/securitygroup"
+	"github.com/CharlieR-o-o-t/eks-webhook-proxy/pkg/tracing"
+	"github.com/CharlieR-o-o-t/eks-webhook-proxy/pkg/utils"
+	"github.com/CharlieR-o-o-t/eks-webhook-proxy/pkg/webhookref"
 	"k8s.io/klog/v2"
 	"os"
 
+	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/log"
 	metricsserver "sigs.k8s.io/controller-runtime/pkg/metrics/server"
 
@@ -39,14 +58,22 @@ var (
 func init() {
 	utilruntime.Must(clientgoscheme.AddToScheme(scheme))
 	utilruntime.Must(apiextensionsv1.AddToScheme(scheme))
+	utilruntime.Must(v1alpha1.AddToScheme(scheme))
 }
 
 func initFlags(fs *pflag.FlagSet) {
 	fs.StringVar(&metricsAddr, "metrics-bind-address", ":8080", "The address the metric endpoint binds to.")
 	fs.StringVar(&probeAddr, "health-probe-bind-address", ":8081", "The address the probe endpoint binds to.")
+	fs.BoolVar(&enableLeaderElection, "leader-elect", false,
+		"Enable leader election, only the elected replica reconciles and is reported ready.")
 }
 
 func main() {
+	// As kubectl plugin the binary only runs subcommands, usage is printed without one.
+	if cli.IsPlugin(os.Args[0]) || len(os.Args) > 1 && cli.IsCommand(os.Args[1]) {
+		os.Exit(cli.Run(context.Background(), os.Args[1:]))
+	}
+
 	klog.InitFlags(nil)
 	initFlags(pflag.CommandLine)
 	pflag.CommandLine.AddGoFlagSet(flag.CommandLine)
@@ -62,6 +89,12 @@ func main() {
 
 	ctrl.SetLogger(logger)
 
+	shutdownTracing, err := tracing.Setup(context.Background(), cfg.Tracing)
+	if err != nil {
+		logger.Error(err, "failed to setup tracing")
+		os.Exit(1)
+	}
+
 	mgr, err := ctrl.NewManager(ctrl.GetConfigOrDie(), ctrl.Options{
 		Scheme: scheme,
 		Metrics: metricsserver.Options{
@@ -76,18 +109,89 @@ func main() {
 		os.Exit(1)
 	}
 
+	if err := webhookref.SetupIndexes(context.Background(), mgr.GetFieldIndexer()); err != nil {
+		logger.Error(err, "failed to setup webhook indexes")
+		os.Exit(1)
+	}
+
 	nodeCache := nodecache.NewNodeIPCache()
-	if err := nodecache.SetupNodeWatch(mgr, nodeCache); err != nil {
+
+	var cidrCache *cidrcache.CIDRCache
+	if cfg.Proxy.AutoCIDRs {
+		cidrCache = cidrcache.NewCIDRCache()
+	}
+
+	cfgStore := config.NewStore(cfg)
+	// Configuration is loaded before controllers and the initial reconcile are started.
+	if err := proxyconfig.Load(context.Background(), mgr.GetAPIReader(), cfgStore,
+		log.Log.WithName(proxyconfig.ControllerName)); err != nil {
+		logger.Error(err, "failed to load webhook proxy config")
+		os.Exit(1)
+	}
+
+	// Writes of controllers go through apiClient, in observe-only mode they are only logged and counted.
+	var apiClient client.Client = mgr.GetClient()
+	if cfg.Proxy.ObserveOnly {
+		logger.Info("observe-only mode, API writes are skipped")
+		apiClient = observe.NewClient(apiClient, log.Log.WithName("observe"))
+	}
+
+	proxyHandler := proxy.New(apiClient, mgr.GetAPIReader(), mgr.GetEventRecorderFor(utils.ControllerName), cfgStore, nodeCache, cidrCache)
+
+	// Network policies allow node addresses only with Cluster traffic policy of restricted webhooks.
+	var nodesChanged func(context.Context) error
+	if cfg.Proxy.RestrictedTrafficPolicy == config.TrafficPolicyCluster {
+		nodesChanged = proxyHandler.RefreshNetworkPolicies
+	}
+	if err := nodecache.SetupNodeWatch(mgr, nodeCache, nodesChanged); err != nil {
 		logger.Error(err, "failed to setup node cache")
 		os.Exit(1)
 	}
 
-	proxyHandler := proxy.New(mgr.GetClient(), cfg, nodeCache)
+	if cfg.Probe.Enabled {
+		endpointProber := prober.New(cfg.Probe, proxyHandler, log.Log.WithName("prober"))
+		proxyHandler.SetEndpointHealth(endpointProber)
+		if err := mgr.Add(endpointProber); err != nil {
+			logger.Error(err, "failed to setup endpoint prober")
+			os.Exit(1)
+		}
+	}
+
+	if cfg.Canary.Enabled {
+		if err := mgr.Add(canary.New(cfg.Canary, mgr.GetClient(),
+			mgr.GetEventRecorderFor(utils.ControllerName), log.Log.WithName("canary"))); err != nil {
+			logger.Error(err, "failed to setup webhook canary")
+			os.Exit(1)
+		}
+	}
+
+	if err := (&proxyconfig.Controller{
+		Store:  cfgStore,
+		Proxy:  proxyHandler,
+		Client: apiClient,
+		Log:    log.Log.WithName(proxyconfig.ControllerName),
+	}).SetupWithManager(mgr); err != nil {
+		logger.Error(err, "failed to setup webhook proxy config controller")
+		os.Exit(1)
+	}
+
+	if cfg.Proxy.AutoCIDRs {
+		if err := (&apiservercontroller.Controller{
+			Store:     cfgStore,
+			Proxy:     proxyHandler,
+			Client:    apiClient,
+			CIDRCache: cidrCache,
+			Log:       log.Log.WithName(apiservercontroller.ControllerName),
+		}).SetupWithManager(mgr); err != nil {
+			logger.Error(err, "failed to setup apiserver controller")
+			os.Exit(1)
+		}
+	}
 
 	if err := (&crdcontroller.Controller{
-		Config: cfg,
+		Store:  cfgStore,
 		Proxy:  proxyHandler,
-		Client: mgr.GetClient(),
+		Client: apiClient,
 		Log:    log.Log.WithName(crdcontroller.ControllerName),
 	}).SetupWithManager(mgr); err != nil {
 		logger.Error(err, "failed to setup CRD controller")
@@ -95,9 +199,9 @@ func main() {
 	}
 
 	if err := (&endpointslice.Controller{
-		Config: cfg,
+		Store:  cfgStore,
 		Proxy:  proxyHandler,
-		Client: mgr.GetClient(),
+		Client: apiClient,
 		Log:    log.Log.WithName(endpointslice.ControllerName),
 	}).SetupWithManager(mgr); err != nil {
 		logger.Error(err, "failed to setup endpoint slice controller")
@@ -105,9 +209,9 @@ func main() {
 	}
 
 	if err := (&mutating.Controller{
-		Config: cfg,
+		Store:  cfgStore,
 		Proxy:  proxyHandler,
-		Client: mgr.GetClient(),
+		Client: apiClient,
 		Log:    log.Log.WithName(mutating.ControllerName),
 	}).SetupWithManager(mgr); err != nil {
 		logger.Error(err, "failed to setup mutating controller")
@@ -115,27 +219,95 @@ func main() {
 	}
 
 	if err := (&validating.Controller{
-		Config: cfg,
+		Store:  cfgStore,
 		Proxy:  proxyHandler,
-		Client: mgr.GetClient(),
+		Client: apiClient,
 		Log:    log.Log.WithName(validating.ControllerName),
 	}).SetupWithManager(mgr); err != nil {
 		logger.Error(err, "failed to setup validating controller")
 		os.Exit(1)
 	}
 
+	if err := (&servicecontroller.Controller{
+		Store:  cfgStore,
+		Proxy:  proxyHandler,
+		Client: apiClient,
+		Log:    log.Log.WithName(servicecontroller.ControllerName),
+	}).SetupWithManager(mgr); err != nil {
+		logger.Error(err, "failed to setup service controller")
+		os.Exit(1)
+	}
+
+	if err := (&statuscontroller.Controller{
+		Store:  cfgStore,
+		Proxy:  proxyHandler,
+		Client: apiClient,
+		Log:    log.Log.WithName(statuscontroller.ControllerName),
+	}).SetupWithManager(mgr); err != nil {
+		logger.Error(err, "failed to setup webhook proxy status controller")
+		os.Exit(1)
+	}
+
+	if cfg.SecurityGroup.Enabled {
+		sgProvider, err := securitygroup.NewEC2(context.Background())
+		if err != nil {
+			logger.Error(err, "failed to create security group provider")
+			os.Exit(1)
+		}
+
+		if err := (&sgcontroller.Controller{
+			Store:    cfgStore,
+			Client:   apiClient,
+			Provider: sgProvider,
+			Log:      log.Log.WithName(sgcontroller.ControllerName),
+		}).SetupWithManager(mgr); err != nil {
+			logger.Error(err, "failed to setup security group controller")
+			os.Exit(1)
+		}
+	}
+
+	if err := mgr.AddMetricsServerExtraHandler(debug.Path, &debug.Handler{
+		Reader:    mgr.GetClient(),
+		NodeCache: nodeCache,
+		Proxy:     proxyHandler,
+	}); err != nil {
+		logger.Error(err, "unable to set up debug endpoint")
+		os.Exit(1)
+	}
+
 	if err := mgr.AddHealthzCheck("healthz", healthz.Ping); err != nil {
 		logger.Error(err, "unable to set up health check")
 		os.Exit(1)
 	}
-	if err := mgr.AddReadyzCheck("readyz", healthz.Ping); err != nil {
-		logger.Error(err, "unable to set up ready check")
+	initialReconcile := &readiness.InitialReconcile{
+		Manager: mgr,
+		Proxy:   proxyHandler,
+		Log:     log.Log.WithName("initial-reconcile"),
+	}
+	if err := mgr.Add(initialReconcile); err != nil {
+		logger.Error(err, "unable to set up initial reconcile")
 		os.Exit(1)
 	}
 
+	for name, check := range map[string]healthz.Checker{
+		"readyz":            healthz.Ping,
+		"node-cache":        nodeCache.Checker,
+		"initial-reconcile": initialReconcile.Checker,
+		"leader":            readiness.Leader(mgr),
+	} {
+		if err := mgr.AddReadyzCheck(name, check); err != nil {
+			logger.Error(err, "unable to set up ready check", "check", name)
+			os.Exit(1)
+		}
+	}
+
 	logger.Info("starting manager")
 	if err := mgr.Start(ctrl.SetupSignalHandler()); err != nil {
 		logger.Error(err, "problem running manager")
 		os.Exit(1)
 	}
+
+	if err := shutdownTracing(context.Background()); err != nil {
+		logger.Error(err, "failed to flush traces")
+	}
 }
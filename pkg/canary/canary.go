@@ -0,0 +1,300 @@
+// Package canary periodically submits template objects with dryRun=All, so kube-apiserver calls
+// the proxied webhooks and proves they are reachable from the control plane.
+package canary
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/CharlieR-o-o-t/eks-webhook-proxy/config"
+	"github.com/CharlieR-o-o-t/eks-webhook-proxy/pkg/metrics"
+	"github.com/CharlieR-o-o-t/eks-webhook-proxy/pkg/utils"
+	"github.com/CharlieR-o-o-t/eks-webhook-proxy/pkg/webhookref"
+	"github.com/go-logr/logr"
+	admissionv1 "k8s.io/api/admissionregistration/v1"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/yaml"
+)
+
+// Event reasons.
+const (
+	EventReasonCanaryFailed    = "CanaryFailed"
+	EventReasonCanaryRecovered = "CanaryRecovered"
+)
+
+// generateName is used for templates without name, dry-run objects are never persisted.
+const generateName = utils.ControllerName + "-canary-"
+
+// failedCallingWebhook is part of kube-apiserver error, when webhook call fails or times out.
+const failedCallingWebhook = "failed calling webhook"
+
+// Result is the outcome of a canary request.
+type Result struct {
+	// Result is one of metrics.CanaryResult* values.
+	Result   string
+	Duration time.Duration
+	Err      error
+}
+
+// Canary submits canary templates of webhook configurations annotated with utils.AnnotationCanary.
+type Canary struct {
+	config   config.Canary
+	client   client.Client
+	recorder record.EventRecorder
+	log      logr.Logger
+
+	mu        sync.Mutex
+	reachable map[string]bool
+}
+
+func New(cfg config.Canary, client client.Client, recorder record.EventRecorder, log logr.Logger) *Canary {
+	return &Canary{
+		config:    cfg,
+		client:    client,
+		recorder:  recorder,
+		log:       log,
+		reachable: make(map[string]bool),
+	}
+}
+
+// Start implements manager.Runnable.
+func (c *Canary) Start(ctx context.Context) error {
+	ticker := time.NewTicker(c.config.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			if err := c.RunOnce(ctx); err != nil {
+				c.log.Error(err, "unable to run canary requests")
+			}
+		}
+	}
+}
+
+// NeedLeaderElection implements manager.LeaderElectionRunnable.
+func (c *Canary) NeedLeaderElection() bool {
+	return true
+}
+
+// RunOnce submits canary template of every annotated webhook configuration once.
+func (c *Canary) RunOnce(ctx context.Context) error {
+	var mutatingList = new(admissionv1.MutatingWebhookConfigurationList)
+	if err := c.client.List(ctx, mutatingList); err != nil {
+		return fmt.Errorf("unable to list mutating webhooks, %w", err)
+	}
+	for i := range mutatingList.Items {
+		c.run(ctx, webhookref.KindMutating, &mutatingList.Items[i])
+	}
+
+	var validatingList = new(admissionv1.ValidatingWebhookConfigurationList)
+	if err := c.client.List(ctx, validatingList); err != nil {
+		return fmt.Errorf("unable to list validating webhooks, %w", err)
+	}
+	for i := range validatingList.Items {
+		c.run(ctx, webhookref.KindValidating, &validatingList.Items[i])
+	}
+
+	return nil
+}
+
+func (c *Canary) run(ctx context.Context, kind string, webhookObj client.Object) {
+	template, ok := webhookObj.GetAnnotations()[utils.AnnotationCanary]
+	if !ok {
+		return
+	}
+	log := c.log.WithValues("kind", kind, "name", webhookObj.GetName())
+
+	result := c.Submit(ctx, webhookObj, template)
+
+	metrics.CanaryRequests.WithLabelValues(kind, webhookObj.GetName(), result.Result).Inc()
+	if result.Result == metrics.CanaryResultInconclusive {
+		log.Error(result.Err, "canary request is inconclusive, check the template and RBAC")
+		return
+	}
+	metrics.CanaryDuration.WithLabelValues(kind, webhookObj.GetName()).Observe(result.Duration.Seconds())
+
+	reachable := result.Result == metrics.CanaryResultReachable
+	reachableValue := 0.0
+	if reachable {
+		reachableValue = 1
+	}
+	metrics.CanaryReachable.WithLabelValues(kind, webhookObj.GetName()).Set(reachableValue)
+	log.V(4).Info("canary request is done", "result", result.Result, "duration", result.Duration)
+
+	key := kind + "/" + webhookObj.GetName()
+	c.mu.Lock()
+	previous, found := c.reachable[key]
+	c.reachable[key] = reachable
+	c.mu.Unlock()
+
+	switch {
+	case !reachable && (!found || previous):
+		c.recorder.Eventf(webhookObj, v1.EventTypeWarning, EventReasonCanaryFailed,
+			"dry-run canary request failed in %s: %v", result.Duration.Round(time.Millisecond), result.Err)
+	case reachable && found && !previous:
+		c.recorder.Eventf(webhookObj, v1.EventTypeNormal, EventReasonCanaryRecovered,
+			"dry-run canary request succeeded in %s", result.Duration.Round(time.Millisecond))
+	}
+}
+
+// Submit creates template object with dryRun=All and classifies the result.
+// Webhook denials prove that webhook was called, so they are reachable results.
+// Success proves it only when a webhook of webhookObj with failurePolicy Fail matches the template,
+// failures of other webhooks are ignored by kube-apiserver.
+func (c *Canary) Submit(ctx context.Context, webhookObj client.Object, template string) Result {
+	obj := new(unstructured.Unstructured)
+	if err := yaml.Unmarshal([]byte(template), &obj.Object); err != nil {
+		return Result{Result: metrics.CanaryResultInconclusive, Err: fmt.Errorf("invalid canary template, %w", err)}
+	}
+	if obj.GetName() == "" && obj.GetGenerateName() == "" {
+		obj.SetGenerateName(generateName)
+	}
+
+	gvk := obj.GroupVersionKind()
+	mapping, err := c.client.RESTMapper().RESTMapping(gvk.GroupKind(), gvk.Version)
+	if err != nil {
+		return Result{Result: metrics.CanaryResultInconclusive, Err: fmt.Errorf("unable to map canary template %s, %w", gvk, err)}
+	}
+	matched, failClosed := matchWebhooks(webhookObj, mapping)
+	if !matched {
+		return Result{
+			Result: metrics.CanaryResultInconclusive,
+			Err:    fmt.Errorf("no webhook rule matches create of %s, canary template does not call the webhooks", mapping.Resource),
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, c.config.Timeout)
+	defer cancel()
+
+	start := time.Now()
+	err = c.client.Create(ctx, obj, client.DryRunAll)
+	result := Result{Duration: time.Since(start), Err: err}
+	result.Result = classify(err, webhookNames(webhookObj), failClosed)
+	if err == nil && !failClosed {
+		result.Err = errors.New("matching webhooks have failurePolicy Ignore, success does not prove they were called")
+	}
+	return result
+}
+
+// classify returns result of dry-run create. Denials and failed calls count only when the error names
+// one of webhooks, other webhooks intercepting the same resource tell nothing about them.
+func classify(err error, webhooks []string, failClosed bool) string {
+	switch {
+	case err == nil && failClosed:
+		return metrics.CanaryResultReachable
+	case err == nil:
+		// Failed calls of webhooks with failurePolicy Ignore are not reported to the client.
+		return metrics.CanaryResultInconclusive
+	case errors.Is(err, context.DeadlineExceeded):
+		return metrics.CanaryResultUnreachable
+	case !mentionsWebhook(err, webhooks):
+		// Request did not reach the webhooks: denied or failed by another webhook, missing RBAC,
+		// invalid template, existing object name, webhooks with side effects, which are not called
+		// for dry-run, or an unknown error.
+		return metrics.CanaryResultInconclusive
+	case strings.Contains(err.Error(), failedCallingWebhook):
+		return metrics.CanaryResultUnreachable
+	case isAdmissionDenial(err):
+		return metrics.CanaryResultReachable
+	}
+	return metrics.CanaryResultInconclusive
+}
+
+// webhookNames returns names of webhooks of mutating or validating configuration.
+func webhookNames(webhookObj client.Object) []string {
+	var names []string
+	switch obj := webhookObj.(type) {
+	case *admissionv1.MutatingWebhookConfiguration:
+		for _, webhook := range obj.Webhooks {
+			names = append(names, webhook.Name)
+		}
+	case *admissionv1.ValidatingWebhookConfiguration:
+		for _, webhook := range obj.Webhooks {
+			names = append(names, webhook.Name)
+		}
+	}
+	return names
+}
+
+// mentionsWebhook tells if kube-apiserver error is about one of webhooks, e.g.
+// `admission webhook "name" denied the request` or `failed calling webhook "name"`.
+func mentionsWebhook(err error, webhooks []string) bool {
+	for _, name := range webhooks {
+		if strings.Contains(err.Error(), fmt.Sprintf("webhook %q", name)) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchWebhooks tells if any webhook of mutating or validating configuration intercepts create of
+// mapping resource, and if any of them has failurePolicy Fail. Namespace and object selectors are not evaluated.
+func matchWebhooks(webhookObj client.Object, mapping *meta.RESTMapping) (matched, failClosed bool) {
+	check := func(rules []admissionv1.RuleWithOperations, failurePolicy *admissionv1.FailurePolicyType) {
+		for _, rule := range rules {
+			if !ruleMatches(rule, mapping) {
+				continue
+			}
+			matched = true
+			// Fail is the default of admissionregistration/v1.
+			if ptr.Deref(failurePolicy, admissionv1.Fail) == admissionv1.Fail {
+				failClosed = true
+			}
+			return
+		}
+	}
+
+	switch obj := webhookObj.(type) {
+	case *admissionv1.MutatingWebhookConfiguration:
+		for _, webhook := range obj.Webhooks {
+			check(webhook.Rules, webhook.FailurePolicy)
+		}
+	case *admissionv1.ValidatingWebhookConfiguration:
+		for _, webhook := range obj.Webhooks {
+			check(webhook.Rules, webhook.FailurePolicy)
+		}
+	}
+	return matched, failClosed
+}
+
+// ruleMatches tells if rule intercepts create of mapping resource, the way kube-apiserver matches rules.
+func ruleMatches(rule admissionv1.RuleWithOperations, mapping *meta.RESTMapping) bool {
+	resource := mapping.Resource
+	return (slices.Contains(rule.Operations, admissionv1.Create) || slices.Contains(rule.Operations, admissionv1.OperationAll)) &&
+		matchesAny(rule.APIGroups, resource.Group) &&
+		matchesAny(rule.APIVersions, resource.Version) &&
+		(matchesAny(rule.Resources, resource.Resource) || slices.Contains(rule.Resources, "*/*")) &&
+		scopeMatches(rule.Scope, mapping.Scope.Name())
+}
+
+func matchesAny(values []string, value string) bool {
+	return slices.Contains(values, "*") || slices.Contains(values, value)
+}
+
+func scopeMatches(scope *admissionv1.ScopeType, name meta.RESTScopeName) bool {
+	switch ptr.Deref(scope, admissionv1.AllScopes) {
+	case admissionv1.NamespacedScope:
+		return name == meta.RESTScopeNameNamespace
+	case admissionv1.ClusterScope:
+		return name == meta.RESTScopeNameRoot
+	}
+	return true
+}
+
+// isAdmissionDenial tells if request was denied by admission webhook.
+func isAdmissionDenial(err error) bool {
+	return strings.Contains(err.Error(), "admission webhook") && strings.Contains(err.Error(), "denied the request")
+}
@@ -0,0 +1,235 @@
+package canary
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/CharlieR-o-o-t/eks-webhook-proxy/config"
+	"github.com/CharlieR-o-o-t/eks-webhook-proxy/pkg/metrics"
+	"github.com/go-logr/logr"
+	admissionv1 "k8s.io/api/admission/v1"
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/envtest"
+)
+
+const template = `
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  namespace: default
+  generateName: webhook-canary-
+`
+
+// webhookServer is a local validating webhook, it denies requests while deny is set.
+type webhookServer struct {
+	*httptest.Server
+	deny  atomic.Bool
+	calls atomic.Int32
+}
+
+func newWebhookServer(t *testing.T) *webhookServer {
+	t.Helper()
+	s := &webhookServer{}
+	s.Server = httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.calls.Add(1)
+
+		var review admissionv1.AdmissionReview
+		if err := json.NewDecoder(r.Body).Decode(&review); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		review.Response = &admissionv1.AdmissionResponse{UID: review.Request.UID, Allowed: !s.deny.Load()}
+		if s.deny.Load() {
+			review.Response.Result = &metav1.Status{Message: "denied by canary test"}
+		}
+		review.Request = nil
+		_ = json.NewEncoder(w).Encode(review)
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *webhookServer) caBundle() []byte {
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: s.Certificate().Raw})
+}
+
+func startEnv(t *testing.T) client.Client {
+	t.Helper()
+	if os.Getenv("KUBEBUILDER_ASSETS") == "" {
+		t.Skip("KUBEBUILDER_ASSETS is not set, skipping envtest")
+	}
+
+	env := &envtest.Environment{}
+	restConfig, err := env.Start()
+	if err != nil {
+		t.Fatalf("unable to start envtest, %v", err)
+	}
+	t.Cleanup(func() { _ = env.Stop() })
+
+	c, err := client.New(restConfig, client.Options{Scheme: clientgoscheme.Scheme})
+	if err != nil {
+		t.Fatalf("unable to create client, %v", err)
+	}
+	return c
+}
+
+func validatingWebhook(name string, server *webhookServer, failurePolicy admissionregistrationv1.FailurePolicyType, resource string) *admissionregistrationv1.ValidatingWebhookConfiguration {
+	return &admissionregistrationv1.ValidatingWebhookConfiguration{
+		ObjectMeta: metav1.ObjectMeta{Name: name},
+		Webhooks: []admissionregistrationv1.ValidatingWebhook{{
+			Name: name + ".canary.test",
+			ClientConfig: admissionregistrationv1.WebhookClientConfig{
+				URL:      ptr.To(server.URL + "/validate"),
+				CABundle: server.caBundle(),
+			},
+			Rules: []admissionregistrationv1.RuleWithOperations{{
+				Operations: []admissionregistrationv1.OperationType{admissionregistrationv1.Create},
+				Rule: admissionregistrationv1.Rule{
+					APIGroups:   []string{""},
+					APIVersions: []string{"v1"},
+					Resources:   []string{resource},
+				},
+			}},
+			FailurePolicy:           ptr.To(failurePolicy),
+			SideEffects:             ptr.To(admissionregistrationv1.SideEffectClassNone),
+			AdmissionReviewVersions: []string{"v1"},
+			TimeoutSeconds:          ptr.To(int32(2)),
+		}},
+	}
+}
+
+func TestSubmit(t *testing.T) {
+	c := startEnv(t)
+	ctx := context.Background()
+	canary := New(config.Canary{Timeout: 10 * time.Second}, c, record.NewFakeRecorder(10), logr.Discard())
+
+	tests := []struct {
+		name          string
+		failurePolicy admissionregistrationv1.FailurePolicyType
+		resource      string
+		deny          bool
+		stopped       bool
+		want          string
+		wantCalled    bool
+	}{
+		{
+			name:          "allowed",
+			failurePolicy: admissionregistrationv1.Fail,
+			resource:      "configmaps",
+			want:          metrics.CanaryResultReachable,
+			wantCalled:    true,
+		},
+		{
+			name:          "denied",
+			failurePolicy: admissionregistrationv1.Fail,
+			resource:      "configmaps",
+			deny:          true,
+			want:          metrics.CanaryResultReachable,
+			wantCalled:    true,
+		},
+		{
+			name:          "unreachable",
+			failurePolicy: admissionregistrationv1.Fail,
+			resource:      "configmaps",
+			stopped:       true,
+			want:          metrics.CanaryResultUnreachable,
+		},
+		{
+			// Failed call is ignored by kube-apiserver, success proves nothing.
+			name:          "failure policy ignore",
+			failurePolicy: admissionregistrationv1.Ignore,
+			resource:      "configmaps",
+			stopped:       true,
+			want:          metrics.CanaryResultInconclusive,
+		},
+		{
+			name:          "rules do not match template",
+			failurePolicy: admissionregistrationv1.Fail,
+			resource:      "secrets",
+			want:          metrics.CanaryResultInconclusive,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newWebhookServer(t)
+			server.deny.Store(tt.deny)
+
+			webhookObj := validatingWebhook("canary-test", server, tt.failurePolicy, tt.resource)
+			if err := c.Create(ctx, webhookObj); err != nil {
+				t.Fatalf("unable to create webhook configuration, %v", err)
+			}
+			t.Cleanup(func() { _ = c.Delete(context.Background(), webhookObj) })
+			if tt.stopped {
+				server.Close()
+			}
+
+			// Webhook configuration is picked up by kube-apiserver asynchronously.
+			var result Result
+			deadline := time.Now().Add(10 * time.Second)
+			for {
+				result = canary.Submit(ctx, webhookObj, template)
+				done := result.Result == tt.want && (!tt.wantCalled || server.calls.Load() > 0)
+				if done || time.Now().After(deadline) {
+					break
+				}
+				time.Sleep(200 * time.Millisecond)
+			}
+
+			if result.Result != tt.want {
+				t.Errorf("Submit() = %s (%v), want %s", result.Result, result.Err, tt.want)
+			}
+			if called := server.calls.Load() > 0; tt.wantCalled && !called {
+				t.Error("webhook was not called")
+			}
+		})
+	}
+}
+
+func TestClassify(t *testing.T) {
+	webhooks := []string{"canary-test.canary.test"}
+	denied := func(name string) error {
+		return fmt.Errorf("admission webhook %q denied the request: denied by canary test", name)
+	}
+	failed := func(name string) error {
+		return fmt.Errorf("Internal error occurred: failed calling webhook %q: failed to call webhook: connection refused", name)
+	}
+
+	tests := []struct {
+		name       string
+		err        error
+		failClosed bool
+		want       string
+	}{
+		{name: "allowed", failClosed: true, want: metrics.CanaryResultReachable},
+		{name: "allowed with failure policy ignore", want: metrics.CanaryResultInconclusive},
+		{name: "denied", err: denied(webhooks[0]), failClosed: true, want: metrics.CanaryResultReachable},
+		{name: "failed call", err: failed(webhooks[0]), failClosed: true, want: metrics.CanaryResultUnreachable},
+		{name: "timeout", err: context.DeadlineExceeded, failClosed: true, want: metrics.CanaryResultUnreachable},
+		{name: "denied by other webhook", err: denied("other.canary.test"), failClosed: true, want: metrics.CanaryResultInconclusive},
+		{name: "failed call of other webhook", err: failed("other.canary.test"), failClosed: true, want: metrics.CanaryResultInconclusive},
+		{name: "forbidden", err: errors.New(`configmaps is forbidden: User "canary" cannot create resource "configmaps"`), failClosed: true, want: metrics.CanaryResultInconclusive},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := classify(tt.err, webhooks, tt.failClosed); got != tt.want {
+				t.Errorf("classify() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
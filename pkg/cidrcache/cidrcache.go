@@ -0,0 +1,118 @@
+package cidrcache
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"slices"
+	"sort"
+	"sync"
+
+	"github.com/CharlieR-o-o-t/eks-webhook-proxy/pkg/utils"
+	discoveryv1 "k8s.io/api/discovery/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	// KubernetesServiceName is the service of kubernetes API, its endpoints are EKS control-plane ENIs.
+	KubernetesServiceName      = "kubernetes"
+	KubernetesServiceNamespace = metav1.NamespaceDefault
+)
+
+// CIDRCache keeps source CIDRs derived from kubernetes API endpoints.
+type CIDRCache struct {
+	mu    sync.RWMutex
+	cidrs []string
+}
+
+func NewCIDRCache() *CIDRCache {
+	return &CIDRCache{}
+}
+
+// ListAPIServerEndpointSlices returns endpoint slices of kubernetes API service.
+func ListAPIServerEndpointSlices(ctx context.Context, reader client.Reader) ([]discoveryv1.EndpointSlice, error) {
+	var endpointSlices = new(discoveryv1.EndpointSliceList)
+	if err := reader.List(ctx, endpointSlices,
+		client.InNamespace(KubernetesServiceNamespace),
+		client.MatchingLabels{utils.LabelEndpointSliceServiceName: KubernetesServiceName},
+	); err != nil {
+		return nil, fmt.Errorf("unable to list kubernetes API EndpointSlices, %w", err)
+	}
+	return endpointSlices.Items, nil
+}
+
+// Set replaces cached CIDRs, returns true if they are changed.
+func (c *CIDRCache) Set(cidrs []string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if slices.Equal(c.cidrs, cidrs) {
+		return false
+	}
+	c.cidrs = cidrs
+	return true
+}
+
+func (c *CIDRCache) Get() []string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return slices.Clone(c.cidrs)
+}
+
+// FromEndpointSlices derives source CIDRs from kubernetes API endpoint addresses.
+// Address is replaced with the first supernet containing it, otherwise /32 is used.
+func FromEndpointSlices(endpointSlices []discoveryv1.EndpointSlice, supernets []string) []string {
+	var nets []*net.IPNet
+	for _, supernet := range supernets {
+		if _, ipNet, err := net.ParseCIDR(supernet); err == nil {
+			nets = append(nets, ipNet)
+		}
+	}
+
+	set := make(map[string]struct{})
+	for _, endpointSlice := range endpointSlices {
+		if endpointSlice.AddressType != discoveryv1.AddressTypeIPv4 {
+			continue
+		}
+		for _, endpoint := range endpointSlice.Endpoints {
+			for _, address := range endpoint.Addresses {
+				ip := net.ParseIP(address).To4()
+				if ip == nil {
+					continue
+				}
+				set[toCIDR(ip, nets)] = struct{}{}
+			}
+		}
+	}
+
+	cidrs := make([]string, 0, len(set))
+	for cidr := range set {
+		cidrs = append(cidrs, cidr)
+	}
+	sort.Strings(cidrs)
+
+	return cidrs
+}
+
+// Merge returns static CIDRs followed by derived ones, without duplicates.
+func Merge(static, derived []string) []string {
+	merged := make([]string, 0, len(static)+len(derived))
+	seen := make(map[string]struct{}, len(static)+len(derived))
+	for _, cidr := range append(slices.Clone(static), derived...) {
+		if _, ok := seen[cidr]; ok {
+			continue
+		}
+		seen[cidr] = struct{}{}
+		merged = append(merged, cidr)
+	}
+	return merged
+}
+
+func toCIDR(ip net.IP, supernets []*net.IPNet) string {
+	for _, supernet := range supernets {
+		if supernet.Contains(ip) {
+			return supernet.String()
+		}
+	}
+	return ip.String() + "/32"
+}
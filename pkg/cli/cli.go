@@ -0,0 +1,295 @@
+// Package cli implements eks-webhook-proxy subcommands, which inspect and operate proxies
+// through the kubeconfig. The binary may be installed as kubectl-webhook_proxy to be used as a kubectl plugin.
+package cli
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/CharlieR-o-o-t/eks-webhook-proxy/api/v1alpha1"
+	"github.com/CharlieR-o-o-t/eks-webhook-proxy/config"
+	"github.com/CharlieR-o-o-t/eks-webhook-proxy/controllers/proxyconfig"
+	"github.com/CharlieR-o-o-t/eks-webhook-proxy/pkg/cidrcache"
+	"github.com/CharlieR-o-o-t/eks-webhook-proxy/pkg/nodecache"
+	"github.com/CharlieR-o-o-t/eks-webhook-proxy/pkg/proxy"
+	"github.com/spf13/pflag"
+	v1 "k8s.io/api/core/v1"
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/yaml"
+)
+
+// Output formats.
+const (
+	OutputTable = "table"
+	OutputJSON  = "json"
+	OutputYAML  = "yaml"
+)
+
+// PluginName is the binary name kubectl looks up for the "kubectl webhook-proxy" plugin.
+const PluginName = "kubectl-webhook_proxy"
+
+var scheme = runtime.NewScheme()
+
+func init() {
+	utilruntime.Must(clientgoscheme.AddToScheme(scheme))
+	utilruntime.Must(apiextensionsv1.AddToScheme(scheme))
+	utilruntime.Must(v1alpha1.AddToScheme(scheme))
+}
+
+// command is a subcommand, run gets arguments left after flags parsing.
+type command struct {
+	short string
+	flags func(fs *pflag.FlagSet, opts *options)
+	run   func(ctx context.Context, opts *options, args []string) error
+}
+
+var commands = map[string]command{}
+
+// IsCommand tells if name is a known subcommand.
+func IsCommand(name string) bool {
+	_, ok := commands[name]
+	return ok || name == "help"
+}
+
+// IsPlugin tells if the binary is run as kubectl plugin, it never starts the controller manager then.
+func IsPlugin(argv0 string) bool {
+	return filepath.Base(argv0) == PluginName
+}
+
+// Run runs subcommand, args[0] is the subcommand name. Usage is printed without one. Returns process exit code.
+func Run(ctx context.Context, args []string) int {
+	if len(args) == 0 {
+		usage(os.Stdout)
+		return 0
+	}
+	name := args[0]
+	cmd, ok := commands[name]
+	if !ok {
+		usage(os.Stdout)
+		return 0
+	}
+
+	opts := &options{out: os.Stdout}
+	fs := pflag.NewFlagSet(name, pflag.ContinueOnError)
+	opts.addFlags(fs)
+	if cmd.flags != nil {
+		cmd.flags(fs, opts)
+	}
+	if err := fs.Parse(args[1:]); err != nil {
+		if err == pflag.ErrHelp {
+			return 0
+		}
+		return 2
+	}
+
+	if err := cmd.run(ctx, opts, fs.Args()); err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+func usage(w io.Writer) {
+	program := "eks-webhook-proxy"
+	if IsPlugin(os.Args[0]) {
+		program = "kubectl webhook-proxy"
+		fmt.Fprintf(w, "Usage: %s <command> [flags]\n\nCommands:\n", program)
+	} else {
+		fmt.Fprintf(w, "Usage: %s [command] [flags]\n", program)
+		fmt.Fprintln(w, "\nWithout a command the controller manager is started.\n\nCommands:")
+	}
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(w, "  %-10s %s\n", name, commands[name].short)
+	}
+	fmt.Fprintf(w, "\nUse \"%s <command> --help\" for command flags.\n", program)
+}
+
+// options are common flags of all subcommands.
+type options struct {
+	kubeconfig  string
+	kubeContext string
+	configFile  string
+	configMap   string
+	output      string
+	namespace   string
+
+	out io.Writer
+}
+
+func (o *options) addFlags(fs *pflag.FlagSet) {
+	fs.StringVar(&o.kubeconfig, "kubeconfig", "", "Path to the kubeconfig file.")
+	fs.StringVar(&o.kubeContext, "context", "", "The kubeconfig context to use.")
+	fs.StringVar(&o.configFile, "config-file", "", "Controller configuration as dotenv file.")
+	fs.StringVar(&o.configMap, "config-map", "", "Controller configuration ConfigMap as <namespace>/<name>, takes precedence over --config-file. The chart ConfigMap of the controller is found by label when both are empty.")
+}
+
+func (o *options) addOutputFlag(fs *pflag.FlagSet) {
+	fs.StringVarP(&o.output, "output", "o", OutputTable, "Output format: table, json or yaml.")
+}
+
+func (o *options) addNamespaceFlag(fs *pflag.FlagSet) {
+	fs.StringVarP(&o.namespace, "namespace", "n", "", "Limit to webhook services in the namespace, all namespaces when empty.")
+}
+
+// client returns client for the kubeconfig cluster.
+func (o *options) client() (client.Client, error) {
+	loadingRules := clientcmd.NewDefaultClientConfigLoadingRules()
+	loadingRules.ExplicitPath = o.kubeconfig
+	restConfig, err := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(
+		loadingRules,
+		&clientcmd.ConfigOverrides{CurrentContext: o.kubeContext},
+	).ClientConfig()
+	if err != nil {
+		return nil, fmt.Errorf("unable to load kubeconfig, %w", err)
+	}
+
+	return client.New(restConfig, client.Options{Scheme: scheme})
+}
+
+// config returns controller configuration, overridden by WebhookProxyConfig of the cluster when it exists.
+func (o *options) config(ctx context.Context, c client.Reader) (*config.Config, error) {
+	var cfg *config.Config
+	var err error
+	switch {
+	case o.configMap != "":
+		cfg, err = configFromConfigMap(ctx, c, o.configMap)
+	case o.configFile != "":
+		cfg, err = config.LoadFile(o.configFile)
+	default:
+		cfg, err = chartConfig(ctx, c)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var proxyConfig = new(v1alpha1.WebhookProxyConfig)
+	if err := c.Get(ctx, types.NamespacedName{Name: v1alpha1.WebhookProxyConfigName}, proxyConfig); err != nil {
+		if apierrors.IsNotFound(err) || meta.IsNoMatchError(err) {
+			return cfg, nil
+		}
+		return nil, fmt.Errorf("unable to get WebhookProxyConfig, %w", err)
+	}
+	return proxyconfig.Apply(cfg, &proxyConfig.Spec)
+}
+
+func configFromConfigMap(ctx context.Context, c client.Reader, ref string) (*config.Config, error) {
+	namespace, name, ok := strings.Cut(ref, "/")
+	if !ok {
+		return nil, fmt.Errorf("invalid config map %q, expected <namespace>/<name>", ref)
+	}
+
+	var configMap = new(v1.ConfigMap)
+	if err := c.Get(ctx, types.NamespacedName{Namespace: namespace, Name: name}, configMap); err != nil {
+		return nil, fmt.Errorf("unable to get config map %s, %w", ref, err)
+	}
+	return config.Load(configMap.Data)
+}
+
+// chartConfig reads the ConfigMap installed by the chart along with the controller. The environment
+// is used only when the controller is not installed, subcommands would not match it otherwise.
+func chartConfig(ctx context.Context, c client.Reader) (*config.Config, error) {
+	var configMaps = new(v1.ConfigMapList)
+	if err := c.List(ctx, configMaps, controllerSelector); err != nil {
+		return nil, fmt.Errorf("unable to list controller config maps, %w", err)
+	}
+
+	switch len(configMaps.Items) {
+	case 0:
+		fmt.Fprintln(os.Stderr, "warning: controller config map is not found, using configuration from the environment")
+		return config.New()
+	case 1:
+		return config.Load(configMaps.Items[0].Data)
+	}
+
+	refs := make([]string, 0, len(configMaps.Items))
+	for _, configMap := range configMaps.Items {
+		refs = append(refs, configMap.Namespace+"/"+configMap.Name)
+	}
+	return nil, fmt.Errorf("found %d controller config maps (%s), select one with --config-map", len(refs), strings.Join(refs, ", "))
+}
+
+// proxy returns Proxy using the client, events are dropped.
+func (o *options) proxy(ctx context.Context, c client.Client) (*proxy.Proxy, *config.Config, error) {
+	cfg, err := o.config(ctx, c)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	nodeCache, err := loadNodeCache(ctx, c)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var cidrCache *cidrcache.CIDRCache
+	if cfg.Proxy.AutoCIDRs {
+		cidrCache, err = loadCIDRCache(ctx, c, cfg)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	return proxy.New(c, c, &record.FakeRecorder{}, config.NewStore(cfg), nodeCache, cidrCache), cfg, nil
+}
+
+// loadNodeCache fills node cache the same way the node watch does.
+func loadNodeCache(ctx context.Context, c client.Reader) (*nodecache.NodeIPCache, error) {
+	var nodes = new(v1.NodeList)
+	if err := c.List(ctx, nodes); err != nil {
+		return nil, fmt.Errorf("unable to list nodes, %w", err)
+	}
+
+	nodeCache := nodecache.NewNodeIPCache()
+	nodeCache.SetNodes(nodes.Items)
+	return nodeCache, nil
+}
+
+// loadCIDRCache derives source CIDRs from the kubernetes API endpoints, the way the apiserver controller does.
+func loadCIDRCache(ctx context.Context, c client.Reader, cfg *config.Config) (*cidrcache.CIDRCache, error) {
+	endpointSlices, err := cidrcache.ListAPIServerEndpointSlices(ctx, c)
+	if err != nil {
+		return nil, err
+	}
+
+	cidrCache := cidrcache.NewCIDRCache()
+	cidrCache.Set(cidrcache.FromEndpointSlices(endpointSlices, cfg.Proxy.AutoCIDRSupernets))
+	return cidrCache, nil
+}
+
+// print writes v in json or yaml format.
+func (o *options) print(v interface{}) error {
+	switch o.output {
+	case OutputJSON:
+		encoder := json.NewEncoder(o.out)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(v)
+	case OutputYAML:
+		data, err := yaml.Marshal(v)
+		if err != nil {
+			return err
+		}
+		_, err = o.out.Write(data)
+		return err
+	default:
+		return fmt.Errorf("unknown output format %q", o.output)
+	}
+}
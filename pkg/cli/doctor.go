@@ -0,0 +1,488 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/CharlieR-o-o-t/eks-webhook-proxy/config"
+	"github.com/CharlieR-o-o-t/eks-webhook-proxy/pkg/cidrcache"
+	"github.com/CharlieR-o-o-t/eks-webhook-proxy/pkg/proxy"
+	"github.com/CharlieR-o-o-t/eks-webhook-proxy/pkg/utils"
+	"github.com/CharlieR-o-o-t/eks-webhook-proxy/pkg/webhookref"
+	"github.com/spf13/pflag"
+	appsv1 "k8s.io/api/apps/v1"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// Check results.
+const (
+	CheckPass = "Pass"
+	CheckWarn = "Warn"
+	CheckFail = "Fail"
+)
+
+// quotaNodePorts is the ResourceQuota resource limiting node ports of a namespace.
+const quotaNodePorts v1.ResourceName = "services.nodeports"
+
+// kubeProxyReplacement is the cilium-config key which enables NodePort handling by Cilium.
+const kubeProxyReplacement = "kube-proxy-replacement"
+
+// rbacRule is a permission the controller needs, see the chart ClusterRole.
+type rbacRule struct {
+	group     string
+	resources []string
+	verbs     []string
+}
+
+var (
+	readVerbs  = []string{"get", "list", "watch"}
+	writeVerbs = []string{"get", "list", "watch", "create", "update", "patch", "delete"}
+
+	controllerRules = []rbacRule{
+		{group: "", resources: []string{"nodes", "namespaces"}, verbs: readVerbs},
+		{group: "", resources: []string{"pods"}, verbs: []string{"get", "list"}},
+		{group: "", resources: []string{"events"}, verbs: []string{"create", "patch"}},
+		{group: "", resources: []string{"services"}, verbs: writeVerbs},
+		{group: "discovery.k8s.io", resources: []string{"endpointslices"}, verbs: writeVerbs},
+		{group: "apiextensions.k8s.io", resources: []string{"customresourcedefinitions"}, verbs: readVerbs},
+		{group: "admissionregistration.k8s.io", resources: []string{"mutatingwebhookconfigurations", "validatingwebhookconfigurations"}, verbs: readVerbs},
+		{group: "networking.k8s.io", resources: []string{"networkpolicies"}, verbs: writeVerbs},
+		{group: "webhookproxy.infra.io", resources: []string{"webhookproxies", "webhookproxyconfigs"}, verbs: writeVerbs},
+		{group: "webhookproxy.infra.io", resources: []string{"webhookproxies/status", "webhookproxyconfigs/status"}, verbs: []string{"get", "update", "patch"}},
+	}
+	calicoRules = []rbacRule{
+		{group: "projectcalico.org", resources: []string{"networkpolicies", "globalnetworksets"}, verbs: writeVerbs},
+	}
+)
+
+type doctorOptions struct {
+	serviceAccount string
+	nodePortRange  string
+}
+
+func init() {
+	doctorOpts := &doctorOptions{}
+	commands["doctor"] = command{
+		short: "Check cluster prerequisites before installing the controller",
+		flags: func(fs *pflag.FlagSet, opts *options) {
+			opts.addOutputFlag(fs)
+			fs.StringVar(&doctorOpts.serviceAccount, "service-account", utils.ControllerName+"/"+utils.ControllerName,
+				"Controller service account as <namespace>/<name>, checked for RBAC.")
+			fs.StringVar(&doctorOpts.nodePortRange, "node-port-range", "30000-32767",
+				"kube-apiserver --service-node-port-range, it is fixed on EKS.")
+		},
+		run: func(ctx context.Context, opts *options, args []string) error {
+			return runDoctor(ctx, opts, doctorOpts)
+		},
+	}
+}
+
+// CheckResult is the outcome of a preflight check, Hint tells how to fix a failed one.
+type CheckResult struct {
+	Check   string `json:"check"`
+	Result  string `json:"result"`
+	Message string `json:"message"`
+	Hint    string `json:"hint,omitempty"`
+}
+
+// pendingService is a webhook service which is proxied, but has no proxy service yet.
+type pendingService struct {
+	key   types.NamespacedName
+	ports int
+}
+
+type doctor struct {
+	client client.Client
+	proxy  *proxy.Proxy
+	cfg    *config.Config
+	opts   *doctorOptions
+
+	pending []pendingService
+}
+
+func runDoctor(ctx context.Context, opts *options, doctorOpts *doctorOptions) error {
+	c, err := opts.client()
+	if err != nil {
+		return err
+	}
+	p, cfg, err := opts.proxy(ctx, c)
+	if err != nil {
+		return err
+	}
+
+	d := &doctor{client: c, proxy: p, cfg: cfg, opts: doctorOpts}
+	if err := d.loadPending(ctx); err != nil {
+		return err
+	}
+
+	results := []CheckResult{
+		d.checkKubeProxy(ctx),
+		d.checkNodePortRange(ctx),
+	}
+	results = append(results, d.checkQuotas(ctx)...)
+	results = append(results, d.checkCIDRs(ctx), d.checkPolicyKinds(), d.checkRBAC(ctx))
+
+	if opts.output == OutputTable {
+		w := tabwriter.NewWriter(opts.out, 0, 0, 3, ' ', 0)
+		fmt.Fprintln(w, "CHECK\tRESULT\tMESSAGE")
+		for _, result := range results {
+			fmt.Fprintf(w, "%s\t%s\t%s\n", result.Check, result.Result, result.Message)
+			if result.Hint != "" {
+				fmt.Fprintf(w, "\t\thint: %s\n", result.Hint)
+			}
+		}
+		if err := w.Flush(); err != nil {
+			return err
+		}
+	} else if err := opts.print(results); err != nil {
+		return err
+	}
+
+	var failed int
+	for _, result := range results {
+		if result.Result == CheckFail {
+			failed++
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d checks failed", failed)
+	}
+	return nil
+}
+
+// loadPending collects webhook services which need a new proxy service, they consume node ports on install.
+func (d *doctor) loadPending(ctx context.Context) error {
+	services, err := webhookref.ListSelected(ctx, d.client, d.cfg.Proxy.SelectionMode)
+	if err != nil {
+		return err
+	}
+
+	for serviceKey := range services {
+		if d.proxy.IsExcluded(serviceKey.Namespace) {
+			continue
+		}
+
+		var serviceOrigin = new(v1.Service)
+		if err := d.client.Get(ctx, serviceKey, serviceOrigin); err != nil {
+			if apierrors.IsNotFound(err) {
+				continue
+			}
+			return fmt.Errorf("unable to get service %s, %w", serviceKey, err)
+		}
+		if serviceOrigin.Spec.Type != v1.ServiceTypeClusterIP {
+			continue
+		}
+
+		var proxyServices = new(v1.ServiceList)
+		if err := d.client.List(ctx, proxyServices,
+			client.InNamespace(serviceKey.Namespace),
+			client.MatchingLabels{utils.LabelManagedBy: utils.ControllerName, utils.LabelServiceProxyOf: serviceKey.Name},
+		); err != nil {
+			return fmt.Errorf("unable to list proxy services, %w", err)
+		}
+		if len(proxyServices.Items) == 0 {
+			d.pending = append(d.pending, pendingService{key: serviceKey, ports: len(serviceOrigin.Spec.Ports)})
+		}
+	}
+	return nil
+}
+
+func (d *doctor) pendingPorts(namespace string) int {
+	var ports int
+	for _, pending := range d.pending {
+		if namespace == "" || pending.key.Namespace == namespace {
+			ports += pending.ports
+		}
+	}
+	return ports
+}
+
+// checkKubeProxy tells if NodePorts are implemented on nodes, by kube-proxy or by Cilium kube-proxy replacement.
+func (d *doctor) checkKubeProxy(ctx context.Context) CheckResult {
+	result := CheckResult{Check: "kube-proxy"}
+
+	var daemonSet = new(appsv1.DaemonSet)
+	err := d.client.Get(ctx, types.NamespacedName{Namespace: metav1.NamespaceSystem, Name: "kube-proxy"}, daemonSet)
+	switch {
+	case err == nil && daemonSet.Status.NumberReady < daemonSet.Status.DesiredNumberScheduled:
+		result.Result = CheckWarn
+		result.Message = fmt.Sprintf("kube-proxy is ready on %d of %d nodes", daemonSet.Status.NumberReady, daemonSet.Status.DesiredNumberScheduled)
+		result.Hint = "node endpoints on nodes without ready kube-proxy do not forward NodePort traffic, check kube-proxy pods"
+		return result
+	case err == nil:
+		result.Result = CheckPass
+		result.Message = fmt.Sprintf("kube-proxy is ready on %d nodes", daemonSet.Status.NumberReady)
+		return result
+	case !apierrors.IsNotFound(err):
+		return errorResult(result, err)
+	}
+
+	var ciliumConfig = new(v1.ConfigMap)
+	err = d.client.Get(ctx, types.NamespacedName{Namespace: metav1.NamespaceSystem, Name: "cilium-config"}, ciliumConfig)
+	if err != nil && !apierrors.IsNotFound(err) {
+		return errorResult(result, err)
+	}
+	switch ciliumConfig.Data[kubeProxyReplacement] {
+	case "true", "strict":
+		result.Result = CheckPass
+		result.Message = "Cilium kube-proxy replacement handles NodePorts"
+	default:
+		result.Result = CheckFail
+		result.Message = "neither kube-proxy nor Cilium kube-proxy replacement is found"
+		result.Hint = "install the kube-proxy EKS add-on, or enable " + kubeProxyReplacement + " in Cilium"
+	}
+	return result
+}
+
+// checkNodePortRange tells if the range has free node ports for all pending proxy services.
+func (d *doctor) checkNodePortRange(ctx context.Context) CheckResult {
+	result := CheckResult{Check: "node-port-range"}
+
+	low, high, err := parsePortRange(d.opts.nodePortRange)
+	if err != nil {
+		return errorResult(result, err)
+	}
+
+	var services = new(v1.ServiceList)
+	if err := d.client.List(ctx, services); err != nil {
+		return errorResult(result, err)
+	}
+	var used, outside int
+	for _, service := range services.Items {
+		for _, port := range service.Spec.Ports {
+			switch {
+			case port.NodePort == 0:
+			case port.NodePort < low || port.NodePort > high:
+				outside++
+			default:
+				used++
+			}
+		}
+	}
+
+	free := int(high-low+1) - used
+	needed := d.pendingPorts("")
+	switch {
+	case free < needed:
+		result.Result = CheckFail
+		result.Message = fmt.Sprintf("%d node ports are free in %s, %d are needed", free, d.opts.nodePortRange, needed)
+		result.Hint = "delete unused NodePort and LoadBalancer services, or limit proxied ports with " + utils.AnnotationProxyPorts
+	case outside > 0:
+		result.Result = CheckWarn
+		result.Message = fmt.Sprintf("%d allocated node ports are outside of %s", outside, d.opts.nodePortRange)
+		result.Hint = "set --node-port-range to the kube-apiserver --service-node-port-range"
+	default:
+		result.Result = CheckPass
+		result.Message = fmt.Sprintf("%d node ports are free in %s, %d are needed", free, d.opts.nodePortRange, needed)
+	}
+	return result
+}
+
+// checkQuotas tells if ResourceQuotas of webhook namespaces allow node ports of pending proxy services.
+func (d *doctor) checkQuotas(ctx context.Context) []CheckResult {
+	namespaces := make(map[string]struct{})
+	for _, pending := range d.pending {
+		namespaces[pending.key.Namespace] = struct{}{}
+	}
+
+	var results []CheckResult
+	for _, namespace := range slices.Sorted(maps.Keys(namespaces)) {
+		result := CheckResult{Check: "quota/" + namespace}
+
+		var quotas = new(v1.ResourceQuotaList)
+		if err := d.client.List(ctx, quotas, client.InNamespace(namespace)); err != nil {
+			results = append(results, errorResult(result, err))
+			continue
+		}
+
+		needed := d.pendingPorts(namespace)
+		result.Result = CheckPass
+		result.Message = fmt.Sprintf("%d node ports are needed, no quota limits them", needed)
+		for _, quota := range quotas.Items {
+			hard, ok := quota.Status.Hard[quotaNodePorts]
+			if !ok {
+				continue
+			}
+			used := quota.Status.Used[quotaNodePorts]
+			headroom := hard.Value() - used.Value()
+			if headroom < int64(needed) {
+				result.Result = CheckFail
+				result.Message = fmt.Sprintf("quota %s allows %d more node ports, %d are needed", quota.Name, headroom, needed)
+				result.Hint = fmt.Sprintf("raise %s of quota %s/%s, or exclude the namespace with EXCLUDED_NAMESPACES", quotaNodePorts, namespace, quota.Name)
+				break
+			}
+			result.Message = fmt.Sprintf("quota %s allows %d more node ports, %d are needed", quota.Name, headroom, needed)
+		}
+		results = append(results, result)
+	}
+	return results
+}
+
+// checkCIDRs tells if allowed source CIDRs contain kube-apiserver endpoint addresses of the restricted mode.
+func (d *doctor) checkCIDRs(ctx context.Context) CheckResult {
+	result := CheckResult{Check: "source-cidrs"}
+
+	switch {
+	case !d.cfg.Proxy.Restricted:
+		result.Result = CheckPass
+		result.Message = "restricted mode is disabled by default, CIDRs are not used"
+		return result
+	case d.cfg.Proxy.AutoCIDRs:
+		result.Result = CheckPass
+		result.Message = "CIDRs are derived from kube-apiserver endpoints"
+		return result
+	}
+
+	endpointSlices, err := cidrcache.ListAPIServerEndpointSlices(ctx, d.client)
+	if err != nil {
+		return errorResult(result, err)
+	}
+
+	var networks []*net.IPNet
+	for _, cidr := range d.cfg.Proxy.AllowedSrcCIDRs {
+		if _, network, err := net.ParseCIDR(cidr); err == nil {
+			networks = append(networks, network)
+		}
+	}
+
+	var uncovered []string
+	for _, endpointSlice := range endpointSlices {
+		for _, endpoint := range endpointSlice.Endpoints {
+			for _, address := range endpoint.Addresses {
+				if !containsIP(networks, net.ParseIP(address)) {
+					uncovered = append(uncovered, address)
+				}
+			}
+		}
+	}
+
+	if len(uncovered) > 0 {
+		result.Result = CheckFail
+		result.Message = fmt.Sprintf("kube-apiserver addresses %s are not in allowed CIDRs", strings.Join(uncovered, ", "))
+		result.Hint = "add the control plane subnets to ALLOWED_CIDRS, or enable AUTO_CIDRS"
+		return result
+	}
+	result.Result = CheckPass
+	result.Message = "allowed CIDRs contain all kube-apiserver addresses"
+	return result
+}
+
+// checkPolicyKinds tells if the cluster serves policy kinds of the selected backend.
+func (d *doctor) checkPolicyKinds() CheckResult {
+	result := CheckResult{Check: "policy-backend"}
+
+	var missing []string
+	for _, gvk := range proxy.PolicyKinds(d.cfg.Proxy.PolicyBackend) {
+		if _, err := d.client.RESTMapper().RESTMapping(gvk.GroupKind(), gvk.Version); err != nil {
+			if !meta.IsNoMatchError(err) {
+				return errorResult(result, err)
+			}
+			missing = append(missing, gvk.GroupVersion().String()+" "+gvk.Kind)
+		}
+	}
+
+	if len(missing) > 0 {
+		result.Result = CheckFail
+		result.Message = fmt.Sprintf("%s backend needs %s", d.cfg.Proxy.PolicyBackend, strings.Join(missing, ", "))
+		result.Hint = "install the Calico API server, or set POLICY_BACKEND=" + config.PolicyBackendKubernetes
+		return result
+	}
+	result.Result = CheckPass
+	result.Message = fmt.Sprintf("%s backend kinds are served", d.cfg.Proxy.PolicyBackend)
+	return result
+}
+
+// checkRBAC tells if the controller service account has permissions of the chart ClusterRole.
+func (d *doctor) checkRBAC(ctx context.Context) CheckResult {
+	result := CheckResult{Check: "rbac"}
+
+	namespace, name, ok := strings.Cut(d.opts.serviceAccount, "/")
+	if !ok {
+		return errorResult(result, fmt.Errorf("invalid service account %q, expected <namespace>/<name>", d.opts.serviceAccount))
+	}
+
+	rules := controllerRules
+	if d.cfg.Proxy.PolicyBackend == config.PolicyBackendCalico {
+		rules = slices.Concat(controllerRules, calicoRules)
+	}
+
+	var denied []string
+	for _, rule := range rules {
+		for _, resource := range rule.resources {
+			resourceName, subresource, _ := strings.Cut(resource, "/")
+			for _, verb := range rule.verbs {
+				review := &authorizationv1.SubjectAccessReview{
+					Spec: authorizationv1.SubjectAccessReviewSpec{
+						User:   fmt.Sprintf("system:serviceaccount:%s:%s", namespace, name),
+						Groups: []string{"system:serviceaccounts", "system:serviceaccounts:" + namespace},
+						ResourceAttributes: &authorizationv1.ResourceAttributes{
+							Group:       rule.group,
+							Resource:    resourceName,
+							Subresource: subresource,
+							Verb:        verb,
+						},
+					},
+				}
+				if err := d.client.Create(ctx, review); err != nil {
+					if apierrors.IsForbidden(err) {
+						result.Result = CheckWarn
+						result.Message = "unable to review access of the service account"
+						result.Hint = "run doctor with permission to create subjectaccessreviews"
+						return result
+					}
+					return errorResult(result, err)
+				}
+				if !review.Status.Allowed {
+					denied = append(denied, verb+" "+resource)
+				}
+			}
+		}
+	}
+
+	if len(denied) > 0 {
+		result.Result = CheckFail
+		result.Message = fmt.Sprintf("%s is not allowed to %s", d.opts.serviceAccount, strings.Join(denied, ", "))
+		result.Hint = "install the chart ClusterRole and its binding, or set --service-account to the controller service account"
+		return result
+	}
+	result.Result = CheckPass
+	result.Message = fmt.Sprintf("%s has all controller permissions", d.opts.serviceAccount)
+	return result
+}
+
+func errorResult(result CheckResult, err error) CheckResult {
+	result.Result = CheckFail
+	result.Message = err.Error()
+	return result
+}
+
+func parsePortRange(portRange string) (int32, int32, error) {
+	lowVal, highVal, ok := strings.Cut(portRange, "-")
+	low, lowErr := strconv.ParseInt(lowVal, 10, 32)
+	high, highErr := strconv.ParseInt(highVal, 10, 32)
+	if !ok || lowErr != nil || highErr != nil || low > high {
+		return 0, 0, fmt.Errorf("invalid node port range %q, expected <low>-<high>", portRange)
+	}
+	return int32(low), int32(high), nil
+}
+
+func containsIP(networks []*net.IPNet, ip net.IP) bool {
+	for _, network := range networks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
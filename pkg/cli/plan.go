@@ -0,0 +1,383 @@
+package cli
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/CharlieR-o-o-t/eks-webhook-proxy/pkg/proxy"
+	"github.com/CharlieR-o-o-t/eks-webhook-proxy/pkg/webhookref"
+	"github.com/pmezard/go-difflib/difflib"
+	"github.com/spf13/pflag"
+	admissionv1 "k8s.io/api/admissionregistration/v1"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/apiutil"
+	"sigs.k8s.io/yaml"
+)
+
+// Planned operations.
+const (
+	PlanCreate = "create"
+	PlanUpdate = "update"
+	PlanStrip  = "strip"
+	PlanDelete = "delete"
+)
+
+func init() {
+	commands["plan"] = command{
+		short: "Print changes the controller would make, nothing is persisted",
+		flags: func(fs *pflag.FlagSet, opts *options) {
+			opts.addOutputFlag(fs)
+			opts.addNamespaceFlag(fs)
+		},
+		run: runPlan,
+	}
+}
+
+// PlannedChange is a change of a single object, Diff is unified diff of the object YAML.
+type PlannedChange struct {
+	Operation string `json:"operation"`
+	Kind      string `json:"kind"`
+	Object    string `json:"object"`
+	Diff      string `json:"diff,omitempty"`
+
+	before, after client.Object
+}
+
+// PlanError is a webhook service the controller would fail to proxy.
+type PlanError struct {
+	Service string `json:"service"`
+	Error   string `json:"error"`
+}
+
+// Plan is the result of plan command.
+type Plan struct {
+	Changes []PlannedChange `json:"changes"`
+	Errors  []PlanError     `json:"errors,omitempty"`
+}
+
+func runPlan(ctx context.Context, opts *options, _ []string) error {
+	c, err := opts.client()
+	if err != nil {
+		return err
+	}
+
+	planner := &planClient{Client: c, overlay: make(map[planKey]client.Object)}
+	p, cfg, err := opts.proxy(ctx, planner)
+	if err != nil {
+		return err
+	}
+
+	services, err := webhookref.ListSelected(ctx, c, cfg.Proxy.SelectionMode)
+	if err != nil {
+		return err
+	}
+
+	// Services are ensured one by one with the controller code path, writes are sent with dryRun=All.
+	var plan Plan
+	for _, serviceKey := range sortedServices(services, opts.namespace) {
+		serviceRef := &admissionv1.ServiceReference{Namespace: serviceKey.Namespace, Name: serviceKey.Name}
+		if err := p.EnsureWebhookService(ctx, serviceRef); err != nil {
+			if errors.Is(err, proxy.ErrServiceNotFound) || errors.Is(err, proxy.ErrServiceNotProxied) {
+				continue
+			}
+			plan.Errors = append(plan.Errors, PlanError{Service: serviceKey.String(), Error: err.Error()})
+		}
+	}
+
+	for _, change := range planner.changes {
+		diff, err := objectDiff(change)
+		if err != nil {
+			return err
+		}
+		if diff == "" {
+			continue
+		}
+		change.Diff = diff
+		plan.Changes = append(plan.Changes, *change)
+	}
+
+	if opts.output != OutputTable {
+		return opts.print(plan)
+	}
+
+	counts := make(map[string]int)
+	for _, change := range plan.Changes {
+		fmt.Fprintf(opts.out, "# %s %s %s\n%s\n", change.Operation, change.Kind, change.Object, change.Diff)
+		counts[change.Operation]++
+	}
+	for _, planError := range plan.Errors {
+		fmt.Fprintf(opts.out, "# error %s: %s\n", planError.Service, planError.Error)
+	}
+	fmt.Fprintf(opts.out, "Plan: %d to create, %d to update, %d to strip, %d to delete, %d errors.\n",
+		counts[PlanCreate], counts[PlanUpdate], counts[PlanStrip], counts[PlanDelete], len(plan.Errors))
+	return nil
+}
+
+type planKey struct {
+	gvk schema.GroupVersionKind
+	key types.NamespacedName
+}
+
+// planClient sends writes with dryRun=All and records them as planned changes.
+// Dry-run results are kept in overlay and returned by Get, so later steps see planned objects.
+type planClient struct {
+	client.Client
+
+	overlay map[planKey]client.Object
+	changes []*PlannedChange
+	// byKey indexes changes, repeated writes of an object are merged into one change.
+	byKey map[planKey]*PlannedChange
+}
+
+func (c *planClient) planKey(obj client.Object) (planKey, error) {
+	gvk, err := apiutil.GVKForObject(obj, c.Scheme())
+	if err != nil {
+		return planKey{}, err
+	}
+	return planKey{gvk: gvk, key: client.ObjectKeyFromObject(obj)}, nil
+}
+
+func (c *planClient) Get(ctx context.Context, key client.ObjectKey, obj client.Object, opts ...client.GetOption) error {
+	k, err := c.planKey(obj)
+	if err != nil {
+		return err
+	}
+	k.key = key
+
+	planned, ok := c.overlay[k]
+	if !ok {
+		return c.Client.Get(ctx, key, obj, opts...)
+	}
+	if planned == nil {
+		return apierrors.NewNotFound(schema.GroupResource{Group: k.gvk.Group, Resource: k.gvk.Kind}, key.Name)
+	}
+	return copyObject(planned, obj, k.gvk)
+}
+
+func (c *planClient) Create(ctx context.Context, obj client.Object, opts ...client.CreateOption) error {
+	k, err := c.planKey(obj)
+	if err != nil {
+		return err
+	}
+	if err := c.Client.Create(ctx, obj, append(opts, client.DryRunAll)...); err != nil {
+		return err
+	}
+	c.record(k, PlanCreate, nil, obj)
+	return nil
+}
+
+func (c *planClient) Update(ctx context.Context, obj client.Object, opts ...client.UpdateOption) error {
+	k, err := c.planKey(obj)
+	if err != nil {
+		return err
+	}
+
+	// Object planned for creation does not exist on the server, the create is updated instead.
+	if change, ok := c.byKey[k]; ok && change.Operation == PlanCreate {
+		c.record(k, PlanCreate, nil, obj)
+		return nil
+	}
+
+	before, err := c.current(ctx, k, obj)
+	if err != nil {
+		return err
+	}
+	if err := c.Client.Update(ctx, obj, append(opts, client.DryRunAll)...); err != nil {
+		return err
+	}
+
+	operation := PlanUpdate
+	if service, ok := obj.(*v1.Service); ok && len(service.Spec.Selector) == 0 && len(before.(*v1.Service).Spec.Selector) > 0 {
+		operation = PlanStrip
+	}
+	c.record(k, operation, before, obj)
+	return nil
+}
+
+func (c *planClient) Delete(ctx context.Context, obj client.Object, opts ...client.DeleteOption) error {
+	k, err := c.planKey(obj)
+	if err != nil {
+		return err
+	}
+
+	// Object planned for creation is dropped from the plan.
+	if change, ok := c.byKey[k]; ok && change.Operation == PlanCreate {
+		c.record(k, PlanDelete, nil, nil)
+		return nil
+	}
+
+	before, err := c.current(ctx, k, obj)
+	if err != nil {
+		return err
+	}
+	if err := c.Client.Delete(ctx, obj, append(opts, client.DryRunAll)...); err != nil {
+		return err
+	}
+	c.record(k, PlanDelete, before, nil)
+	return nil
+}
+
+// Patch plans server-side apply with dryRun=All. Other patches carry the whole patched object,
+// they are planned as its update.
+func (c *planClient) Patch(ctx context.Context, obj client.Object, patch client.Patch, opts ...client.PatchOption) error {
+	if patch.Type() != types.ApplyPatchType {
+		return c.Update(ctx, obj)
+	}
+
+	k, err := c.planKey(obj)
+	if err != nil {
+		return err
+	}
+
+	operation := PlanUpdate
+	before, err := c.current(ctx, k, obj)
+	if err != nil {
+		if !apierrors.IsNotFound(err) {
+			return err
+		}
+		operation = PlanCreate
+	}
+	if change, ok := c.byKey[k]; ok && change.Operation == PlanCreate {
+		operation = PlanCreate
+	}
+
+	if err := c.Client.Patch(ctx, obj, patch, append(opts, client.DryRunAll)...); err != nil {
+		return err
+	}
+	c.record(k, operation, before, obj)
+	return nil
+}
+
+// Status refuses writes, status updates are not planned.
+func (c *planClient) Status() client.SubResourceWriter {
+	return planSubResourceWriter{}
+}
+
+// SubResource refuses writes, subresource updates are not planned.
+func (c *planClient) SubResource(string) client.SubResourceClient {
+	return planSubResourceWriter{}
+}
+
+type planSubResourceWriter struct{}
+
+var errSubResourceNotPlanned = errors.New("subresource writes are not supported by plan")
+
+func (planSubResourceWriter) Get(context.Context, client.Object, client.Object, ...client.SubResourceGetOption) error {
+	return errSubResourceNotPlanned
+}
+
+func (planSubResourceWriter) Create(context.Context, client.Object, client.Object, ...client.SubResourceCreateOption) error {
+	return errSubResourceNotPlanned
+}
+
+func (planSubResourceWriter) Update(context.Context, client.Object, ...client.SubResourceUpdateOption) error {
+	return errSubResourceNotPlanned
+}
+
+func (planSubResourceWriter) Patch(context.Context, client.Object, client.Patch, ...client.SubResourcePatchOption) error {
+	return errSubResourceNotPlanned
+}
+
+// current returns object state before the planned change.
+func (c *planClient) current(ctx context.Context, k planKey, obj client.Object) (client.Object, error) {
+	if change, ok := c.byKey[k]; ok {
+		return change.before, nil
+	}
+	before, ok := obj.DeepCopyObject().(client.Object)
+	if !ok {
+		return nil, fmt.Errorf("unable to copy %s", k.key)
+	}
+	if err := c.Client.Get(ctx, k.key, before); err != nil {
+		return nil, err
+	}
+	return before, nil
+}
+
+func (c *planClient) record(k planKey, operation string, before, after client.Object) {
+	if after != nil {
+		after = after.DeepCopyObject().(client.Object)
+		after.GetObjectKind().SetGroupVersionKind(k.gvk)
+	}
+	c.overlay[k] = after
+
+	if c.byKey == nil {
+		c.byKey = make(map[planKey]*PlannedChange)
+	}
+	change, ok := c.byKey[k]
+	if !ok {
+		change = &PlannedChange{Kind: k.gvk.Kind, Object: k.key.String(), before: before}
+		c.byKey[k] = change
+		c.changes = append(c.changes, change)
+	}
+	// Strip is kept over a later update of the same service.
+	if change.Operation != PlanStrip || operation == PlanDelete {
+		change.Operation = operation
+	}
+	change.after = after
+}
+
+// copyObject copies src into dst, which may be of a different go type of the same kind.
+func copyObject(src, dst client.Object, gvk schema.GroupVersionKind) error {
+	data, err := json.Marshal(src)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, dst); err != nil {
+		return err
+	}
+	dst.GetObjectKind().SetGroupVersionKind(gvk)
+	return nil
+}
+
+// objectDiff returns unified diff of object YAML, fields set by the server are omitted.
+func objectDiff(change *PlannedChange) (string, error) {
+	before, err := objectYAML(change.before)
+	if err != nil {
+		return "", err
+	}
+	after, err := objectYAML(change.after)
+	if err != nil {
+		return "", err
+	}
+	if before == after {
+		return "", nil
+	}
+
+	return difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+		A:        difflib.SplitLines(before),
+		B:        difflib.SplitLines(after),
+		FromFile: "live",
+		ToFile:   "planned",
+		Context:  3,
+	})
+}
+
+func objectYAML(obj client.Object) (string, error) {
+	if obj == nil {
+		return "", nil
+	}
+
+	content, err := runtime.DefaultUnstructuredConverter.ToUnstructured(obj)
+	if err != nil {
+		return "", err
+	}
+	for _, field := range []string{"managedFields", "resourceVersion", "uid", "creationTimestamp", "generation"} {
+		unstructured.RemoveNestedField(content, "metadata", field)
+	}
+	unstructured.RemoveNestedField(content, "status")
+
+	data, err := yaml.Marshal(content)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(string(data), "\n") + "\n", nil
+}
@@ -0,0 +1,250 @@
+package cli
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/CharlieR-o-o-t/eks-webhook-proxy/pkg/proxy"
+	"github.com/CharlieR-o-o-t/eks-webhook-proxy/pkg/webhookref"
+	"github.com/spf13/pflag"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	utilyaml "k8s.io/apimachinery/pkg/util/yaml"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/apiutil"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/yaml"
+)
+
+type renderOptions struct {
+	filenames     []string
+	nodePortRange string
+}
+
+func init() {
+	renderOpts := &renderOptions{}
+	commands["render"] = command{
+		short: "Render proxy manifests offline from YAML files, for GitOps-owned services",
+		flags: func(fs *pflag.FlagSet, opts *options) {
+			opts.addNamespaceFlag(fs)
+			fs.StringArrayVarP(&renderOpts.filenames, "filename", "f", nil,
+				"YAML file or directory with webhook configurations, services, endpoint slices, nodes and pods, - reads stdin.")
+			fs.StringVar(&renderOpts.nodePortRange, "node-port-range", "30000-32767",
+				"Range of node ports allocated to new proxy services.")
+		},
+		run: func(ctx context.Context, opts *options, args []string) error {
+			return runRender(ctx, opts, renderOpts)
+		},
+	}
+}
+
+func runRender(ctx context.Context, opts *options, renderOpts *renderOptions) error {
+	if len(renderOpts.filenames) == 0 {
+		return fmt.Errorf("at least one --filename is required")
+	}
+
+	var objects []client.Object
+	for _, filename := range renderOpts.filenames {
+		fileObjects, err := loadObjects(filename)
+		if err != nil {
+			return err
+		}
+		objects = append(objects, fileObjects...)
+	}
+
+	// Snapshot is served by fake client, so the controller reads it the same way as a live cluster.
+	c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(objects...).Build()
+	p, cfg, err := opts.proxy(ctx, c)
+	if err != nil {
+		return err
+	}
+
+	allocate, err := nodePortAllocator(objects, renderOpts.nodePortRange)
+	if err != nil {
+		return err
+	}
+
+	services, err := webhookref.ListSelected(ctx, c, cfg.Proxy.SelectionMode)
+	if err != nil {
+		return err
+	}
+
+	var rendered []client.Object
+	for _, serviceKey := range sortedServices(services, opts.namespace) {
+		var serviceOrigin = new(v1.Service)
+		if err := c.Get(ctx, serviceKey, serviceOrigin); err != nil {
+			if apierrors.IsNotFound(err) {
+				fmt.Fprintf(os.Stderr, "skipping %s, service is not found in files\n", serviceKey)
+				continue
+			}
+			return err
+		}
+
+		serviceObjects, err := p.RenderService(ctx, serviceOrigin, allocate)
+		if err != nil {
+			if errors.Is(err, proxy.ErrServiceNotProxied) {
+				continue
+			}
+			return err
+		}
+		rendered = append(rendered, serviceObjects...)
+	}
+
+	return writeManifests(opts.out, rendered)
+}
+
+// loadObjects decodes objects of known kinds from YAML or JSON file, directory or stdin.
+// List kinds, e.g. kubectl get -o yaml output, are flattened.
+func loadObjects(filename string) ([]client.Object, error) {
+	if filename == "-" {
+		return decodeObjects(os.Stdin, "stdin")
+	}
+
+	info, err := os.Stat(filename)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		file, err := os.Open(filename)
+		if err != nil {
+			return nil, err
+		}
+		defer file.Close()
+		return decodeObjects(file, filename)
+	}
+
+	var objects []client.Object
+	entries, err := os.ReadDir(filename)
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		switch filepath.Ext(entry.Name()) {
+		case ".yaml", ".yml", ".json":
+		default:
+			continue
+		}
+		fileObjects, err := loadObjects(filepath.Join(filename, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		objects = append(objects, fileObjects...)
+	}
+	return objects, nil
+}
+
+func decodeObjects(r io.Reader, source string) ([]client.Object, error) {
+	var objects []client.Object
+	decoder := utilyaml.NewYAMLOrJSONDecoder(bufio.NewReader(r), 4096)
+	for {
+		obj := new(unstructured.Unstructured)
+		if err := decoder.Decode(&obj.Object); err != nil {
+			if err == io.EOF {
+				return objects, nil
+			}
+			return nil, fmt.Errorf("unable to decode %s, %w", source, err)
+		}
+		if len(obj.Object) == 0 {
+			continue
+		}
+
+		items := []unstructured.Unstructured{*obj}
+		if obj.IsList() {
+			list, err := obj.ToList()
+			if err != nil {
+				return nil, fmt.Errorf("unable to decode list in %s, %w", source, err)
+			}
+			items = list.Items
+		}
+
+		for _, item := range items {
+			typed, err := scheme.New(item.GroupVersionKind())
+			if err != nil {
+				// Kinds the controller does not read are skipped.
+				continue
+			}
+			if err := runtime.DefaultUnstructuredConverter.FromUnstructured(item.Object, typed); err != nil {
+				return nil, fmt.Errorf("unable to decode %s %s in %s, %w", item.GetKind(), item.GetName(), source, err)
+			}
+			typedObj, ok := typed.(client.Object)
+			if !ok {
+				continue
+			}
+			typedObj.SetResourceVersion("")
+			objects = append(objects, typedObj)
+		}
+	}
+}
+
+// nodePortAllocator returns allocator of node ports which are not used by services in objects.
+// Ports are allocated in ascending order, so rendering the same files gives the same result.
+func nodePortAllocator(objects []client.Object, portRange string) (proxy.NodePortAllocator, error) {
+	low, high, err := parsePortRange(portRange)
+	if err != nil {
+		return nil, err
+	}
+
+	used := make(map[int32]struct{})
+	for _, obj := range objects {
+		if service, ok := obj.(*v1.Service); ok {
+			for _, port := range service.Spec.Ports {
+				used[port.NodePort] = struct{}{}
+			}
+		}
+	}
+
+	next := low
+	return func(v1.ServicePort) (int32, error) {
+		for ; next <= high; next++ {
+			if _, ok := used[next]; !ok {
+				used[next] = struct{}{}
+				return next, nil
+			}
+		}
+		return 0, fmt.Errorf("no free node port in %s", portRange)
+	}, nil
+}
+
+// writeManifests writes objects as multi document YAML. Fields set by the server and owner references
+// are dropped, objects are owned by the GitOps tool. Shared objects are written once.
+func writeManifests(w io.Writer, objects []client.Object) error {
+	written := make(map[string]struct{})
+	for _, obj := range objects {
+		gvk, err := apiutil.GVKForObject(obj, scheme)
+		if err != nil {
+			return err
+		}
+		key := gvk.String() + "/" + client.ObjectKeyFromObject(obj).String()
+		if _, ok := written[key]; ok {
+			continue
+		}
+		written[key] = struct{}{}
+
+		content, err := runtime.DefaultUnstructuredConverter.ToUnstructured(obj)
+		if err != nil {
+			return err
+		}
+		content["apiVersion"], content["kind"] = gvk.GroupVersion().String(), gvk.Kind
+		for _, field := range []string{"ownerReferences", "creationTimestamp", "resourceVersion", "uid"} {
+			unstructured.RemoveNestedField(content, "metadata", field)
+		}
+		unstructured.RemoveNestedField(content, "status")
+
+		data, err := yaml.Marshal(content)
+		if err != nil {
+			return err
+		}
+		if _, err := fmt.Fprintf(w, "---\n%s", strings.TrimPrefix(string(data), "---\n")); err != nil {
+			return err
+		}
+	}
+	return nil
+}
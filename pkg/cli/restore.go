@@ -0,0 +1,166 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	"github.com/CharlieR-o-o-t/eks-webhook-proxy/pkg/proxy"
+	"github.com/CharlieR-o-o-t/eks-webhook-proxy/pkg/utils"
+	"github.com/spf13/pflag"
+	appsv1 "k8s.io/api/apps/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// Verification results of restored services.
+const (
+	restoreActionEndpointsReady   = "EndpointsReady"
+	restoreActionEndpointsMissing = "EndpointsMissing"
+)
+
+// endpointsPollInterval is the interval of pod endpoints checks after selector restore.
+const endpointsPollInterval = 2 * time.Second
+
+// controllerSelector selects Deployments of the controller installed by the chart.
+var controllerSelector = client.MatchingLabels{"app.kubernetes.io/name": utils.ControllerName}
+
+type restoreOptions struct {
+	dryRun  bool
+	force   bool
+	timeout time.Duration
+}
+
+func init() {
+	restoreOpts := &restoreOptions{}
+	commands["restore"] = command{
+		short: "Revert all changes of the controller, run it after the controller is uninstalled",
+		flags: func(fs *pflag.FlagSet, opts *options) {
+			opts.addOutputFlag(fs)
+			opts.addNamespaceFlag(fs)
+			fs.BoolVar(&restoreOpts.dryRun, "dry-run", false, "Send changes with dryRun=All, nothing is persisted.")
+			fs.DurationVar(&restoreOpts.timeout, "timeout", 2*time.Minute,
+				"How long to wait for pod endpoints of restored services before proxy objects are deleted.")
+			fs.BoolVar(&restoreOpts.force, "force", false, "Restore while the controller Deployment is running.")
+		},
+		run: func(ctx context.Context, opts *options, args []string) error {
+			return runRestore(ctx, opts, restoreOpts)
+		},
+	}
+}
+
+func runRestore(ctx context.Context, opts *options, restoreOpts *restoreOptions) error {
+	c, err := opts.client()
+	if err != nil {
+		return err
+	}
+	p, _, err := opts.proxy(ctx, c)
+	if err != nil {
+		return err
+	}
+
+	if err := checkControllerStopped(ctx, c, restoreOpts.force); err != nil {
+		return err
+	}
+
+	services, err := p.ListTouchedServices(ctx, opts.namespace)
+	if err != nil {
+		return err
+	}
+	sort.Slice(services, func(i, j int) bool {
+		return services[i].String() < services[j].String()
+	})
+
+	// Selector is restored first, proxy objects keep serving the service until the endpointslice
+	// controller publishes ready pod endpoints, they are deleted only after that.
+	var actions []proxy.RestoreAction
+	var missing int
+	for _, serviceKey := range services {
+		serviceActions, err := p.RestoreSelector(ctx, serviceKey, restoreOpts.dryRun)
+		if err != nil {
+			return err
+		}
+		actions = append(actions, serviceActions...)
+
+		if !restoreOpts.dryRun && proxy.SelectorRestored(serviceActions) {
+			action := proxy.RestoreAction{Action: restoreActionEndpointsReady, Kind: "Service", Object: serviceKey.String()}
+			err := wait.PollUntilContextTimeout(ctx, endpointsPollInterval, restoreOpts.timeout, true,
+				func(ctx context.Context) (bool, error) {
+					ready, err := p.PodEndpoints(ctx, serviceKey)
+					return ready > 0, err
+				})
+			if err != nil {
+				action.Action = restoreActionEndpointsMissing
+				missing++
+				actions = append(actions, action, proxy.RestoreAction{
+					Action: proxy.RestoreActionKept,
+					Kind:   "EndpointSlice",
+					Object: types.NamespacedName{Namespace: serviceKey.Namespace, Name: proxy.ProxyEndpointSliceName(serviceKey)}.String(),
+				})
+				continue
+			}
+			actions = append(actions, action)
+		}
+
+		deleteActions, err := p.DeleteProxyObjects(ctx, serviceKey, restoreOpts.dryRun)
+		if err != nil {
+			return err
+		}
+		actions = append(actions, deleteActions...)
+	}
+
+	// Shared objects are kept while a namespace of webhook services may still be proxied.
+	if opts.namespace == "" && missing == 0 {
+		clusterActions, err := p.RestoreClusterObjects(ctx, restoreOpts.dryRun)
+		if err != nil {
+			return err
+		}
+		actions = append(actions, clusterActions...)
+	}
+
+	if opts.output != OutputTable {
+		if err := opts.print(actions); err != nil {
+			return err
+		}
+	} else {
+		w := tabwriter.NewWriter(opts.out, 0, 0, 3, ' ', 0)
+		fmt.Fprintln(w, "ACTION\tKIND\tOBJECT")
+		for _, action := range actions {
+			fmt.Fprintf(w, "%s\t%s\t%s\n", action.Action, action.Kind, action.Object)
+		}
+		if err := w.Flush(); err != nil {
+			return err
+		}
+		if restoreOpts.dryRun {
+			fmt.Fprintln(opts.out, "dry run, nothing is changed")
+		}
+	}
+
+	if missing > 0 {
+		return fmt.Errorf("%d restored services have no ready pod endpoints after %s, their proxy objects are kept, run restore again", missing, restoreOpts.timeout)
+	}
+	return nil
+}
+
+// checkControllerStopped refuses to restore while the controller is running, it would proxy the services again.
+func checkControllerStopped(ctx context.Context, c client.Reader, force bool) error {
+	var deployments = new(appsv1.DeploymentList)
+	if err := c.List(ctx, deployments, controllerSelector); err != nil {
+		return fmt.Errorf("unable to list controller deployments, %w", err)
+	}
+	for _, deployment := range deployments.Items {
+		if deployment.Status.ReadyReplicas == 0 {
+			continue
+		}
+		key := types.NamespacedName{Namespace: deployment.Namespace, Name: deployment.Name}
+		if !force {
+			return fmt.Errorf("controller deployment %s is running, uninstall it first or use --force", key)
+		}
+		fmt.Fprintf(os.Stderr, "warning: controller deployment %s is running, it will proxy restored services again\n", key)
+	}
+	return nil
+}
@@ -0,0 +1,182 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/CharlieR-o-o-t/eks-webhook-proxy/api/v1alpha1"
+	"github.com/CharlieR-o-o-t/eks-webhook-proxy/pkg/webhookref"
+	"github.com/spf13/pflag"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// Phases of webhook services which are not proxied, besides v1alpha1.CutoverPhase values.
+const (
+	phaseServiceNotFound = "ServiceNotFound"
+	phaseNotProxied      = "NotProxied"
+)
+
+func init() {
+	commands["status"] = command{
+		short: "Print proxy state of every webhook service",
+		flags: func(fs *pflag.FlagSet, opts *options) {
+			opts.addOutputFlag(fs)
+			opts.addNamespaceFlag(fs)
+		},
+		run: runStatus,
+	}
+}
+
+// ServiceStatus is the proxy state of a webhook service.
+type ServiceStatus struct {
+	Webhooks        []string `json:"webhooks"`
+	Namespace       string   `json:"namespace"`
+	Service         string   `json:"service"`
+	ProxyService    string   `json:"proxyService,omitempty"`
+	NodePorts       []string `json:"nodePorts,omitempty"`
+	ReadyEndpoints  int32    `json:"readyEndpoints"`
+	TotalEndpoints  int32    `json:"totalEndpoints"`
+	Restricted      bool     `json:"restricted"`
+	NetworkPolicy   bool     `json:"networkPolicy"`
+	SelectorStashed bool     `json:"selectorStashed"`
+	Phase           string   `json:"phase"`
+}
+
+func runStatus(ctx context.Context, opts *options, _ []string) error {
+	c, err := opts.client()
+	if err != nil {
+		return err
+	}
+	p, cfg, err := opts.proxy(ctx, c)
+	if err != nil {
+		return err
+	}
+
+	services, err := webhookref.ListSelected(ctx, c, cfg.Proxy.SelectionMode)
+	if err != nil {
+		return err
+	}
+
+	var statuses []ServiceStatus
+	for _, serviceKey := range sortedServices(services, opts.namespace) {
+		status := ServiceStatus{
+			Webhooks:  webhookNames(services[serviceKey]),
+			Namespace: serviceKey.Namespace,
+			Service:   serviceKey.Name,
+		}
+
+		var serviceOrigin = new(v1.Service)
+		if err := c.Get(ctx, serviceKey, serviceOrigin); err != nil {
+			if !apierrors.IsNotFound(err) {
+				return fmt.Errorf("unable to get service %s, %w", serviceKey, err)
+			}
+			status.Phase = phaseServiceNotFound
+			statuses = append(statuses, status)
+			continue
+		}
+		if serviceOrigin.Spec.Type != v1.ServiceTypeClusterIP || p.IsExcluded(serviceKey.Namespace) {
+			status.Phase = phaseNotProxied
+			statuses = append(statuses, status)
+			continue
+		}
+
+		proxyStatus, err := p.BuildStatus(ctx, serviceOrigin, services[serviceKey])
+		if err != nil {
+			return fmt.Errorf("unable to build status of service %s, %w", serviceKey, err)
+		}
+		status.ProxyService = proxyStatus.ProxyService
+		for _, nodePort := range proxyStatus.NodePorts {
+			status.NodePorts = append(status.NodePorts, fmt.Sprintf("%d:%d/%s", nodePort.Port, nodePort.NodePort, nodePort.Protocol))
+		}
+		status.ReadyEndpoints = proxyStatus.ReadyEndpoints
+		status.TotalEndpoints = proxyStatus.TotalEndpoints
+		status.Restricted = proxyStatus.Restricted
+		status.NetworkPolicy = proxyStatus.NetworkPolicy != nil
+		status.SelectorStashed = proxyStatus.Phase == v1alpha1.PhaseCutOver ||
+			meta.IsStatusConditionTrue(proxyStatus.Conditions, v1alpha1.ConditionSelectorStashed)
+		status.Phase = string(proxyStatus.Phase)
+
+		statuses = append(statuses, status)
+	}
+
+	if opts.output != OutputTable {
+		return opts.print(statuses)
+	}
+
+	w := tabwriter.NewWriter(opts.out, 0, 0, 3, ' ', 0)
+	fmt.Fprintln(w, "WEBHOOKS\tSERVICE\tPROXY SERVICE\tNODEPORTS\tENDPOINTS\tRESTRICTED\tPOLICY\tSELECTOR STASHED\tPHASE")
+	for _, status := range statuses {
+		fmt.Fprintf(w, "%s\t%s/%s\t%s\t%s\t%d/%d\t%t\t%s\t%t\t%s\n",
+			strings.Join(status.Webhooks, ","),
+			status.Namespace, status.Service,
+			valueOrNone(status.ProxyService),
+			valueOrNone(strings.Join(status.NodePorts, ",")),
+			status.ReadyEndpoints, status.TotalEndpoints,
+			status.Restricted,
+			policyState(status),
+			status.SelectorStashed,
+			status.Phase,
+		)
+	}
+	return w.Flush()
+}
+
+// sortedServices returns webhook services, limited to namespace when it is set.
+func sortedServices(services webhookref.ServiceMap, namespace string) []types.NamespacedName {
+	keys := make([]types.NamespacedName, 0, len(services))
+	for key := range services {
+		if namespace != "" && key.Namespace != namespace {
+			continue
+		}
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].String() < keys[j].String()
+	})
+	return keys
+}
+
+// webhookNames returns references as <mutating|validating|crd>/<name>.
+func webhookNames(refs []v1alpha1.WebhookReference) []string {
+	names := make([]string, 0, len(refs))
+	for _, ref := range refs {
+		names = append(names, shortKind(ref.Kind)+"/"+ref.Name)
+	}
+	return names
+}
+
+func shortKind(kind string) string {
+	switch kind {
+	case webhookref.KindMutating:
+		return "mutating"
+	case webhookref.KindValidating:
+		return "validating"
+	case webhookref.KindCRD:
+		return "crd"
+	}
+	return strings.ToLower(kind)
+}
+
+func policyState(status ServiceStatus) string {
+	switch {
+	case !status.Restricted:
+		return "-"
+	case status.NetworkPolicy:
+		return "present"
+	default:
+		return "missing"
+	}
+}
+
+func valueOrNone(value string) string {
+	if value == "" {
+		return "<none>"
+	}
+	return value
+}
@@ -0,0 +1,147 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/CharlieR-o-o-t/eks-webhook-proxy/pkg/proxy"
+	"github.com/CharlieR-o-o-t/eks-webhook-proxy/pkg/webhookref"
+	"github.com/spf13/pflag"
+	admissionv1 "k8s.io/api/admissionregistration/v1"
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+func init() {
+	commands["trace"] = command{
+		short: "Explain reachability path of a webhook: trace <mutating|validating|crd>/<name>",
+		flags: func(fs *pflag.FlagSet, opts *options) {
+			opts.addOutputFlag(fs)
+		},
+		run: runTrace,
+	}
+}
+
+// webhookService is a service called by a webhook, nil service means webhook is called by URL.
+type webhookService struct {
+	webhook string
+	service *admissionv1.ServiceReference
+}
+
+func runTrace(ctx context.Context, opts *options, args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("expected one argument <mutating|validating|crd>/<name>")
+	}
+	kind, name, ok := strings.Cut(args[0], "/")
+	if !ok || name == "" {
+		return fmt.Errorf("invalid webhook %q, expected <mutating|validating|crd>/<name>", args[0])
+	}
+
+	c, err := opts.client()
+	if err != nil {
+		return err
+	}
+
+	webhookServices, err := getWebhookServices(ctx, c, kind, name)
+	if err != nil {
+		return err
+	}
+
+	p, _, err := opts.proxy(ctx, c)
+	if err != nil {
+		return err
+	}
+
+	var steps []proxy.TraceStep
+	for _, webhookService := range webhookServices {
+		if webhookService.service == nil {
+			steps = append(steps, proxy.TraceStep{
+				Hop:     proxy.TraceHopWebhook,
+				Object:  webhookService.webhook,
+				Result:  proxy.TraceResultSkipped,
+				Reason:  "URLClientConfig",
+				Message: "webhook is called by URL, it is not proxied",
+			})
+			continue
+		}
+
+		serviceKey := types.NamespacedName{Namespace: webhookService.service.Namespace, Name: webhookService.service.Name}
+		port := ptr.Deref(webhookService.service.Port, webhookref.DefaultServicePort)
+		steps = append(steps, proxy.TraceStep{
+			Hop:     proxy.TraceHopWebhook,
+			Object:  webhookService.webhook,
+			Result:  proxy.TraceResultOK,
+			Message: fmt.Sprintf("calls service %s port %d path %s", serviceKey, port, ptr.Deref(webhookService.service.Path, "/")),
+		})
+
+		serviceSteps, err := p.TraceService(ctx, serviceKey, port)
+		if err != nil {
+			return fmt.Errorf("unable to trace service %s, %w", serviceKey, err)
+		}
+		steps = append(steps, serviceSteps...)
+	}
+
+	if opts.output != OutputTable {
+		return opts.print(steps)
+	}
+
+	w := tabwriter.NewWriter(opts.out, 0, 0, 3, ' ', 0)
+	fmt.Fprintln(w, "HOP\tOBJECT\tRESULT\tREASON\tMESSAGE")
+	for _, step := range steps {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", step.Hop, step.Object, step.Result, valueOrNone(step.Reason), step.Message)
+	}
+	return w.Flush()
+}
+
+// getWebhookServices returns services called by webhooks of the object.
+func getWebhookServices(ctx context.Context, c client.Reader, kind, name string) ([]webhookService, error) {
+	var webhookServices []webhookService
+	switch kind {
+	case shortKind(webhookref.KindMutating):
+		var obj = new(admissionv1.MutatingWebhookConfiguration)
+		if err := c.Get(ctx, types.NamespacedName{Name: name}, obj); err != nil {
+			return nil, fmt.Errorf("unable to get mutating webhook %s, %w", name, err)
+		}
+		for _, webhook := range obj.Webhooks {
+			webhookServices = append(webhookServices, webhookService{
+				webhook: kind + "/" + name + "/" + webhook.Name,
+				service: webhook.ClientConfig.Service,
+			})
+		}
+	case shortKind(webhookref.KindValidating):
+		var obj = new(admissionv1.ValidatingWebhookConfiguration)
+		if err := c.Get(ctx, types.NamespacedName{Name: name}, obj); err != nil {
+			return nil, fmt.Errorf("unable to get validating webhook %s, %w", name, err)
+		}
+		for _, webhook := range obj.Webhooks {
+			webhookServices = append(webhookServices, webhookService{
+				webhook: kind + "/" + name + "/" + webhook.Name,
+				service: webhook.ClientConfig.Service,
+			})
+		}
+	case shortKind(webhookref.KindCRD):
+		var obj = new(apiextensionsv1.CustomResourceDefinition)
+		if err := c.Get(ctx, types.NamespacedName{Name: name}, obj); err != nil {
+			return nil, fmt.Errorf("unable to get custom resource definition %s, %w", name, err)
+		}
+		if obj.Spec.Conversion == nil || obj.Spec.Conversion.Webhook == nil || obj.Spec.Conversion.Webhook.ClientConfig == nil {
+			return nil, fmt.Errorf("custom resource definition %s has no conversion webhook", name)
+		}
+		webhookServices = append(webhookServices, webhookService{webhook: kind + "/" + name})
+		if conversionService := webhookref.ConversionService(obj); conversionService != nil {
+			webhookServices[0].service = &admissionv1.ServiceReference{
+				Namespace: conversionService.Namespace,
+				Name:      conversionService.Name,
+				Path:      conversionService.Path,
+				Port:      conversionService.Port,
+			}
+		}
+	default:
+		return nil, fmt.Errorf("unknown webhook kind %q, expected mutating, validating or crd", kind)
+	}
+	return webhookServices, nil
+}
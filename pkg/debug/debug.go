@@ -0,0 +1,101 @@
+// Package debug serves live controller state as JSON,
+// answering "what does the controller think" without raising log verbosity.
+package debug
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/CharlieR-o-o-t/eks-webhook-proxy/api/v1alpha1"
+	"github.com/CharlieR-o-o-t/eks-webhook-proxy/pkg/nodecache"
+	"github.com/CharlieR-o-o-t/eks-webhook-proxy/pkg/proxy"
+	"github.com/CharlieR-o-o-t/eks-webhook-proxy/pkg/webhookref"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+// Path is the debug endpoint path on the metrics server.
+const Path = "/debug/webhook-proxy"
+
+// Reconcile describes the last reconcile of a controller.
+type Reconcile struct {
+	Request string    `json:"request"`
+	Time    time.Time `json:"time"`
+	Error   string    `json:"error,omitempty"`
+}
+
+// State is the debug endpoint response.
+type State struct {
+	// Nodes maps node name to cached InternalIP.
+	Nodes map[string]string `json:"nodes"`
+	// Services maps webhook service to objects referencing it.
+	Services map[string][]v1alpha1.WebhookReference `json:"services"`
+	// Endpoints are the last computed proxy endpoints by webhook service.
+	Endpoints map[string]proxy.PublishedEndpoints `json:"endpoints"`
+	// Reconciles are the last reconciles by controller name.
+	Reconciles map[string]Reconcile `json:"reconciles"`
+}
+
+var reconciles = struct {
+	sync.RWMutex
+	data map[string]Reconcile
+}{data: make(map[string]Reconcile)}
+
+// Reconciler wraps reconciler, recording time and result of the last reconcile.
+func Reconciler(controllerName string, r reconcile.Reconciler) reconcile.Reconciler {
+	return reconcile.Func(func(ctx context.Context, req reconcile.Request) (reconcile.Result, error) {
+		result, err := r.Reconcile(ctx, req)
+
+		last := Reconcile{Request: req.String(), Time: time.Now()}
+		if err != nil {
+			last.Error = err.Error()
+		}
+		reconciles.Lock()
+		reconciles.data[controllerName] = last
+		reconciles.Unlock()
+
+		return result, err
+	})
+}
+
+// Handler serves State as JSON.
+type Handler struct {
+	Reader    client.Reader
+	NodeCache *nodecache.NodeIPCache
+	Proxy     *proxy.Proxy
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	services, err := webhookref.List(r.Context(), h.Reader)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	state := State{
+		Nodes:      h.NodeCache.Snapshot(),
+		Services:   make(map[string][]v1alpha1.WebhookReference, len(services)),
+		Endpoints:  make(map[string]proxy.PublishedEndpoints),
+		Reconciles: make(map[string]Reconcile),
+	}
+	for service, webhooks := range services {
+		state.Services[service.String()] = webhooks
+	}
+	for service, published := range h.Proxy.PublishedEndpoints() {
+		state.Endpoints[service.String()] = published
+	}
+
+	reconciles.RLock()
+	for controllerName, last := range reconciles.data {
+		state.Reconciles[controllerName] = last
+	}
+	reconciles.RUnlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	_ = encoder.Encode(state)
+}
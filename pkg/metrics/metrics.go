@@ -0,0 +1,223 @@
+package metrics
+
+import (
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"k8s.io/apimachinery/pkg/types"
+	ctrlmetrics "sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+const (
+	namespace = "eks_webhook_proxy"
+
+	ModeRestricted   = "restricted"
+	ModeUnrestricted = "unrestricted"
+
+	SkipReasonNoNodeName   = "no_node_name"
+	SkipReasonNoNodeIP     = "no_node_ip"
+	SkipReasonNodeSelector = "node_selector"
+
+	SelectorStrip   = "strip"
+	SelectorRestore = "restore"
+
+	CanaryResultReachable    = "reachable"
+	CanaryResultUnreachable  = "unreachable"
+	CanaryResultInconclusive = "inconclusive"
+)
+
+var (
+	ProxiedServices = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "proxied_services",
+		Help:      "Number of proxied webhook services by mode.",
+	}, []string{"mode"})
+
+	NodeEndpoints = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "node_endpoints",
+		Help:      "Number of node endpoints published for webhook service.",
+	}, []string{"namespace", "service"})
+
+	ReadyNodeEndpoints = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "ready_node_endpoints",
+		Help:      "Number of ready node endpoints published for webhook service.",
+	}, []string{"namespace", "service"})
+
+	SkippedEndpoints = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "skipped_endpoints",
+		Help:      "Number of webhook pod endpoints not published in the last proxy endpoint slice update, by reason.",
+	}, []string{"namespace", "service", "reason"})
+
+	NodeIPCacheSize = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "node_ip_cache_size",
+		Help:      "Number of nodes with known InternalIP.",
+	})
+
+	SelectorOperations = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "selector_operations_total",
+		Help:      "Number of webhook service selector strips and restores.",
+	}, []string{"operation"})
+
+	EndpointPropagation = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "endpoint_propagation_seconds",
+		Help:      "Time from webhook pod endpoint change to proxy endpoint slice update.",
+		Buckets:   prometheus.ExponentialBuckets(0.05, 2, 12),
+	})
+
+	CutoverPhase = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "cutover_phase",
+		Help:      "Cutover phase of webhook service, 1 for the current phase.",
+	}, []string{"namespace", "service", "phase"})
+
+	EndpointProbeSuccess = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "endpoint_probe_success",
+		Help:      "Result of the last TCP dial of published node endpoint, 1 for success.",
+	}, []string{"namespace", "service", "address", "port"})
+
+	EndpointProbeHealthy = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "endpoint_probe_healthy",
+		Help:      "Probe state of published node endpoint after hysteresis, 0 means endpoint is marked not ready.",
+	}, []string{"namespace", "service", "address", "port"})
+
+	CertificateValid = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "certificate_valid",
+		Help:      "Result of the last serving certificate check of webhook through the proxy path, 1 if it is accepted.",
+	}, []string{"namespace", "service", "webhook"})
+
+	CertificateExpiry = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "certificate_expiry_timestamp_seconds",
+		Help:      "Expiry of webhook serving certificate as unix timestamp.",
+	}, []string{"namespace", "service", "webhook"})
+
+	CanaryReachable = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "canary_reachable",
+		Help:      "Result of the last dry-run canary request, 1 if webhook is reachable from control plane.",
+	}, []string{"kind", "name"})
+
+	CanaryRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "canary_requests_total",
+		Help:      "Number of dry-run canary requests by result.",
+	}, []string{"kind", "name", "result"})
+
+	CanaryDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "canary_duration_seconds",
+		Help:      "Duration of dry-run canary requests, including webhook calls.",
+		Buckets:   prometheus.ExponentialBuckets(0.01, 2, 12),
+	}, []string{"kind", "name"})
+
+	ObservedWrites = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "observed_writes_total",
+		Help:      "Number of API writes skipped in observe-only mode, by operation and kind.",
+	}, []string{"operation", "kind"})
+
+	FieldConflicts = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "field_conflicts_total",
+		Help:      "Number of server-side apply conflicts with other field managers, by kind.",
+	}, []string{"kind"})
+
+	ProxyFailed = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "proxy_failed",
+		Help:      "Result of the last proxy ensure of webhook service, 1 if it failed.",
+	}, []string{"namespace", "service"})
+
+	EndpointProbeDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "endpoint_probe_duration_seconds",
+		Help:      "Duration of TCP dials of published node endpoints.",
+		Buckets:   prometheus.ExponentialBuckets(0.001, 2, 12),
+	})
+)
+
+func init() {
+	ctrlmetrics.Registry.MustRegister(
+		ProxiedServices,
+		NodeEndpoints,
+		ReadyNodeEndpoints,
+		SkippedEndpoints,
+		NodeIPCacheSize,
+		SelectorOperations,
+		EndpointPropagation,
+		CutoverPhase,
+		EndpointProbeSuccess,
+		EndpointProbeHealthy,
+		EndpointProbeDuration,
+		CertificateValid,
+		CertificateExpiry,
+		CanaryReachable,
+		CanaryRequests,
+		CanaryDuration,
+		ObservedWrites,
+		FieldConflicts,
+		ProxyFailed,
+	)
+}
+
+var (
+	modesMu sync.Mutex
+	modes   = make(map[types.NamespacedName]string)
+)
+
+// SetServiceMode records proxy mode of webhook service and updates ProxiedServices.
+func SetServiceMode(service types.NamespacedName, mode string) {
+	modesMu.Lock()
+	defer modesMu.Unlock()
+	modes[service] = mode
+	updateProxiedServices()
+}
+
+// DeleteService removes all per-service metrics of webhook service.
+func DeleteService(service types.NamespacedName) {
+	modesMu.Lock()
+	delete(modes, service)
+	updateProxiedServices()
+	modesMu.Unlock()
+
+	labels := prometheus.Labels{"namespace": service.Namespace, "service": service.Name}
+	NodeEndpoints.DeletePartialMatch(labels)
+	ReadyNodeEndpoints.DeletePartialMatch(labels)
+	SkippedEndpoints.DeletePartialMatch(labels)
+	CutoverPhase.DeletePartialMatch(labels)
+	EndpointProbeSuccess.DeletePartialMatch(labels)
+	EndpointProbeHealthy.DeletePartialMatch(labels)
+	CertificateValid.DeletePartialMatch(labels)
+	CertificateExpiry.DeletePartialMatch(labels)
+	ProxyFailed.DeletePartialMatch(labels)
+}
+
+// SetCutoverPhase sets 1 for the current phase and 0 for the others.
+func SetCutoverPhase(service types.NamespacedName, phase string, phases []string) {
+	for _, p := range phases {
+		value := 0.0
+		if p == phase {
+			value = 1
+		}
+		CutoverPhase.WithLabelValues(service.Namespace, service.Name, p).Set(value)
+	}
+}
+
+func updateProxiedServices() {
+	counts := map[string]float64{ModeRestricted: 0, ModeUnrestricted: 0}
+	for _, mode := range modes {
+		counts[mode]++
+	}
+	for mode, count := range counts {
+		ProxiedServices.WithLabelValues(mode).Set(count)
+	}
+}
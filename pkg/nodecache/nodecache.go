@@ -2,22 +2,34 @@ package nodecache
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"github.com/CharlieR-o-o-t/eks-webhook-proxy/pkg/metrics"
+	"maps"
 	"net"
+	"net/http"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sort"
 	"sync"
+	"sync/atomic"
 
 	"k8s.io/client-go/util/workqueue"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/event"
 	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
 	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
 )
 
+var ErrNotSynced = errors.New("node IP cache is not synced")
+
 type NodeIPCache struct {
-	mu   sync.RWMutex
-	data map[string]string // nodeName -> InternalIP
+	mu     sync.RWMutex
+	data   map[string]string // nodeName -> InternalIP
+	synced atomic.Bool
 }
 
 func NewNodeIPCache() *NodeIPCache {
@@ -26,16 +38,24 @@ func NewNodeIPCache() *NodeIPCache {
 	}
 }
 
-func (c *NodeIPCache) Set(nodeName, ip string) {
+// Set caches node InternalIP, it tells if the address is new or changed.
+func (c *NodeIPCache) Set(nodeName, ip string) bool {
 	c.mu.Lock()
 	defer c.mu.Unlock()
+	previous, ok := c.data[nodeName]
 	c.data[nodeName] = ip
+	metrics.NodeIPCacheSize.Set(float64(len(c.data)))
+	return !ok || previous != ip
 }
 
-func (c *NodeIPCache) Delete(nodeName string) {
+// Delete removes node from cache, it tells if the node was cached.
+func (c *NodeIPCache) Delete(nodeName string) bool {
 	c.mu.Lock()
 	defer c.mu.Unlock()
+	_, ok := c.data[nodeName]
 	delete(c.data, nodeName)
+	metrics.NodeIPCacheSize.Set(float64(len(c.data)))
+	return ok
 }
 
 func (c *NodeIPCache) Get(nodeName string) (string, bool) {
@@ -45,6 +65,63 @@ func (c *NodeIPCache) Get(nodeName string) (string, bool) {
 	return ip, ok
 }
 
+// IPs returns sorted IP addresses of all cached nodes.
+func (c *NodeIPCache) IPs() []string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	ips := make([]string, 0, len(c.data))
+	for _, ip := range c.data {
+		ips = append(ips, ip)
+	}
+	sort.Strings(ips)
+	return ips
+}
+
+// Snapshot returns copy of cached node name to InternalIP mapping.
+func (c *NodeIPCache) Snapshot() map[string]string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return maps.Clone(c.data)
+}
+
+// SetNodes caches InternalIPs of nodes.
+func (c *NodeIPCache) SetNodes(nodes []corev1.Node) {
+	for i := range nodes {
+		if ip := getInternalIP(&nodes[i]); ip != "" {
+			c.Set(nodes[i].Name, ip)
+		}
+	}
+}
+
+// Synced tells if cache has been filled with all nodes known at startup.
+func (c *NodeIPCache) Synced() bool {
+	return c.synced.Load()
+}
+
+// Checker is a readyz check passing once cache is synced.
+func (c *NodeIPCache) Checker(_ *http.Request) error {
+	if !c.Synced() {
+		return ErrNotSynced
+	}
+	return nil
+}
+
+// sync fills cache with all nodes from informer cache and marks it synced.
+func (c *NodeIPCache) sync(ctx context.Context, mgr ctrl.Manager) error {
+	// Cache sync is interrupted only by shutdown.
+	if !mgr.GetCache().WaitForCacheSync(ctx) {
+		return nil
+	}
+
+	var nodes = new(corev1.NodeList)
+	if err := mgr.GetClient().List(ctx, nodes); err != nil {
+		return fmt.Errorf("unable to list nodes, %w", err)
+	}
+	c.SetNodes(nodes.Items)
+	c.synced.Store(true)
+	return nil
+}
+
 // getInternalIP returns IPv4 node internalIP.
 func getInternalIP(node *corev1.Node) string {
 	for _, addr := range node.Status.Addresses {
@@ -64,10 +141,22 @@ func getInternalIP(node *corev1.Node) string {
 	return ""
 }
 
+// changedRequest is queued when node addresses are changed, node requests are not namespaced.
+var changedRequest = reconcile.Request{NamespacedName: types.NamespacedName{Namespace: "node-ip-cache", Name: "changed"}}
+
+// SetupNodeWatch keeps cache in sync with nodes. onChange, when set, is called after node addresses
+// are changed once the cache is synced, changes in a burst are folded into a single call.
 func SetupNodeWatch(
 	mgr ctrl.Manager,
 	cache *NodeIPCache,
+	onChange func(ctx context.Context) error,
 ) error {
+	if err := mgr.Add(manager.RunnableFunc(func(ctx context.Context) error {
+		return cache.sync(ctx, mgr)
+	})); err != nil {
+		return err
+	}
+
 	return ctrl.NewControllerManagedBy(mgr).
 		Named("node-ip-cache").
 		For(&corev1.Node{}).
@@ -79,8 +168,8 @@ func SetupNodeWatch(
 					if !ok || node == nil {
 						return
 					}
-					if ip := getInternalIP(node); ip != "" {
-						cache.Set(node.Name, ip)
+					if ip := getInternalIP(node); ip != "" && cache.Set(node.Name, ip) {
+						q.Add(changedRequest)
 					}
 				},
 				UpdateFunc: func(ctx context.Context, e event.TypedUpdateEvent[client.Object], q workqueue.TypedRateLimitingInterface[reconcile.Request]) {
@@ -88,8 +177,8 @@ func SetupNodeWatch(
 					if !ok || node == nil {
 						return
 					}
-					if ip := getInternalIP(node); ip != "" {
-						cache.Set(node.Name, ip)
+					if ip := getInternalIP(node); ip != "" && cache.Set(node.Name, ip) {
+						q.Add(changedRequest)
 					}
 				},
 				DeleteFunc: func(ctx context.Context, e event.TypedDeleteEvent[client.Object], q workqueue.TypedRateLimitingInterface[reconcile.Request]) {
@@ -97,13 +186,17 @@ func SetupNodeWatch(
 					if !ok || node == nil {
 						return
 					}
-					if node != nil {
-						cache.Delete(node.Name)
+					if cache.Delete(node.Name) {
+						q.Add(changedRequest)
 					}
 				},
 			},
 		).
 		Complete(reconcile.Func(func(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
-			return ctrl.Result{}, nil
+			// Addresses of nodes known at startup are handled by the initial reconcile.
+			if req != changedRequest || onChange == nil || !cache.Synced() {
+				return ctrl.Result{}, nil
+			}
+			return ctrl.Result{}, onChange(ctx)
 		}))
 }
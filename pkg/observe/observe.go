@@ -0,0 +1,120 @@
+// Package observe implements observe-only mode: controllers compute the desired state,
+// but writes to the API are logged and counted instead of being sent.
+package observe
+
+import (
+	"context"
+
+	"github.com/CharlieR-o-o-t/eks-webhook-proxy/pkg/metrics"
+	"github.com/go-logr/logr"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/apiutil"
+)
+
+// Skipped write operations.
+const (
+	OperationCreate      = "create"
+	OperationUpdate      = "update"
+	OperationPatch       = "patch"
+	OperationApply       = "apply"
+	OperationDelete      = "delete"
+	OperationDeleteAllOf = "delete_all_of"
+)
+
+// Client skips writes of the wrapped client, reads are passed through.
+// Dry-run creates are passed as well, they are never persisted. Server-side apply
+// patches are sent with dryRun=All, so the object gets defaults and allocated node ports.
+type Client struct {
+	client.Client
+	log logr.Logger
+}
+
+// NewClient wraps c, so nothing is written to the API.
+func NewClient(c client.Client, log logr.Logger) *Client {
+	return &Client{Client: c, log: log}
+}
+
+func (c *Client) Create(ctx context.Context, obj client.Object, opts ...client.CreateOption) error {
+	createOpts := new(client.CreateOptions).ApplyOptions(opts)
+	if len(createOpts.DryRun) > 0 {
+		return c.Client.Create(ctx, obj, opts...)
+	}
+	c.skip(OperationCreate, "", obj)
+	return nil
+}
+
+func (c *Client) Update(_ context.Context, obj client.Object, _ ...client.UpdateOption) error {
+	c.skip(OperationUpdate, "", obj)
+	return nil
+}
+
+func (c *Client) Patch(ctx context.Context, obj client.Object, patch client.Patch, opts ...client.PatchOption) error {
+	c.skip(OperationPatch, "", obj)
+	if patch.Type() != types.ApplyPatchType {
+		return nil
+	}
+	return c.Client.Patch(ctx, obj, patch, append(opts, client.DryRunAll)...)
+}
+
+func (c *Client) Apply(_ context.Context, _ runtime.ApplyConfiguration, _ ...client.ApplyOption) error {
+	c.log.Info("observe-only mode, skipping write", "operation", OperationApply)
+	metrics.ObservedWrites.WithLabelValues(OperationApply, "").Inc()
+	return nil
+}
+
+func (c *Client) Delete(_ context.Context, obj client.Object, _ ...client.DeleteOption) error {
+	c.skip(OperationDelete, "", obj)
+	return nil
+}
+
+func (c *Client) DeleteAllOf(_ context.Context, obj client.Object, _ ...client.DeleteAllOfOption) error {
+	c.skip(OperationDeleteAllOf, "", obj)
+	return nil
+}
+
+// Status skips status writes.
+func (c *Client) Status() client.SubResourceWriter {
+	return &subResourceClient{SubResourceClient: c.Client.SubResource("status"), parent: c, subResource: "status"}
+}
+
+// SubResource skips subresource writes, subresource reads are passed through.
+func (c *Client) SubResource(subResource string) client.SubResourceClient {
+	return &subResourceClient{SubResourceClient: c.Client.SubResource(subResource), parent: c, subResource: subResource}
+}
+
+func (c *Client) skip(operation, subResource string, obj client.Object) {
+	var kind string
+	if gvk, err := apiutil.GVKForObject(obj, c.Scheme()); err == nil {
+		kind = gvk.Kind
+	}
+
+	log := c.log.WithValues("operation", operation, "kind", kind, "object", client.ObjectKeyFromObject(obj))
+	if subResource != "" {
+		log = log.WithValues("subresource", subResource)
+	}
+	log.Info("observe-only mode, skipping write")
+	metrics.ObservedWrites.WithLabelValues(operation, kind).Inc()
+}
+
+type subResourceClient struct {
+	client.SubResourceClient
+	parent      *Client
+	subResource string
+}
+
+func (c *subResourceClient) Create(_ context.Context, obj client.Object, _ client.Object, _ ...client.SubResourceCreateOption) error {
+	c.parent.skip(OperationCreate, c.subResource, obj)
+	return nil
+}
+
+func (c *subResourceClient) Update(_ context.Context, obj client.Object, _ ...client.SubResourceUpdateOption) error {
+	c.parent.skip(OperationUpdate, c.subResource, obj)
+	return nil
+}
+
+func (c *subResourceClient) Patch(_ context.Context, obj client.Object, _ client.Patch, _ ...client.SubResourcePatchOption) error {
+	c.parent.skip(OperationPatch, c.subResource, obj)
+	return nil
+}
@@ -0,0 +1,211 @@
+// Package prober periodically dials published nodeIP:nodePort endpoints,
+// so endpoints dropped by a missing kube-proxy or a host firewall are not published as ready.
+package prober
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/CharlieR-o-o-t/eks-webhook-proxy/config"
+	"github.com/CharlieR-o-o-t/eks-webhook-proxy/pkg/metrics"
+	"github.com/CharlieR-o-o-t/eks-webhook-proxy/pkg/proxy"
+	"github.com/go-logr/logr"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// maxConcurrentDials limits dials in flight during a probe round.
+const maxConcurrentDials = 16
+
+type target struct {
+	service types.NamespacedName
+	address string
+	port    int32
+}
+
+func (t target) hostPort() string {
+	return net.JoinHostPort(t.address, strconv.Itoa(int(t.port)))
+}
+
+// state keeps hysteresis of a single endpoint.
+type state struct {
+	healthy   bool
+	failures  int
+	successes int
+}
+
+// Prober dials published endpoints of Proxy and marks unreachable ones not ready.
+// Endpoint state is changed only after FailureThreshold consecutive failures
+// or SuccessThreshold consecutive successes.
+type Prober struct {
+	config config.Probe
+	proxy  *proxy.Proxy
+	log    logr.Logger
+
+	mu     sync.RWMutex
+	states map[target]*state
+}
+
+func New(cfg config.Probe, proxy *proxy.Proxy, log logr.Logger) *Prober {
+	return &Prober{
+		config: cfg,
+		proxy:  proxy,
+		log:    log,
+		states: make(map[target]*state),
+	}
+}
+
+// Healthy implements proxy.EndpointHealth, endpoints not probed yet are healthy.
+func (p *Prober) Healthy(address string, port int32) bool {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	for t, s := range p.states {
+		if t.address == address && t.port == port && !s.healthy {
+			return false
+		}
+	}
+	return true
+}
+
+// Start implements manager.Runnable.
+func (p *Prober) Start(ctx context.Context) error {
+	ticker := time.NewTicker(p.config.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			p.ProbeOnce(ctx)
+		}
+	}
+}
+
+// NeedLeaderElection implements manager.LeaderElectionRunnable.
+func (p *Prober) NeedLeaderElection() bool {
+	return true
+}
+
+// ProbeOnce dials all published endpoints once and refreshes proxy endpoint slices
+// of services whose endpoints changed state.
+func (p *Prober) ProbeOnce(ctx context.Context) {
+	targets := p.targets()
+
+	results := make(map[target]bool, len(targets))
+	var resultsMu sync.Mutex
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, maxConcurrentDials)
+	for _, t := range targets {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			ok := p.dial(ctx, t)
+			resultsMu.Lock()
+			results[t] = ok
+			resultsMu.Unlock()
+		}()
+	}
+	wg.Wait()
+
+	changed := p.update(results)
+	for service := range changed {
+		if err := p.proxy.RefreshProxyEndpointSlices(ctx, service); err != nil {
+			p.log.Error(err, "unable to refresh proxy endpoint slice", "service", service)
+		}
+	}
+}
+
+// targets returns TCP endpoints of all published proxy endpoint slices,
+// services which the controller can not dial are not probed, see proxy.PublishedEndpoints.
+func (p *Prober) targets() []target {
+	var targets []target
+	for service, published := range p.proxy.PublishedEndpoints() {
+		if published.SkipProbe {
+			continue
+		}
+		for _, endpoint := range published.Endpoints {
+			for _, port := range published.Ports {
+				targets = append(targets, target{service: service, address: endpoint.Address, port: port})
+			}
+		}
+	}
+	return targets
+}
+
+func (p *Prober) dial(ctx context.Context, t target) bool {
+	dialer := net.Dialer{Timeout: p.config.Timeout}
+
+	start := time.Now()
+	conn, err := dialer.DialContext(ctx, "tcp", t.hostPort())
+	metrics.EndpointProbeDuration.Observe(time.Since(start).Seconds())
+	if err != nil {
+		p.log.V(5).Info("endpoint probe failed", "service", t.service, "endpoint", t.hostPort(), "err", err.Error())
+		return false
+	}
+	_ = conn.Close()
+	return true
+}
+
+// update applies probe results with hysteresis, forgets endpoints which are no longer published
+// and returns services with endpoints which changed state.
+func (p *Prober) update(results map[target]bool) map[types.NamespacedName]struct{} {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	changed := make(map[types.NamespacedName]struct{})
+	for t, ok := range results {
+		s, found := p.states[t]
+		if !found {
+			s = &state{healthy: true}
+			p.states[t] = s
+		}
+
+		if ok {
+			s.successes++
+			s.failures = 0
+			if !s.healthy && s.successes >= p.config.SuccessThreshold {
+				s.healthy = true
+				changed[t.service] = struct{}{}
+				p.log.Info("endpoint is reachable again", "service", t.service, "endpoint", t.hostPort())
+			}
+		} else {
+			s.failures++
+			s.successes = 0
+			if s.healthy && s.failures >= p.config.FailureThreshold {
+				s.healthy = false
+				changed[t.service] = struct{}{}
+				p.log.Info("endpoint is unreachable, marking not ready", "service", t.service, "endpoint", t.hostPort())
+			}
+		}
+
+		labels := []string{t.service.Namespace, t.service.Name, t.address, strconv.Itoa(int(t.port))}
+		metrics.EndpointProbeSuccess.WithLabelValues(labels...).Set(boolToFloat(ok))
+		metrics.EndpointProbeHealthy.WithLabelValues(labels...).Set(boolToFloat(s.healthy))
+	}
+
+	for t := range p.states {
+		if _, ok := results[t]; ok {
+			continue
+		}
+		delete(p.states, t)
+		labels := []string{t.service.Namespace, t.service.Name, t.address, strconv.Itoa(int(t.port))}
+		metrics.EndpointProbeSuccess.DeleteLabelValues(labels...)
+		metrics.EndpointProbeHealthy.DeleteLabelValues(labels...)
+	}
+
+	return changed
+}
+
+func boolToFloat(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
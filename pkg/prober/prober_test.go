@@ -0,0 +1,155 @@
+package prober
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/CharlieR-o-o-t/eks-webhook-proxy/config"
+	"github.com/go-logr/logr"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+var testService = types.NamespacedName{Namespace: "webhooks", Name: "webhook"}
+
+func newTestProber() *Prober {
+	return New(config.Probe{
+		Timeout:          time.Second,
+		FailureThreshold: 3,
+		SuccessThreshold: 2,
+	}, nil, logr.Discard())
+}
+
+// listen returns target served by a local listener, the listener is closed with the test.
+func listen(t *testing.T) target {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	t.Cleanup(func() { _ = listener.Close() })
+
+	return target{service: testService, address: "127.0.0.1", port: int32(listener.Addr().(*net.TCPAddr).Port)}
+}
+
+// closedTarget returns target on a port nobody listens on.
+func closedTarget(t *testing.T) target {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	port := listener.Addr().(*net.TCPAddr).Port
+	_ = listener.Close()
+
+	return target{service: testService, address: "127.0.0.1", port: int32(port)}
+}
+
+func TestDial(t *testing.T) {
+	p := newTestProber()
+	ctx := context.Background()
+
+	if !p.dial(ctx, listen(t)) {
+		t.Error("dial of listening port failed")
+	}
+	if p.dial(ctx, closedTarget(t)) {
+		t.Error("dial of closed port succeeded")
+	}
+}
+
+func TestUpdateFailureThreshold(t *testing.T) {
+	p := newTestProber()
+	down := closedTarget(t)
+
+	for round := 1; round < p.config.FailureThreshold; round++ {
+		if changed := p.update(map[target]bool{down: p.dial(context.Background(), down)}); len(changed) != 0 {
+			t.Fatalf("round %d: service changed before failure threshold", round)
+		}
+		if !p.Healthy(down.address, down.port) {
+			t.Fatalf("round %d: endpoint is not healthy before failure threshold", round)
+		}
+	}
+
+	changed := p.update(map[target]bool{down: p.dial(context.Background(), down)})
+	if _, ok := changed[testService]; !ok {
+		t.Fatal("service is not changed at failure threshold")
+	}
+	if p.Healthy(down.address, down.port) {
+		t.Fatal("endpoint is healthy at failure threshold")
+	}
+}
+
+func TestUpdateSuccessThreshold(t *testing.T) {
+	p := newTestProber()
+	up := listen(t)
+
+	for round := 0; round < p.config.FailureThreshold; round++ {
+		p.update(map[target]bool{up: false})
+	}
+	if p.Healthy(up.address, up.port) {
+		t.Fatal("endpoint is healthy after failure threshold")
+	}
+
+	for round := 1; round < p.config.SuccessThreshold; round++ {
+		if changed := p.update(map[target]bool{up: p.dial(context.Background(), up)}); len(changed) != 0 {
+			t.Fatalf("round %d: service changed before success threshold", round)
+		}
+		if p.Healthy(up.address, up.port) {
+			t.Fatalf("round %d: endpoint is healthy before success threshold", round)
+		}
+	}
+
+	changed := p.update(map[target]bool{up: p.dial(context.Background(), up)})
+	if _, ok := changed[testService]; !ok {
+		t.Fatal("service is not changed at success threshold")
+	}
+	if !p.Healthy(up.address, up.port) {
+		t.Fatal("endpoint is not healthy at success threshold")
+	}
+}
+
+func TestUpdateSuccessResetsFailures(t *testing.T) {
+	p := newTestProber()
+	flaky := listen(t)
+
+	// Failures are counted only when consecutive.
+	for round := 0; round < 2*p.config.FailureThreshold; round++ {
+		p.update(map[target]bool{flaky: round%2 == 0})
+	}
+	if !p.Healthy(flaky.address, flaky.port) {
+		t.Fatal("endpoint with alternating results is marked not healthy")
+	}
+}
+
+func TestUpdateForgetsUnpublishedEndpoints(t *testing.T) {
+	p := newTestProber()
+	down := closedTarget(t)
+	up := listen(t)
+
+	for round := 0; round < p.config.FailureThreshold; round++ {
+		p.update(map[target]bool{down: false, up: true})
+	}
+	if p.Healthy(down.address, down.port) {
+		t.Fatal("endpoint is healthy after failure threshold")
+	}
+
+	// Endpoint is no longer published, its state is dropped.
+	p.update(map[target]bool{up: true})
+	if _, ok := p.states[down]; ok {
+		t.Fatal("state of unpublished endpoint is kept")
+	}
+	if !p.Healthy(down.address, down.port) {
+		t.Fatal("unpublished endpoint is not healthy")
+	}
+	if _, ok := p.states[up]; !ok {
+		t.Fatal("state of published endpoint is dropped")
+	}
+}
+
+func TestHealthyUnknownEndpoint(t *testing.T) {
+	p := newTestProber()
+	if !p.Healthy("127.0.0.1", 30000) {
+		t.Fatal("endpoint not probed yet is not healthy")
+	}
+}
@@ -0,0 +1,156 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/CharlieR-o-o-t/eks-webhook-proxy/pkg/metrics"
+	"github.com/CharlieR-o-o-t/eks-webhook-proxy/pkg/utils"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/apiutil"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+)
+
+// FieldManager owns fields written by the controller, managedFields of objects tell which fields belong to it.
+const FieldManager = utils.ControllerName
+
+// OperationResultPlanned means that the object does not exist and would be created, writes are skipped
+// in observe-only mode. It is returned once per object, OperationResultNone is returned afterwards.
+const OperationResultPlanned controllerutil.OperationResult = "planned"
+
+// ErrFieldConflict means that fields applied by the controller are owned by other field managers.
+var ErrFieldConflict = errors.New("fields are owned by other field managers")
+
+// apply sends fields set in obj with server-side apply, obj is updated with the response.
+// Conflicting fields are reported with their managers. With force they are taken over,
+// which is done for objects created by the controller, otherwise ErrFieldConflict is returned.
+// serviceOrigin, when set, receives the conflict event as well.
+func (p *Proxy) apply(ctx context.Context, obj client.Object, force bool, serviceOrigin *v1.Service) (controllerutil.OperationResult, error) {
+	gvk, err := apiutil.GVKForObject(obj, p.client.Scheme())
+	if err != nil {
+		return controllerutil.OperationResultNone, err
+	}
+	obj.GetObjectKind().SetGroupVersionKind(gvk)
+	obj.SetResourceVersion("")
+	obj.SetManagedFields(nil)
+
+	op := controllerutil.OperationResultUpdated
+	current, ok := obj.DeepCopyObject().(client.Object)
+	if !ok {
+		return controllerutil.OperationResultNone, fmt.Errorf("unable to copy %s %s", gvk.Kind, client.ObjectKeyFromObject(obj))
+	}
+	if err := p.client.Get(ctx, client.ObjectKeyFromObject(obj), current); err != nil {
+		if !apierrors.IsNotFound(err) {
+			return controllerutil.OperationResultNone, err
+		}
+		op = controllerutil.OperationResultCreated
+	}
+
+	err = p.client.Patch(ctx, obj, client.Apply, client.FieldOwner(FieldManager))
+	if apierrors.IsConflict(err) {
+		conflicts := fieldConflicts(err)
+		p.reportConflicts(ctx, obj, gvk.Kind, conflicts, force, serviceOrigin)
+		if !force {
+			return controllerutil.OperationResultNone, fmt.Errorf("%s %s, %w: %s",
+				gvk.Kind, client.ObjectKeyFromObject(obj), ErrFieldConflict, strings.Join(conflicts, "; "))
+		}
+		obj.GetObjectKind().SetGroupVersionKind(gvk)
+		err = p.client.Patch(ctx, obj, client.Apply, client.FieldOwner(FieldManager), client.ForceOwnership)
+	}
+	if err != nil {
+		return controllerutil.OperationResultNone, err
+	}
+
+	key := gvk.Kind + "/" + client.ObjectKeyFromObject(obj).String()
+	switch {
+	case op == controllerutil.OperationResultCreated && p.cfg().Proxy.ObserveOnly:
+		op = controllerutil.OperationResultNone
+		if p.planned.add(key) {
+			op = OperationResultPlanned
+		}
+	case op == controllerutil.OperationResultCreated:
+	case obj.GetResourceVersion() == current.GetResourceVersion():
+		p.planned.delete(key)
+		op = controllerutil.OperationResultNone
+	default:
+		p.planned.delete(key)
+	}
+	return op, nil
+}
+
+// plannedSet keeps objects reported as planned in observe-only mode, by kind and key.
+type plannedSet struct {
+	mu   sync.Mutex
+	keys map[string]struct{}
+}
+
+func newPlannedSet() *plannedSet {
+	return &plannedSet{keys: make(map[string]struct{})}
+}
+
+// add returns false when key is already planned.
+func (s *plannedSet) add(key string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.keys[key]; ok {
+		return false
+	}
+	s.keys[key] = struct{}{}
+	return true
+}
+
+func (s *plannedSet) delete(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.keys, key)
+}
+
+// reportConflicts logs, counts and records events of fields owned by other managers.
+// Event is recorded on the object and on webhook service with its webhook configurations.
+func (p *Proxy) reportConflicts(ctx context.Context, obj client.Object, kind string, conflicts []string, force bool, serviceOrigin *v1.Service) {
+	action := "are kept"
+	if force {
+		action = "are taken over"
+	}
+
+	metrics.FieldConflicts.WithLabelValues(kind).Inc()
+	p.log.Info("fields owned by other field managers "+action,
+		"kind", kind, "object", client.ObjectKeyFromObject(obj), "conflicts", conflicts)
+
+	message := strings.Join(conflicts, "; ")
+	if _, ok := obj.(*v1.Service); !ok || serviceOrigin == nil || obj.GetName() != serviceOrigin.Name {
+		p.recorder.Eventf(obj, v1.EventTypeWarning, EventReasonFieldConflict,
+			"fields owned by other field managers %s by %s: %s", action, FieldManager, message)
+	}
+	if serviceOrigin != nil {
+		p.recordEvent(ctx, serviceOrigin, v1.EventTypeWarning, EventReasonFieldConflict,
+			"fields of %s %s owned by other field managers %s: %s", kind, obj.GetName(), action, message)
+	}
+}
+
+// fieldConflicts returns conflicts of server-side apply error, e.g. `conflict with "helm" using v1: .spec.selector`.
+func fieldConflicts(err error) []string {
+	var status apierrors.APIStatus
+	if !errors.As(err, &status) || status.Status().Details == nil {
+		return []string{err.Error()}
+	}
+
+	var conflicts []string
+	for _, cause := range status.Status().Details.Causes {
+		if cause.Type == metav1.CauseTypeFieldManagerConflict {
+			conflicts = append(conflicts, cause.Message)
+		}
+	}
+	if len(conflicts) == 0 {
+		return []string{err.Error()}
+	}
+	sort.Strings(conflicts)
+	return conflicts
+}
@@ -0,0 +1,220 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/CharlieR-o-o-t/eks-webhook-proxy/config"
+	"github.com/CharlieR-o-o-t/eks-webhook-proxy/pkg/tracing"
+	"github.com/CharlieR-o-o-t/eks-webhook-proxy/pkg/utils"
+	"github.com/go-logr/logr"
+	v1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+)
+
+const (
+	// controlPlaneNetworkSet is the name of the GlobalNetworkSet holding allowed source CIDRs.
+	controlPlaneNetworkSet = utils.ControllerName + "-control-plane"
+	// controlPlaneNetworkSetValue is the value of utils.LabelNetworkSet on the shared GlobalNetworkSet.
+	controlPlaneNetworkSetValue = "control-plane"
+)
+
+var (
+	calicoNetworkPolicyGVK = schema.GroupVersionKind{
+		Group:   "projectcalico.org",
+		Version: "v3",
+		Kind:    "NetworkPolicy",
+	}
+	calicoGlobalNetworkSetGVK = schema.GroupVersionKind{
+		Group:   "projectcalico.org",
+		Version: "v3",
+		Kind:    "GlobalNetworkSet",
+	}
+)
+
+// ensureGlobalNetworkSet maintains the single GlobalNetworkSet with allowed source CIDRs,
+// which is referenced by label from every calico webhook network policy.
+func (p *Proxy) ensureGlobalNetworkSet(ctx context.Context, logger logr.Logger) error {
+	networkSet := new(unstructured.Unstructured)
+	networkSet.SetGroupVersionKind(calicoGlobalNetworkSetGVK)
+	networkSet.SetName(controlPlaneNetworkSet)
+
+	if err := p.buildGlobalNetworkSet(networkSet); err != nil {
+		return err
+	}
+	op, err := p.apply(ctx, networkSet, true, nil)
+	if err != nil {
+		return fmt.Errorf("failed to ensure global network set %s, %w", controlPlaneNetworkSet, err)
+	}
+
+	logger.V(4).Info("global network set has been ensured", "name", controlPlaneNetworkSet, "operation", op)
+	return nil
+}
+
+// ensureCalicoNetworkPolicy creates projectcalico.org/v3 NetworkPolicy allowing
+// webhook ports only from the control-plane GlobalNetworkSet.
+func (p *Proxy) ensureCalicoNetworkPolicy(ctx context.Context, serviceOrigin *v1.Service, target *policyTarget, logger logr.Logger) error {
+	if err := p.ensureGlobalNetworkSet(ctx, logger); err != nil {
+		return err
+	}
+
+	networkPolicy := new(unstructured.Unstructured)
+	networkPolicy.SetGroupVersionKind(calicoNetworkPolicyGVK)
+	networkPolicy.SetName(getProxyName(serviceOrigin.Name, serviceNameHashLen))
+	networkPolicy.SetNamespace(serviceOrigin.Namespace)
+
+	if err := p.buildCalicoNetworkPolicy(networkPolicy, serviceOrigin, target); err != nil {
+		return err
+	}
+	op, err := p.apply(ctx, networkPolicy, true, serviceOrigin)
+	if err != nil {
+		logger.Error(err, "failed to ensure calico network policy")
+		return err
+	}
+
+	if op == controllerutil.OperationResultCreated || op == OperationResultPlanned {
+		p.recordEvent(ctx, serviceOrigin, v1.EventTypeNormal, EventReasonNetworkPolicyCreated,
+			"created calico network policy %s", networkPolicy.GetName())
+	}
+
+	tracing.SetAttributes(ctx, tracing.Operation(op))
+	logger.V(4).Info("calico network policy has been ensured", "operation", op)
+	return nil
+}
+
+// buildGlobalNetworkSet sets allowed source CIDRs of the shared GlobalNetworkSet.
+func (p *Proxy) buildGlobalNetworkSet(networkSet *unstructured.Unstructured) error {
+	allowedSrcCIDRs := p.allowedSrcCIDRs(p.cfg().Proxy.AllowedSrcCIDRs)
+	nets := make([]interface{}, 0, len(allowedSrcCIDRs))
+	for _, cidr := range allowedSrcCIDRs {
+		nets = append(nets, cidr)
+	}
+
+	networkSet.SetLabels(map[string]string{
+		utils.LabelManagedBy:  utils.ControllerName,
+		utils.LabelNetworkSet: controlPlaneNetworkSetValue,
+	})
+	return unstructured.SetNestedSlice(networkSet.Object, nets, "spec", "nets")
+}
+
+// buildCalicoNetworkPolicy sets projectcalico.org/v3 NetworkPolicy spec of webhook pods.
+func (p *Proxy) buildCalicoNetworkPolicy(networkPolicy *unstructured.Unstructured, serviceOrigin *v1.Service, target *policyTarget) error {
+	labels := map[string]string{
+		utils.LabelManagedBy:      utils.ControllerName,
+		utils.LabelServiceProxyOf: serviceOrigin.Name,
+	}
+	if instance, ok := serviceOrigin.Labels[utils.LabelAppInstance]; ok {
+		labels[utils.LabelPartOf] = instance
+	}
+	networkPolicy.SetLabels(labels)
+
+	spec := map[string]interface{}{
+		"selector": calicoSelector(target.selector),
+		"types":    []interface{}{"Ingress"},
+		"ingress":  calicoIngressRules(target),
+	}
+	if err := unstructured.SetNestedMap(networkPolicy.Object, spec, "spec"); err != nil {
+		return err
+	}
+	return controllerutil.SetControllerReference(serviceOrigin, networkPolicy, p.client.Scheme())
+}
+
+// calicoIngressRules builds one Allow rule per protocol, with source limited to the control-plane network set.
+// Service with own CIDRs gets them inline instead of the network set.
+// Node addresses are allowed by a separate rule in Cluster traffic policy mode.
+func calicoIngressRules(target *policyTarget) []interface{} {
+	portsByProtocol := make(map[v1.Protocol][]interface{})
+	for _, targetPort := range target.ports {
+		var port interface{} = int64(targetPort.port.IntValue())
+		if targetPort.port.Type == intstr.String {
+			port = targetPort.port.StrVal
+		}
+		portsByProtocol[targetPort.protocol] = append(portsByProtocol[targetPort.protocol], port)
+	}
+
+	var nodeNets []interface{}
+	for _, cidr := range target.nodeCIDRs {
+		nodeNets = append(nodeNets, cidr)
+	}
+
+	source := map[string]interface{}{
+		"namespaceSelector": "global()",
+		"selector":          fmt.Sprintf("%s == '%s'", utils.LabelNetworkSet, controlPlaneNetworkSetValue),
+	}
+	if target.customCIDRs {
+		var nets []interface{}
+		for _, cidr := range target.sourceCIDRs {
+			nets = append(nets, cidr)
+		}
+		source = map[string]interface{}{
+			"nets": nets,
+		}
+	}
+
+	protocols := make([]string, 0, len(portsByProtocol))
+	for protocol := range portsByProtocol {
+		protocols = append(protocols, string(protocol))
+	}
+	sort.Strings(protocols)
+
+	rules := make([]interface{}, 0, len(protocols))
+	for _, protocol := range protocols {
+		rules = append(rules, map[string]interface{}{
+			"action":   "Allow",
+			"protocol": protocol,
+			"source":   source,
+			"destination": map[string]interface{}{
+				"ports": portsByProtocol[v1.Protocol(protocol)],
+			},
+		})
+
+		if len(nodeNets) > 0 {
+			rules = append(rules, map[string]interface{}{
+				"action":   "Allow",
+				"protocol": protocol,
+				"source": map[string]interface{}{
+					"nets": nodeNets,
+				},
+				"destination": map[string]interface{}{
+					"ports": portsByProtocol[v1.Protocol(protocol)],
+				},
+			})
+		}
+	}
+
+	return rules
+}
+
+// calicoSelector converts label selector map into calico selector expression.
+func calicoSelector(selector map[string]string) string {
+	if len(selector) == 0 {
+		return "all()"
+	}
+
+	keys := make([]string, 0, len(selector))
+	for key := range selector {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	terms := make([]string, 0, len(keys))
+	for _, key := range keys {
+		terms = append(terms, fmt.Sprintf("%s == '%s'", key, selector[key]))
+	}
+
+	return strings.Join(terms, " && ")
+}
+
+// PolicyKinds returns kinds the network policy backend creates, they must be served by the cluster.
+func PolicyKinds(backend string) []schema.GroupVersionKind {
+	if backend == config.PolicyBackendCalico {
+		return []schema.GroupVersionKind{calicoNetworkPolicyGVK, calicoGlobalNetworkSetGVK}
+	}
+	return []schema.GroupVersionKind{networkingv1.SchemeGroupVersion.WithKind("NetworkPolicy")}
+}
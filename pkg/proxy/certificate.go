@@ -0,0 +1,218 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/CharlieR-o-o-t/eks-webhook-proxy/api/v1alpha1"
+	"github.com/CharlieR-o-o-t/eks-webhook-proxy/config"
+	"github.com/CharlieR-o-o-t/eks-webhook-proxy/pkg/metrics"
+	"github.com/CharlieR-o-o-t/eks-webhook-proxy/pkg/tlscheck"
+	"github.com/CharlieR-o-o-t/eks-webhook-proxy/pkg/webhookref"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// Certificate check reasons, besides tlscheck results.
+const (
+	certificateReasonNoEndpoint     = "NoReadyEndpoint"
+	certificateReasonPortNotProxied = "PortNotProxied"
+	certificateReasonRestricted     = "Restricted"
+)
+
+// certificateCache keeps the last certificate checks per webhook service,
+// so handshakes are done once per interval and not on every status update.
+type certificateCache struct {
+	mu   sync.Mutex
+	data map[types.NamespacedName]certificateCheck
+}
+
+type certificateCheck struct {
+	checkedAt    time.Time
+	certificates []v1alpha1.CertificateStatus
+}
+
+func newCertificateCache() *certificateCache {
+	return &certificateCache{
+		data: make(map[types.NamespacedName]certificateCheck),
+	}
+}
+
+func (c *certificateCache) get(service types.NamespacedName, interval time.Duration) ([]v1alpha1.CertificateStatus, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	check, ok := c.data[service]
+	if !ok || time.Since(check.checkedAt) > interval {
+		return nil, false
+	}
+	return check.certificates, true
+}
+
+func (c *certificateCache) set(service types.NamespacedName, certificates []v1alpha1.CertificateStatus) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.data[service] = certificateCheck{checkedAt: time.Now(), certificates: certificates}
+}
+
+func (c *certificateCache) delete(service types.NamespacedName) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.data, service)
+}
+
+// CheckCertificates performs TLS handshake with every webhook calling the service through a ready node endpoint,
+// the way kube-apiserver does, and reports results in status, metrics and events.
+func (p *Proxy) CheckCertificates(ctx context.Context, serviceOrigin *v1.Service, status *v1alpha1.WebhookProxyStatus) error {
+	checkConfig := p.cfg().CertCheck
+	if !checkConfig.Enabled {
+		return nil
+	}
+
+	serviceKey := types.NamespacedName{Namespace: serviceOrigin.Namespace, Name: serviceOrigin.Name}
+
+	certificates, ok := p.certificates.get(serviceKey, checkConfig.Interval)
+	if !ok {
+		clientConfigs, err := webhookref.ClientConfigs(ctx, p.client, serviceKey, status.Webhooks)
+		if err != nil {
+			return err
+		}
+
+		certificates = p.checkCertificates(ctx, serviceKey, clientConfigs, status, checkConfig)
+		// Missing endpoints are retried on the next status update.
+		if !hasCertificateReason(certificates, certificateReasonNoEndpoint) {
+			p.certificates.set(serviceKey, certificates)
+		}
+	}
+	status.Certificates = certificates
+
+	condition := certificateCondition(certificates)
+	meta.SetStatusCondition(&status.Conditions, condition)
+
+	// Events are emitted on condition change only, status is updated much more often than certificates change.
+	var webhookProxy = new(v1alpha1.WebhookProxy)
+	if err := p.client.Get(ctx, serviceKey, webhookProxy); err != nil && !apierrors.IsNotFound(err) {
+		return err
+	}
+	previous := meta.FindStatusCondition(webhookProxy.Status.Conditions, v1alpha1.ConditionCertificateValid)
+	if previous != nil && previous.Status == condition.Status && previous.Reason == condition.Reason {
+		return nil
+	}
+
+	switch {
+	case condition.Status == metav1.ConditionFalse:
+		p.recordEvent(ctx, serviceOrigin, v1.EventTypeWarning, EventReasonCertificateInvalid, "%s", condition.Message)
+	case condition.Reason == tlscheck.ReasonExpiringSoon:
+		p.recordEvent(ctx, serviceOrigin, v1.EventTypeWarning, EventReasonCertificateExpiring, "%s", condition.Message)
+	}
+	return nil
+}
+
+func (p *Proxy) checkCertificates(ctx context.Context, serviceKey types.NamespacedName, clientConfigs []webhookref.ClientConfig, status *v1alpha1.WebhookProxyStatus, checkConfig config.CertCheck) []v1alpha1.CertificateStatus {
+	// kube-apiserver verifies serving certificate against <service>.<namespace>.svc.
+	serverName := fmt.Sprintf("%s.%s.svc", serviceKey.Name, serviceKey.Namespace)
+
+	var address string
+	for _, endpoint := range status.Endpoints {
+		if endpoint.Ready {
+			address = endpoint.Address
+			break
+		}
+	}
+
+	certificates := make([]v1alpha1.CertificateStatus, 0, len(clientConfigs))
+	for _, clientConfig := range clientConfigs {
+		certificate := v1alpha1.CertificateStatus{Webhook: clientConfig.Webhook}
+
+		nodePort, found := findNodePort(status.NodePorts, clientConfig.Port)
+		switch {
+		case !found:
+			certificate.Reason = certificateReasonPortNotProxied
+			certificate.Message = fmt.Sprintf("service port %d is not proxied", clientConfig.Port)
+		case p.controllerBlocked(status.Restricted):
+			certificate.Reason = certificateReasonRestricted
+			certificate.Message = "network policy admits only allowed source CIDRs, certificate is not checked"
+		case address == "":
+			certificate.Reason = certificateReasonNoEndpoint
+			certificate.Message = "no ready node endpoint to check certificate through"
+		default:
+			certificate.Endpoint = net.JoinHostPort(address, strconv.Itoa(int(nodePort)))
+			result := tlscheck.Check(ctx, certificate.Endpoint, serverName, clientConfig.CABundle,
+				checkConfig.Timeout, checkConfig.ExpiryWarning)
+
+			certificate.Reason = result.Reason
+			certificate.Message = result.Message
+			if !result.NotAfter.IsZero() {
+				certificate.NotAfter = &metav1.Time{Time: result.NotAfter}
+				metrics.CertificateExpiry.WithLabelValues(serviceKey.Namespace, serviceKey.Name, clientConfig.Webhook).
+					Set(float64(result.NotAfter.Unix()))
+			}
+
+			valid := 0.0
+			if result.Valid() {
+				valid = 1
+			}
+			metrics.CertificateValid.WithLabelValues(serviceKey.Namespace, serviceKey.Name, clientConfig.Webhook).Set(valid)
+		}
+
+		certificates = append(certificates, certificate)
+	}
+	return certificates
+}
+
+// certificateCondition aggregates certificate checks, the first invalid one sets the reason.
+func certificateCondition(certificates []v1alpha1.CertificateStatus) metav1.Condition {
+	condition := metav1.Condition{
+		Type:    v1alpha1.ConditionCertificateValid,
+		Status:  metav1.ConditionTrue,
+		Reason:  tlscheck.ReasonVerified,
+		Message: fmt.Sprintf("%d webhook certificates are verified", len(certificates)),
+	}
+
+	for _, certificate := range certificates {
+		message := fmt.Sprintf("%s: %s", certificate.Webhook, certificate.Message)
+		switch certificate.Reason {
+		case tlscheck.ReasonVerified:
+		case tlscheck.ReasonExpiringSoon:
+			if condition.Reason == tlscheck.ReasonVerified {
+				condition.Reason, condition.Message = certificate.Reason, message
+			}
+		case certificateReasonNoEndpoint, certificateReasonPortNotProxied, certificateReasonRestricted:
+			if condition.Status == metav1.ConditionTrue {
+				condition.Status, condition.Reason, condition.Message = metav1.ConditionUnknown, certificate.Reason, message
+			}
+		default:
+			return metav1.Condition{
+				Type:    v1alpha1.ConditionCertificateValid,
+				Status:  metav1.ConditionFalse,
+				Reason:  certificate.Reason,
+				Message: message,
+			}
+		}
+	}
+	return condition
+}
+
+func findNodePort(nodePorts []v1alpha1.NodePort, port int32) (int32, bool) {
+	for _, nodePort := range nodePorts {
+		if nodePort.Port == port && nodePort.Protocol == v1.ProtocolTCP {
+			return nodePort.NodePort, true
+		}
+	}
+	return 0, false
+}
+
+func hasCertificateReason(certificates []v1alpha1.CertificateStatus, reason string) bool {
+	for _, certificate := range certificates {
+		if certificate.Reason == reason {
+			return true
+		}
+	}
+	return false
+}
@@ -4,12 +4,16 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"github.com/CharlieR-o-o-t/eks-webhook-proxy/pkg/metrics"
+	"github.com/CharlieR-o-o-t/eks-webhook-proxy/pkg/tracing"
 	"github.com/CharlieR-o-o-t/eks-webhook-proxy/pkg/utils"
+	"time"
 
 	"github.com/go-logr/logr"
 	v1 "k8s.io/api/core/v1"
 	discoveryv1 "k8s.io/api/discovery/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/utils/ptr"
 	"sigs.k8s.io/controller-runtime/pkg/client"
@@ -24,11 +28,14 @@ var (
 // Using <node>:<node-port> as endpoints, to handle webhook traffic from EKS control-plane.
 // TODO: endpoint slice limited to 100 endpoints.
 // TODO: Need wrap this function to split endpoints into portions (few endpoint slice, united by service-name label).
-func (p *Proxy) EnsureProxyEndpointSlices(ctx context.Context, proxyService *v1.Service) error {
+func (p *Proxy) EnsureProxyEndpointSlices(ctx context.Context, proxyService *v1.Service) (err error) {
 	proxyServiceKey := types.NamespacedName{
 		Namespace: proxyService.Namespace,
 		Name:      proxyService.Name,
 	}
+
+	ctx, span := tracing.Start(ctx, "EnsureProxyEndpointSlices", tracing.Service(proxyServiceKey))
+	defer func() { tracing.End(span, err) }()
 	log := p.log.WithValues("service", proxyServiceKey)
 
 	webhookServiceName, ok := proxyService.Labels[utils.LabelServiceProxyOf]
@@ -39,6 +46,19 @@ func (p *Proxy) EnsureProxyEndpointSlices(ctx context.Context, proxyService *v1.
 		)
 	}
 
+	var serviceOrigin = new(v1.Service)
+	if err := p.client.Get(ctx, types.NamespacedName{Namespace: proxyService.Namespace, Name: webhookServiceName}, serviceOrigin); err != nil {
+		log.Error(err, "failed to get webhook service")
+		return err
+	}
+	settings := p.resolveSettings(ctx, serviceOrigin)
+
+	allowedNodes, err := p.getAllowedNodes(ctx, settings.nodeSelector)
+	if err != nil {
+		log.Error(err, "failed to list nodes matching selector")
+		return err
+	}
+
 	proxyEndpointSliceKey := types.NamespacedName{
 		Namespace: proxyService.Namespace,
 		Name:      getProxyName(proxyService.Name, serviceNameHashLen),
@@ -53,6 +73,14 @@ func (p *Proxy) EnsureProxyEndpointSlices(ctx context.Context, proxyService *v1.
 		log.Error(err, "failed to get endpoint slice")
 		return err
 	}
+	// Proxy service is not written in observe-only mode and has no pod endpoints,
+	// webhook service selects the same pods, the way RenderService does.
+	if len(endpointSlices) == 0 && p.cfg().Proxy.ObserveOnly {
+		if endpointSlices, err = p.getEndpointSlices(ctx, types.NamespacedName{Namespace: proxyService.Namespace, Name: webhookServiceName}); err != nil {
+			log.Error(err, "failed to get endpoint slice")
+			return err
+		}
+	}
 
 	// We need to collect service endpoints from slices.
 	var webhookEndpoints []discoveryv1.Endpoint
@@ -60,36 +88,98 @@ func (p *Proxy) EnsureProxyEndpointSlices(ctx context.Context, proxyService *v1.
 		webhookEndpoints = append(webhookEndpoints, endpointSlice.Endpoints...)
 	}
 
-	proxyEndpointSliceObj := p.generateProxyEndpointSlice(
+	proxyEndpointSliceObj, skipped := p.generateProxyEndpointSlice(
 		log,
 		proxyEndpointSliceKey.Name,
 		webhookEndpoints,
 		proxyService,
+		allowedNodes,
 	)
-	proxyEndpointSlice := proxyEndpointSliceObj.DeepCopy()
-
-	op, err := controllerutil.CreateOrUpdate(ctx, p.client,
-		proxyEndpointSliceObj,
-		func() error {
-			proxyEndpointSliceObj.Labels = map[string]string{
-				utils.LabelEndpointSliceServiceName: webhookServiceName,
-				utils.LabelEdpointSliceManagedBy:    utils.ControllerName,
-			}
-			proxyEndpointSliceObj.Ports = proxyEndpointSlice.Ports
-			proxyEndpointSliceObj.Endpoints = proxyEndpointSlice.Endpoints
-
-			return controllerutil.SetControllerReference(proxyService, proxyEndpointSliceObj, p.client.Scheme())
-		},
-	)
+	if err := p.buildProxyEndpointSlice(proxyEndpointSliceObj, proxyEndpointSliceObj.DeepCopy(), proxyService, webhookServiceName); err != nil {
+		return err
+	}
+
+	op, err := p.apply(ctx, proxyEndpointSliceObj, true, serviceOrigin)
 	if err != nil {
-		return client.IgnoreAlreadyExists(err)
+		return err
+	}
+
+	webhookServiceKey := types.NamespacedName{Namespace: proxyService.Namespace, Name: webhookServiceName}
+	for _, reason := range []string{metrics.SkipReasonNoNodeName, metrics.SkipReasonNoNodeIP, metrics.SkipReasonNodeSelector} {
+		metrics.SkippedEndpoints.WithLabelValues(webhookServiceKey.Namespace, webhookServiceKey.Name, reason).Set(float64(skipped[reason]))
+	}
+	// Probes of the controller are dropped by restricted policy, every endpoint would be marked not ready.
+	// Skipped endpoints are reported once per change, not on every ensure.
+	previous, _ := p.published.get(webhookServiceKey)
+	p.published.set(webhookServiceKey, proxyEndpointSliceObj, skipped, p.controllerBlocked(settings.restricted))
+	if skipped[metrics.SkipReasonNoNodeIP] > 0 && skipped[metrics.SkipReasonNoNodeIP] != previous.Skipped[metrics.SkipReasonNoNodeIP] {
+		p.recordEvent(ctx, serviceOrigin, v1.EventTypeWarning, EventReasonEndpointSkipped,
+			"%d webhook endpoint(s) are not published, node InternalIP is unknown", skipped[metrics.SkipReasonNoNodeIP])
+	}
+	if op != controllerutil.OperationResultNone {
+		if triggerTime, ok := lastChangeTriggerTime(endpointSlices); ok {
+			metrics.EndpointPropagation.Observe(time.Since(triggerTime).Seconds())
+		}
 	}
 
+	span.SetAttributes(
+		tracing.Operation(op),
+		tracing.AttrEndpoints.Int(len(proxyEndpointSliceObj.Endpoints)),
+	)
 	log.Info("ensured proxy endpoint slice", "name", proxyEndpointSliceKey, "op", op)
 	return nil
 }
 
-func (p *Proxy) generateProxyEndpointSlice(log logr.Logger, name string, webhookEndpoints []discoveryv1.Endpoint, proxyService *v1.Service) *discoveryv1.EndpointSlice {
+// RefreshProxyEndpointSlices rebuilds proxy endpoint slice of webhook service,
+// used when endpoint reachability is changed.
+func (p *Proxy) RefreshProxyEndpointSlices(ctx context.Context, service types.NamespacedName) error {
+	var proxyService = new(v1.Service)
+	if err := p.client.Get(ctx, types.NamespacedName{
+		Namespace: service.Namespace,
+		Name:      getProxyName(service.Name, serviceNameHashLen),
+	}, proxyService); err != nil {
+		return client.IgnoreNotFound(err)
+	}
+
+	return p.EnsureProxyEndpointSlices(ctx, proxyService)
+}
+
+// endpointHealthy tells if node address is reachable on all TCP ports, unknown endpoints are healthy.
+func (p *Proxy) endpointHealthy(address string, ports []discoveryv1.EndpointPort) bool {
+	if p.health == nil {
+		return true
+	}
+	for _, port := range tcpPorts(ports) {
+		if !p.health.Healthy(address, port) {
+			return false
+		}
+	}
+	return true
+}
+
+// lastChangeTriggerTime returns the latest pod change time, which triggered webhook endpoint slices update.
+func lastChangeTriggerTime(endpointSlices []discoveryv1.EndpointSlice) (time.Time, bool) {
+	var latest time.Time
+	for _, endpointSlice := range endpointSlices {
+		val, ok := endpointSlice.Annotations[v1.EndpointsLastChangeTriggerTime]
+		if !ok {
+			continue
+		}
+		triggerTime, err := time.Parse(time.RFC3339Nano, val)
+		if err != nil {
+			continue
+		}
+		if triggerTime.After(latest) {
+			latest = triggerTime
+		}
+	}
+	return latest, !latest.IsZero()
+}
+
+// generateProxyEndpointSlice builds proxy endpoint slice, allowedNodes limits published nodes (nil means all nodes).
+// Number of skipped webhook endpoints is returned by reason.
+func (p *Proxy) generateProxyEndpointSlice(log logr.Logger, name string, webhookEndpoints []discoveryv1.Endpoint, proxyService *v1.Service, allowedNodes map[string]struct{}) (*discoveryv1.EndpointSlice, map[string]int) {
+	skipped := make(map[string]int)
 	proxyEndpointSlice := &discoveryv1.EndpointSlice{
 		ObjectMeta: metav1.ObjectMeta{
 			Name:      name,
@@ -99,10 +189,70 @@ func (p *Proxy) generateProxyEndpointSlice(log logr.Logger, name string, webhook
 	}
 
 	// Add NodePorts to proxy endpoint slice.
+	proxyEndpointSlice.Ports = nodeEndpointPorts(proxyService)
+
+	// Add pod's node ipaddress to endpoints.
+	// probedDown are indexes of ready endpoints marked not ready by probes.
+	var probedDown []int
+	for _, webhookEndpoint := range webhookEndpoints {
+		endpoint, reason := p.proxyEndpoint(webhookEndpoint, proxyEndpointSlice.Ports, allowedNodes)
+		if endpoint == nil {
+			log.V(5).Info("skipping webhook endpoint", "endpoint", webhookEndpoint.String(), "reason", reason)
+			skipped[reason]++
+			continue
+		}
+		if !ptr.Deref(endpoint.Conditions.Ready, true) && ptr.Deref(webhookEndpoint.Conditions.Ready, true) {
+			log.V(4).Info("endpoint node is unreachable, marking not ready", "endpoint", webhookEndpoint.String(), "address", endpoint.Addresses[0])
+			probedDown = append(probedDown, len(proxyEndpointSlice.Endpoints))
+		}
+
+		proxyEndpointSlice.Endpoints = append(proxyEndpointSlice.Endpoints, *endpoint)
+	}
+
+	// Probes fail open: when every endpoint is unreachable, the probe is more likely broken
+	// than every node, endpoints keep readiness of webhook pods.
+	if len(probedDown) > 0 && !anyReady(proxyEndpointSlice.Endpoints) {
+		log.Info("all endpoints are unreachable, ignoring probe results", "endpoints", len(probedDown))
+		for _, i := range probedDown {
+			proxyEndpointSlice.Endpoints[i].Conditions.Ready = ptr.To(true)
+		}
+	}
+
+	return proxyEndpointSlice, skipped
+}
+
+func anyReady(endpoints []discoveryv1.Endpoint) bool {
+	for _, endpoint := range endpoints {
+		if ptr.Deref(endpoint.Conditions.Ready, true) {
+			return true
+		}
+	}
+	return false
+}
+
+// buildProxyEndpointSlice sets generated ports and endpoints on proxy endpoint slice.
+func (p *Proxy) buildProxyEndpointSlice(proxyEndpointSliceObj, generated *discoveryv1.EndpointSlice, proxyService *v1.Service, webhookServiceName string) error {
+	proxyEndpointSliceObj.Labels = map[string]string{
+		utils.LabelEndpointSliceServiceName: webhookServiceName,
+		utils.LabelEdpointSliceManagedBy:    utils.ControllerName,
+	}
+	proxyEndpointSliceObj.Ports = generated.Ports
+	proxyEndpointSliceObj.Endpoints = generated.Endpoints
+	// Empty list is applied explicitly, so endpoints of removed pods are dropped.
+	if proxyEndpointSliceObj.Endpoints == nil {
+		proxyEndpointSliceObj.Endpoints = []discoveryv1.Endpoint{}
+	}
+
+	return controllerutil.SetControllerReference(proxyService, proxyEndpointSliceObj, p.client.Scheme())
+}
+
+// nodeEndpointPorts returns node ports of proxy service as endpoint ports.
+func nodeEndpointPorts(proxyService *v1.Service) []discoveryv1.EndpointPort {
+	var ports []discoveryv1.EndpointPort
 	for i := range proxyService.Spec.Ports {
 		port := proxyService.Spec.Ports[i]
 
-		proxyEndpointSlice.Ports = append(proxyEndpointSlice.Ports,
+		ports = append(ports,
 			discoveryv1.EndpointPort{
 				Name:     ptr.To(port.Name),
 				Port:     ptr.To(port.NodePort),
@@ -110,28 +260,55 @@ func (p *Proxy) generateProxyEndpointSlice(log logr.Logger, name string, webhook
 			},
 		)
 	}
+	return ports
+}
 
-	// Add pod's node ipaddress to endpoints.
-	for _, webhookEndpoint := range webhookEndpoints {
-		if webhookEndpoint.NodeName == nil {
-			log.V(5).Info("skipping webhook endpoint, nodeName is nil", "endpoint", webhookEndpoint.String())
-			continue
-		}
+// proxyEndpoint maps webhook endpoint to its node address, ports are node ports of proxy endpoint slice.
+// When endpoint is not published, nil is returned with one of metrics.SkipReason* values.
+func (p *Proxy) proxyEndpoint(webhookEndpoint discoveryv1.Endpoint, ports []discoveryv1.EndpointPort, allowedNodes map[string]struct{}) (*discoveryv1.Endpoint, string) {
+	if webhookEndpoint.NodeName == nil {
+		return nil, metrics.SkipReasonNoNodeName
+	}
 
-		nodeIPAddress, found := p.nodeCache.Get(*webhookEndpoint.NodeName)
-		if !found {
-			log.V(5).Info("skipping endpoint node, no ipaddress found", "endpoint", webhookEndpoint.String(), "node", *webhookEndpoint.NodeName)
-			continue
+	if allowedNodes != nil {
+		if _, ok := allowedNodes[*webhookEndpoint.NodeName]; !ok {
+			return nil, metrics.SkipReasonNodeSelector
 		}
+	}
+
+	nodeIPAddress, found := p.nodeCache.Get(*webhookEndpoint.NodeName)
+	if !found {
+		return nil, metrics.SkipReasonNoNodeIP
+	}
+
+	conditions := webhookEndpoint.Conditions
+	if !p.endpointHealthy(nodeIPAddress, ports) {
+		conditions.Ready = ptr.To(false)
+	}
 
-		proxyEndpointSlice.Endpoints = append(proxyEndpointSlice.Endpoints,
-			discoveryv1.Endpoint{
-				Addresses:  []string{nodeIPAddress},
-				Conditions: webhookEndpoint.Conditions,
-			})
+	return &discoveryv1.Endpoint{
+		Addresses:  []string{nodeIPAddress},
+		Conditions: conditions,
+		NodeName:   webhookEndpoint.NodeName,
+	}, ""
+}
+
+// getAllowedNodes returns names of nodes matching selector, nil selector allows all nodes.
+func (p *Proxy) getAllowedNodes(ctx context.Context, selector labels.Selector) (map[string]struct{}, error) {
+	if selector == nil {
+		return nil, nil
 	}
 
-	return proxyEndpointSlice
+	var nodes = new(v1.NodeList)
+	if err := p.client.List(ctx, nodes, client.MatchingLabelsSelector{Selector: selector}); err != nil {
+		return nil, err
+	}
+
+	allowedNodes := make(map[string]struct{}, len(nodes.Items))
+	for _, node := range nodes.Items {
+		allowedNodes[node.Name] = struct{}{}
+	}
+	return allowedNodes, nil
 }
 
 // getEndpointSlices returns endpoint slice with real service endpoints.
@@ -0,0 +1,66 @@
+package proxy
+
+import (
+	"context"
+
+	"github.com/CharlieR-o-o-t/eks-webhook-proxy/pkg/webhookref"
+	admissionv1 "k8s.io/api/admissionregistration/v1"
+	v1 "k8s.io/api/core/v1"
+	apiextv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// Event reasons.
+const (
+	EventReasonProxyServiceCreated  = "ProxyServiceCreated"
+	EventReasonNetworkPolicyCreated = "NetworkPolicyCreated"
+	EventReasonNetworkPolicyDeleted = "NetworkPolicyDeleted"
+	EventReasonNetworkPolicyFailed  = "NetworkPolicyFailed"
+	EventReasonSelectorStripped     = "SelectorStripped"
+	EventReasonSelectorStripPlanned = "SelectorStripPlanned"
+	EventReasonSelectorRestored     = "SelectorRestored"
+	EventReasonProxyReleased        = "ProxyReleased"
+	EventReasonEndpointSkipped      = "EndpointSkipped"
+	EventReasonProxyFailed          = "ProxyFailed"
+	EventReasonCertificateInvalid   = "CertificateInvalid"
+	EventReasonCertificateExpiring  = "CertificateExpiring"
+	EventReasonFieldConflict        = "FieldConflict"
+)
+
+// recordEvent emits event on webhook service and on every selected webhook configuration or CRD referencing it,
+// so kubectl describe of any of them explains what happened.
+// In observe-only mode the message tells that nothing was applied.
+func (p *Proxy) recordEvent(ctx context.Context, serviceOrigin *v1.Service, eventType, reason, messageFmt string, args ...interface{}) {
+	if p.cfg().Proxy.ObserveOnly {
+		messageFmt = "observe-only mode, not applied: " + messageFmt
+	}
+	p.recorder.Eventf(serviceOrigin, eventType, reason, messageFmt, args...)
+
+	serviceKey := types.NamespacedName{Namespace: serviceOrigin.Namespace, Name: serviceOrigin.Name}
+	refs, err := webhookref.ListForService(ctx, p.client, p.cfg().Proxy.SelectionMode, serviceKey)
+	if err != nil {
+		p.log.V(4).Info("unable to list webhooks for event", "reason", reason, "err", err.Error())
+		return
+	}
+
+	for _, ref := range refs {
+		var obj client.Object
+		switch ref.Kind {
+		case webhookref.KindMutating:
+			obj = new(admissionv1.MutatingWebhookConfiguration)
+		case webhookref.KindValidating:
+			obj = new(admissionv1.ValidatingWebhookConfiguration)
+		case webhookref.KindCRD:
+			obj = new(apiextv1.CustomResourceDefinition)
+		default:
+			continue
+		}
+
+		if err := p.client.Get(ctx, types.NamespacedName{Name: ref.Name}, obj); err != nil {
+			continue
+		}
+		p.recorder.Eventf(obj, eventType, reason, "service %s/%s: "+messageFmt,
+			append([]interface{}{serviceOrigin.Namespace, serviceOrigin.Name}, args...)...)
+	}
+}
@@ -0,0 +1,191 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/CharlieR-o-o-t/eks-webhook-proxy/config"
+	"github.com/CharlieR-o-o-t/eks-webhook-proxy/pkg/utils"
+	"github.com/go-logr/logr"
+	v1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+var (
+	// ErrSelectorUnknown means that neither service selector nor its stash exists,
+	// policy with empty selector would select every pod in namespace.
+	ErrSelectorUnknown = errors.New("service selector is unknown")
+	// ErrNoSourceCIDRs means that there are no allowed sources yet (e.g. CIDRs are not derived).
+	ErrNoSourceCIDRs = errors.New("no allowed source CIDRs")
+)
+
+// policyTarget describes webhook pods and ports network policy should allow.
+type policyTarget struct {
+	selector map[string]string
+	ports    []policyPort
+	// sourceCIDRs are allowed source CIDRs of the service.
+	sourceCIDRs []string
+	// customCIDRs is set when service has own CIDRs, different from the global ones.
+	customCIDRs bool
+	// nodeCIDRs are node addresses, set only for Cluster traffic policy,
+	// because kube-proxy SNATs proxied traffic to node IPs.
+	nodeCIDRs []string
+}
+
+type policyPort struct {
+	protocol v1.Protocol
+	port     intstr.IntOrString
+}
+
+func (p *Proxy) getPolicyTarget(ctx context.Context, serviceOrigin, serviceProxy *v1.Service, settings *serviceSettings) (*policyTarget, error) {
+	selector := originalSelector(serviceOrigin)
+	if len(selector) == 0 {
+		return nil, ErrSelectorUnknown
+	}
+
+	ports, err := p.resolvePolicyPorts(ctx, serviceOrigin, settings.filterPorts(serviceOrigin.Spec.Ports), selector)
+	if err != nil {
+		return nil, err
+	}
+
+	target := &policyTarget{
+		selector:    selector,
+		ports:       ports,
+		sourceCIDRs: p.allowedSrcCIDRs(settings.allowedSrcCIDRs),
+		customCIDRs: settings.customCIDRs,
+	}
+
+	if serviceProxy != nil && serviceProxy.Spec.ExternalTrafficPolicy == v1.ServiceExternalTrafficPolicyTypeCluster {
+		for _, ip := range p.nodeCache.IPs() {
+			target.nodeCIDRs = append(target.nodeCIDRs, ip+"/32")
+		}
+	}
+
+	return target, nil
+}
+
+// deleteStaleNetworkPolicies deletes network policies of the service which current settings do not need:
+// all of them when the service is not restricted, otherwise the policy of the other backend.
+// Without it, a policy left from restricted mode keeps dropping traffic which is not from allowed CIDRs.
+func (p *Proxy) deleteStaleNetworkPolicies(ctx context.Context, serviceOrigin *v1.Service, settings *serviceSettings, logger logr.Logger) error {
+	backend := p.cfg().Proxy.PolicyBackend
+	key := types.NamespacedName{Namespace: serviceOrigin.Namespace, Name: getProxyName(serviceOrigin.Name, serviceNameHashLen)}
+
+	calicoPolicy := new(unstructured.Unstructured)
+	calicoPolicy.SetGroupVersionKind(calicoNetworkPolicyGVK)
+
+	policies := []struct {
+		backend string
+		kind    string
+		obj     client.Object
+	}{
+		{backend: config.PolicyBackendKubernetes, kind: "network policy", obj: new(networkingv1.NetworkPolicy)},
+		{backend: config.PolicyBackendCalico, kind: "calico network policy", obj: calicoPolicy},
+	}
+	for _, policy := range policies {
+		if settings.restricted && policy.backend == backend {
+			continue
+		}
+
+		deleted, err := p.deleteManaged(ctx, key, policy.obj)
+		// Calico kinds are granted by the chart only with calico backend, nothing could be created without it.
+		if apierrors.IsForbidden(err) && policy.backend == config.PolicyBackendCalico {
+			logger.V(5).Info("calico network policy is not accessible, skipping", "err", err.Error())
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("unable to delete %s %s, %w", policy.kind, key, err)
+		}
+		if deleted {
+			logger.Info("stale network policy has been deleted", "kind", policy.kind, "name", key.Name)
+			p.recordEvent(ctx, serviceOrigin, v1.EventTypeNormal, EventReasonNetworkPolicyDeleted,
+				"deleted %s %s, it is not needed with current settings", policy.kind, key.Name)
+		}
+	}
+
+	return nil
+}
+
+// resolvePolicyPorts collects target ports of the service.
+// Named target ports are resolved to container ports of webhook pods,
+// unresolved names are kept as is.
+func (p *Proxy) resolvePolicyPorts(ctx context.Context, serviceOrigin *v1.Service, servicePorts []v1.ServicePort, selector map[string]string) ([]policyPort, error) {
+	var pods *v1.PodList
+
+	ports := make([]policyPort, 0, len(servicePorts))
+	for _, servicePort := range servicePorts {
+		protocol := servicePort.Protocol
+		if protocol == "" {
+			protocol = v1.ProtocolTCP
+		}
+
+		targetPort := servicePort.TargetPort
+		if !utils.IsTargetPortSet(targetPort) {
+			targetPort = intstr.FromInt32(servicePort.Port)
+		}
+
+		if targetPort.Type == intstr.Int {
+			ports = append(ports, policyPort{protocol: protocol, port: targetPort})
+			continue
+		}
+
+		if pods == nil {
+			pods = new(v1.PodList)
+			if err := p.apiReader.List(ctx, pods,
+				client.InNamespace(serviceOrigin.Namespace),
+				client.MatchingLabels(selector),
+			); err != nil {
+				return nil, fmt.Errorf("unable to list webhook pods, %w", err)
+			}
+		}
+
+		resolved := resolveNamedPort(pods.Items, targetPort.StrVal, protocol)
+		if len(resolved) == 0 {
+			ports = append(ports, policyPort{protocol: protocol, port: targetPort})
+			continue
+		}
+		for _, port := range resolved {
+			ports = append(ports, policyPort{protocol: protocol, port: intstr.FromInt32(port)})
+		}
+	}
+
+	return ports, nil
+}
+
+// resolveNamedPort returns all distinct container port numbers with the given name.
+func resolveNamedPort(pods []v1.Pod, name string, protocol v1.Protocol) []int32 {
+	set := make(map[int32]struct{})
+	for _, pod := range pods {
+		for _, container := range pod.Spec.Containers {
+			for _, containerPort := range container.Ports {
+				containerProtocol := containerPort.Protocol
+				if containerProtocol == "" {
+					containerProtocol = v1.ProtocolTCP
+				}
+				if containerPort.Name == name && containerProtocol == protocol {
+					set[containerPort.ContainerPort] = struct{}{}
+				}
+			}
+		}
+	}
+
+	ports := make([]int32, 0, len(set))
+	for port := range set {
+		ports = append(ports, port)
+	}
+	sort.Slice(ports, func(i, j int) bool { return ports[i] < ports[j] })
+	return ports
+}
+
+// controllerBlocked tells if network policy of the service drops connections of the controller: restricted
+// policy admits only allowed source CIDRs and Local traffic policy keeps the client address on the pod node.
+func (p *Proxy) controllerBlocked(restricted bool) bool {
+	return restricted && p.cfg().Proxy.RestrictedTrafficPolicy == config.TrafficPolicyLocal
+}
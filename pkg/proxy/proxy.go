@@ -2,13 +2,16 @@ package proxy
 
 import (
 	"fmt"
+	"slices"
 	"strings"
 
 	"crypto/sha256"
 	"encoding/hex"
-	"github.com/go-logr/logr"
-	"github.com/CharlieR-o-o-t/eks-webhook-proxy/pkg/nodecache"
 	"github.com/CharlieR-o-o-t/eks-webhook-proxy/config"
+	"github.com/CharlieR-o-o-t/eks-webhook-proxy/pkg/cidrcache"
+	"github.com/CharlieR-o-o-t/eks-webhook-proxy/pkg/nodecache"
+	"github.com/go-logr/logr"
+	"k8s.io/client-go/tools/record"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/log"
 )
@@ -19,19 +22,65 @@ const (
 )
 
 type Proxy struct {
-	config    *config.Config
-	client    client.Client
-	nodeCache *nodecache.NodeIPCache
-	log       logr.Logger
+	config       *config.Store
+	client       client.Client
+	apiReader    client.Reader
+	recorder     record.EventRecorder
+	nodeCache    *nodecache.NodeIPCache
+	cidrCache    *cidrcache.CIDRCache
+	published    *publishedCache
+	certificates *certificateCache
+	failures     *failureCache
+	planned      *plannedSet
+	warnings     *annotationWarnings
+	health       EndpointHealth
+	log          logr.Logger
 }
 
-func New(client client.Client, config *config.Config, nodeCache *nodecache.NodeIPCache) *Proxy {
+// EndpointHealth reports reachability of published node endpoints.
+type EndpointHealth interface {
+	Healthy(address string, port int32) bool
+}
+
+func New(client client.Client, apiReader client.Reader, recorder record.EventRecorder, config *config.Store, nodeCache *nodecache.NodeIPCache, cidrCache *cidrcache.CIDRCache) *Proxy {
 	return &Proxy{
-		config:    config,
-		client:    client,
-		nodeCache: nodeCache,
-		log:       log.Log.WithName("proxy"),
+		config:       config,
+		client:       client,
+		apiReader:    apiReader,
+		recorder:     recorder,
+		nodeCache:    nodeCache,
+		cidrCache:    cidrCache,
+		published:    newPublishedCache(),
+		certificates: newCertificateCache(),
+		failures:     newFailureCache(),
+		planned:      newPlannedSet(),
+		warnings:     newAnnotationWarnings(),
+		log:          log.Log.WithName("proxy"),
+	}
+}
+
+// SetEndpointHealth makes unreachable node endpoints published as not ready.
+// Must be called before manager is started.
+func (p *Proxy) SetEndpointHealth(health EndpointHealth) {
+	p.health = health
+}
+
+// cfg returns current configuration.
+func (p *Proxy) cfg() *config.Config {
+	return p.config.Get()
+}
+
+// IsExcluded tells if namespace is excluded from proxying.
+func (p *Proxy) IsExcluded(namespace string) bool {
+	return slices.Contains(p.cfg().Proxy.ExcludedNamespaces, namespace)
+}
+
+// allowedSrcCIDRs returns static CIDRs merged with ones derived from kubernetes API endpoints.
+func (p *Proxy) allowedSrcCIDRs(static []string) []string {
+	if p.cidrCache == nil {
+		return static
 	}
+	return cidrcache.Merge(static, p.cidrCache.Get())
 }
 
 func getProxyName(serviceName string, hashLen int) string {
@@ -0,0 +1,142 @@
+package proxy
+
+import (
+	"sync"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/utils/ptr"
+)
+
+// PublishedEndpoint is a node endpoint published in proxy endpoint slice.
+type PublishedEndpoint struct {
+	Address string `json:"address"`
+	Node    string `json:"node"`
+	Ready   bool   `json:"ready"`
+}
+
+// PublishedEndpoints are the last computed proxy endpoints of webhook service.
+type PublishedEndpoints struct {
+	Endpoints []PublishedEndpoint `json:"endpoints"`
+	// Ports are TCP node ports of proxy service.
+	Ports []int32 `json:"ports"`
+	// Skipped is number of webhook endpoints not published, by reason.
+	Skipped map[string]int `json:"skipped,omitempty"`
+	// SkipProbe is set when network policy admits only allowed source CIDRs to the webhook pods and
+	// node ports are not forwarded across nodes, the controller can not dial the endpoints.
+	SkipProbe bool      `json:"skipProbe,omitempty"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// publishedCache keeps the last computed proxy endpoints per webhook service, for debugging.
+type publishedCache struct {
+	mu   sync.RWMutex
+	data map[types.NamespacedName]PublishedEndpoints
+}
+
+func newPublishedCache() *publishedCache {
+	return &publishedCache{
+		data: make(map[types.NamespacedName]PublishedEndpoints),
+	}
+}
+
+func (c *publishedCache) get(service types.NamespacedName) (PublishedEndpoints, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	published, ok := c.data[service]
+	return published, ok
+}
+
+func (c *publishedCache) set(service types.NamespacedName, endpointSlice *discoveryv1.EndpointSlice, skipped map[string]int, skipProbe bool) {
+	endpoints := endpointSlice.Endpoints
+	published := PublishedEndpoints{
+		Endpoints: make([]PublishedEndpoint, 0, len(endpoints)),
+		Ports:     tcpPorts(endpointSlice.Ports),
+		Skipped:   skipped,
+		SkipProbe: skipProbe,
+		UpdatedAt: time.Now(),
+	}
+	for _, endpoint := range endpoints {
+		var address string
+		if len(endpoint.Addresses) > 0 {
+			address = endpoint.Addresses[0]
+		}
+		published.Endpoints = append(published.Endpoints, PublishedEndpoint{
+			Address: address,
+			Node:    ptr.Deref(endpoint.NodeName, ""),
+			Ready:   ptr.Deref(endpoint.Conditions.Ready, true),
+		})
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.data[service] = published
+}
+
+func tcpPorts(endpointPorts []discoveryv1.EndpointPort) []int32 {
+	var ports []int32
+	for _, port := range endpointPorts {
+		if port.Port == nil || ptr.Deref(port.Protocol, v1.ProtocolTCP) != v1.ProtocolTCP {
+			continue
+		}
+		ports = append(ports, *port.Port)
+	}
+	return ports
+}
+
+func (c *publishedCache) delete(service types.NamespacedName) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.data, service)
+}
+
+// PublishedEndpoints returns the last computed proxy endpoints by webhook service.
+func (p *Proxy) PublishedEndpoints() map[types.NamespacedName]PublishedEndpoints {
+	p.published.mu.RLock()
+	defer p.published.mu.RUnlock()
+
+	result := make(map[types.NamespacedName]PublishedEndpoints, len(p.published.data))
+	for service, published := range p.published.data {
+		result[service] = published
+	}
+	return result
+}
+
+// ForgetService drops in-memory state of webhook service which is no longer proxied.
+func (p *Proxy) ForgetService(service types.NamespacedName) {
+	p.published.delete(service)
+	p.certificates.delete(service)
+	p.failures.set(service, nil)
+}
+
+// failureCache keeps the last proxy ensure error per webhook service, reported in WebhookProxy status.
+type failureCache struct {
+	mu   sync.RWMutex
+	data map[types.NamespacedName]string
+}
+
+func newFailureCache() *failureCache {
+	return &failureCache{
+		data: make(map[types.NamespacedName]string),
+	}
+}
+
+// set records result of proxy ensure, nil error clears the failure.
+func (c *failureCache) set(service types.NamespacedName, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err == nil {
+		delete(c.data, service)
+		return
+	}
+	c.data[service] = err.Error()
+}
+
+func (c *failureCache) get(service types.NamespacedName) (string, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	message, ok := c.data[service]
+	return message, ok
+}
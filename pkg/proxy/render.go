@@ -0,0 +1,167 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/CharlieR-o-o-t/eks-webhook-proxy/config"
+	"github.com/CharlieR-o-o-t/eks-webhook-proxy/pkg/utils"
+	v1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// NodePortAllocator returns a free node port for service port, used when rendering proxy services offline.
+type NodePortAllocator func(servicePort v1.ServicePort) (int32, error)
+
+// RenderService builds proxy objects of webhook service with the controller builders, without writing them:
+// selector stripping patch of the service, proxy service, proxy endpoint slice and network policy.
+// Objects are read with the proxy client, which is expected to serve a snapshot of the cluster.
+// Node ports of existing proxy service are kept, missing ones are taken from allocate.
+func (p *Proxy) RenderService(ctx context.Context, serviceOrigin *v1.Service, allocate NodePortAllocator) ([]client.Object, error) {
+	if p.IsExcluded(serviceOrigin.Namespace) || serviceOrigin.Spec.Type != v1.ServiceTypeClusterIP {
+		return nil, ErrServiceNotProxied
+	}
+	serviceKey := types.NamespacedName{Namespace: serviceOrigin.Namespace, Name: serviceOrigin.Name}
+	log := p.log.WithValues("service", serviceKey)
+
+	settings := p.resolveSettings(ctx, serviceOrigin)
+
+	servicePatch, err := renderServicePatch(serviceOrigin)
+	if err != nil {
+		return nil, err
+	}
+	objects := []client.Object{servicePatch}
+
+	proxyService := &v1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      getProxyName(serviceOrigin.Name, serviceNameHashLen),
+			Namespace: serviceOrigin.Namespace,
+		},
+		Spec: v1.ServiceSpec{
+			Type: v1.ServiceTypeNodePort,
+		},
+	}
+	var existing = new(v1.Service)
+	if err := p.client.Get(ctx, client.ObjectKeyFromObject(proxyService), existing); err != nil && !apierrors.IsNotFound(err) {
+		return nil, err
+	}
+	if err := p.buildProxyService(proxyService, serviceOrigin, settings); err != nil {
+		return nil, err
+	}
+	for i := range proxyService.Spec.Ports {
+		port := &proxyService.Spec.Ports[i]
+		for _, existingPort := range existing.Spec.Ports {
+			if existingPort.Port == port.Port && existingPort.Protocol == port.Protocol {
+				port.NodePort = existingPort.NodePort
+			}
+		}
+		if port.NodePort == 0 {
+			if port.NodePort, err = allocate(*port); err != nil {
+				return nil, fmt.Errorf("unable to allocate node port of service %s, %w", serviceKey, err)
+			}
+		}
+	}
+	objects = append(objects, proxyService)
+
+	// Pod endpoints of proxy service are not published offline, webhook service selects the same pods.
+	endpointSlices, err := p.getEndpointSlices(ctx, client.ObjectKeyFromObject(proxyService))
+	if err != nil {
+		return nil, err
+	}
+	if len(endpointSlices) == 0 {
+		if endpointSlices, err = p.getEndpointSlices(ctx, serviceKey); err != nil {
+			return nil, err
+		}
+	}
+	var webhookEndpoints []discoveryv1.Endpoint
+	for _, endpointSlice := range endpointSlices {
+		webhookEndpoints = append(webhookEndpoints, endpointSlice.Endpoints...)
+	}
+
+	allowedNodes, err := p.getAllowedNodes(ctx, settings.nodeSelector)
+	if err != nil {
+		return nil, err
+	}
+	generated, _ := p.generateProxyEndpointSlice(log, getProxyName(proxyService.Name, serviceNameHashLen),
+		webhookEndpoints, proxyService, allowedNodes)
+	proxyEndpointSlice := &discoveryv1.EndpointSlice{
+		ObjectMeta:  generated.ObjectMeta,
+		AddressType: generated.AddressType,
+	}
+	if err := p.buildProxyEndpointSlice(proxyEndpointSlice, generated, proxyService, serviceOrigin.Name); err != nil {
+		return nil, err
+	}
+	objects = append(objects, proxyEndpointSlice)
+
+	if !settings.restricted {
+		return objects, nil
+	}
+
+	target, err := p.getPolicyTarget(ctx, serviceOrigin, proxyService, settings)
+	if err != nil {
+		return nil, fmt.Errorf("unable to render network policy of service %s, %w", serviceKey, err)
+	}
+	policyName := getProxyName(serviceOrigin.Name, serviceNameHashLen)
+	switch p.cfg().Proxy.PolicyBackend {
+	case config.PolicyBackendCalico:
+		networkSet := new(unstructured.Unstructured)
+		networkSet.SetGroupVersionKind(calicoGlobalNetworkSetGVK)
+		networkSet.SetName(controlPlaneNetworkSet)
+		if err := p.buildGlobalNetworkSet(networkSet); err != nil {
+			return nil, err
+		}
+
+		networkPolicy := new(unstructured.Unstructured)
+		networkPolicy.SetGroupVersionKind(calicoNetworkPolicyGVK)
+		networkPolicy.SetName(policyName)
+		networkPolicy.SetNamespace(serviceOrigin.Namespace)
+		if err := p.buildCalicoNetworkPolicy(networkPolicy, serviceOrigin, target); err != nil {
+			return nil, err
+		}
+		objects = append(objects, networkPolicy, networkSet)
+	default:
+		networkPolicy := &networkingv1.NetworkPolicy{
+			ObjectMeta: metav1.ObjectMeta{Name: policyName, Namespace: serviceOrigin.Namespace},
+		}
+		if err := p.buildKubernetesNetworkPolicy(networkPolicy, serviceOrigin, target); err != nil {
+			return nil, fmt.Errorf("unable to render network policy of service %s, %w", serviceKey, err)
+		}
+		objects = append(objects, networkPolicy)
+	}
+
+	return objects, nil
+}
+
+// renderServicePatch returns strategic merge patch, which removes selector of webhook service
+// and keeps it in utils.AnnotationStashedSelector.
+func renderServicePatch(serviceOrigin *v1.Service) (*unstructured.Unstructured, error) {
+	stashed := serviceOrigin.DeepCopy()
+	stashed.Spec.Selector = originalSelector(serviceOrigin)
+	if len(stashed.Spec.Selector) == 0 {
+		return nil, fmt.Errorf("service %s/%s, %w", serviceOrigin.Namespace, serviceOrigin.Name, ErrSelectorUnknown)
+	}
+	if err := stashSelector(stashed); err != nil {
+		return nil, err
+	}
+
+	return &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "v1",
+		"kind":       "Service",
+		"metadata": map[string]interface{}{
+			"name":      serviceOrigin.Name,
+			"namespace": serviceOrigin.Namespace,
+			"annotations": map[string]interface{}{
+				utils.AnnotationStashedSelector: stashed.Annotations[utils.AnnotationStashedSelector],
+			},
+		},
+		"spec": map[string]interface{}{
+			"selector": nil,
+		},
+	}}, nil
+}
@@ -0,0 +1,282 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/CharlieR-o-o-t/eks-webhook-proxy/api/v1alpha1"
+	"github.com/CharlieR-o-o-t/eks-webhook-proxy/pkg/metrics"
+	"github.com/CharlieR-o-o-t/eks-webhook-proxy/pkg/utils"
+	v1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// Restore actions.
+const (
+	RestoreActionSelectorRestored = "SelectorRestored"
+	RestoreActionStashRemoved     = "StashRemoved"
+	RestoreActionDeleted          = "Deleted"
+	// RestoreActionKept means proxy objects are kept, pod endpoints of restored service are not ready.
+	RestoreActionKept = "Kept"
+)
+
+// RestoreAction is a change done by restore.
+type RestoreAction struct {
+	Action string `json:"action"`
+	Kind   string `json:"kind"`
+	Object string `json:"object"`
+}
+
+// ListTouchedServices returns webhook services changed by the controller: services with proxy service
+// or with stashed selector. Empty namespace means all namespaces.
+func (p *Proxy) ListTouchedServices(ctx context.Context, namespace string) ([]types.NamespacedName, error) {
+	touched := make(map[types.NamespacedName]struct{})
+
+	var proxyServices = new(v1.ServiceList)
+	if err := p.client.List(ctx, proxyServices,
+		client.InNamespace(namespace),
+		client.MatchingLabels{utils.LabelManagedBy: utils.ControllerName},
+	); err != nil {
+		return nil, fmt.Errorf("unable to list proxy services, %w", err)
+	}
+	for _, proxyService := range proxyServices.Items {
+		if originName, ok := proxyService.Labels[utils.LabelServiceProxyOf]; ok {
+			touched[types.NamespacedName{Namespace: proxyService.Namespace, Name: originName}] = struct{}{}
+		}
+	}
+
+	var services = new(v1.ServiceList)
+	if err := p.client.List(ctx, services, client.InNamespace(namespace)); err != nil {
+		return nil, fmt.Errorf("unable to list services, %w", err)
+	}
+	for _, service := range services.Items {
+		if _, ok := service.Annotations[utils.AnnotationStashedSelector]; ok {
+			touched[types.NamespacedName{Namespace: service.Namespace, Name: service.Name}] = struct{}{}
+		}
+	}
+
+	keys := make([]types.NamespacedName, 0, len(touched))
+	for key := range touched {
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+// RestoreSelector puts stashed selector of webhook service back and removes the stash annotation.
+// Proxy objects are kept, the proxy endpoint slice serves the service until the endpointslice controller
+// publishes pod endpoints again, see PodEndpoints and DeleteProxyObjects.
+// With dryRun, changes are sent with dryRun=All and are not persisted.
+func (p *Proxy) RestoreSelector(ctx context.Context, serviceKey types.NamespacedName, dryRun bool) ([]RestoreAction, error) {
+	patchOpts := []client.PatchOption{client.FieldOwner(FieldManager)}
+	if dryRun {
+		patchOpts = append(patchOpts, client.DryRunAll)
+	}
+
+	var serviceOrigin = new(v1.Service)
+	if err := p.client.Get(ctx, serviceKey, serviceOrigin); err != nil {
+		return nil, client.IgnoreNotFound(err)
+	}
+	if _, ok := serviceOrigin.Annotations[utils.AnnotationStashedSelector]; !ok {
+		return nil, nil
+	}
+
+	original := serviceOrigin.DeepCopy()
+	action := RestoreActionStashRemoved
+	if len(serviceOrigin.Spec.Selector) == 0 {
+		selector := originalSelector(serviceOrigin)
+		if selector == nil {
+			return nil, fmt.Errorf("stashed selector of service %s is invalid, restore it manually", serviceKey)
+		}
+		serviceOrigin.Spec.Selector = selector
+		action = RestoreActionSelectorRestored
+	}
+	delete(serviceOrigin.Annotations, utils.AnnotationStashedSelector)
+
+	// Only selector and stash annotation are patched, other fields of the service are not sent.
+	patch := client.MergeFromWithOptions(original, client.MergeFromWithOptimisticLock{})
+	if err := p.client.Patch(ctx, serviceOrigin, patch, patchOpts...); err != nil {
+		return nil, fmt.Errorf("unable to restore selector of service %s, %w", serviceKey, err)
+	}
+	if action == RestoreActionSelectorRestored && !dryRun {
+		metrics.SelectorOperations.WithLabelValues(metrics.SelectorRestore).Inc()
+	}
+	return []RestoreAction{{Action: action, Kind: "Service", Object: serviceKey.String()}}, nil
+}
+
+// DeleteProxyObjects deletes proxy service, proxy endpoint slice, network policies and WebhookProxy
+// of webhook service. Pod endpoints of the service must be ready, otherwise the service has no endpoints.
+// With dryRun, changes are sent with dryRun=All and are not persisted.
+func (p *Proxy) DeleteProxyObjects(ctx context.Context, serviceKey types.NamespacedName, dryRun bool) ([]RestoreAction, error) {
+	var actions []RestoreAction
+	var deleteOpts []client.DeleteOption
+	if dryRun {
+		deleteOpts = append(deleteOpts, client.DryRunAll)
+	}
+
+	proxyName := getProxyName(serviceKey.Name, serviceNameHashLen)
+
+	calicoPolicy := new(unstructured.Unstructured)
+	calicoPolicy.SetGroupVersionKind(calicoNetworkPolicyGVK)
+
+	// Endpoint slice is removed with proxy service by garbage collector, it is deleted explicitly
+	// for the dry-run report and for slices left by removed owner references.
+	objects := []struct {
+		kind string
+		obj  client.Object
+		name string
+	}{
+		{kind: "EndpointSlice", obj: new(discoveryv1.EndpointSlice), name: ProxyEndpointSliceName(serviceKey)},
+		{kind: "Service", obj: new(v1.Service), name: proxyName},
+		{kind: "NetworkPolicy", obj: new(networkingv1.NetworkPolicy), name: proxyName},
+		{kind: calicoNetworkPolicyGVK.Kind + "." + calicoNetworkPolicyGVK.Group, obj: calicoPolicy, name: proxyName},
+		{kind: "WebhookProxy", obj: new(v1alpha1.WebhookProxy), name: serviceKey.Name},
+	}
+	for _, object := range objects {
+		key := types.NamespacedName{Namespace: serviceKey.Namespace, Name: object.name}
+		deleted, err := p.deleteManaged(ctx, key, object.obj, deleteOpts...)
+		if err != nil {
+			return nil, fmt.Errorf("unable to delete %s %s, %w", object.kind, key, err)
+		}
+		if deleted {
+			actions = append(actions, RestoreAction{Action: RestoreActionDeleted, Kind: object.kind, Object: key.String()})
+		}
+	}
+
+	return actions, nil
+}
+
+// ProxyEndpointSliceName returns name of the proxy endpoint slice of webhook service.
+func ProxyEndpointSliceName(serviceKey types.NamespacedName) string {
+	return getProxyName(getProxyName(serviceKey.Name, serviceNameHashLen), serviceNameHashLen)
+}
+
+// ReleaseWebhookService reverts proxy of webhook service which is no longer called by selected webhooks.
+// Selector is restored first, proxy objects are deleted once pod endpoints of the service are ready,
+// ErrPodEndpointsNotReady is returned until then.
+func (p *Proxy) ReleaseWebhookService(ctx context.Context, serviceKey types.NamespacedName) error {
+	if p.IsExcluded(serviceKey.Namespace) {
+		return nil
+	}
+
+	var serviceOrigin = new(v1.Service)
+	if err := p.client.Get(ctx, serviceKey, serviceOrigin); err != nil {
+		return client.IgnoreNotFound(err)
+	}
+
+	if _, ok := serviceOrigin.Annotations[utils.AnnotationStashedSelector]; ok {
+		actions, err := p.RestoreSelector(ctx, serviceKey, false)
+		if err != nil {
+			return err
+		}
+		if SelectorRestored(actions) {
+			p.recordEvent(ctx, serviceOrigin, v1.EventTypeNormal, EventReasonSelectorRestored,
+				"selector of service %s is restored, it is not called by selected webhooks", serviceKey)
+		}
+	}
+
+	var serviceProxy = new(v1.Service)
+	proxyKey := types.NamespacedName{Namespace: serviceKey.Namespace, Name: getProxyName(serviceKey.Name, serviceNameHashLen)}
+	if err := p.client.Get(ctx, proxyKey, serviceProxy); err != nil {
+		return client.IgnoreNotFound(err)
+	}
+	if serviceProxy.Labels[utils.LabelManagedBy] != utils.ControllerName {
+		return nil
+	}
+
+	ready, err := p.PodEndpoints(ctx, serviceKey)
+	if err != nil {
+		return err
+	}
+	if ready == 0 {
+		return ErrPodEndpointsNotReady
+	}
+
+	actions, err := p.DeleteProxyObjects(ctx, serviceKey, false)
+	if err != nil {
+		return err
+	}
+	if len(actions) > 0 {
+		p.recordEvent(ctx, serviceOrigin, v1.EventTypeNormal, EventReasonProxyReleased,
+			"proxy of service %s is deleted, it is not called by selected webhooks", serviceKey)
+	}
+	return nil
+}
+
+// SelectorRestored tells if restore actions put the selector of a service back.
+func SelectorRestored(actions []RestoreAction) bool {
+	for _, action := range actions {
+		if action.Action == RestoreActionSelectorRestored {
+			return true
+		}
+	}
+	return false
+}
+
+// RestoreClusterObjects deletes cluster scoped objects shared by all webhook services.
+func (p *Proxy) RestoreClusterObjects(ctx context.Context, dryRun bool) ([]RestoreAction, error) {
+	var deleteOpts []client.DeleteOption
+	if dryRun {
+		deleteOpts = append(deleteOpts, client.DryRunAll)
+	}
+
+	networkSet := new(unstructured.Unstructured)
+	networkSet.SetGroupVersionKind(calicoGlobalNetworkSetGVK)
+	key := types.NamespacedName{Name: controlPlaneNetworkSet}
+	deleted, err := p.deleteManaged(ctx, key, networkSet, deleteOpts...)
+	if err != nil {
+		return nil, fmt.Errorf("unable to delete global network set %s, %w", controlPlaneNetworkSet, err)
+	}
+	if !deleted {
+		return nil, nil
+	}
+	return []RestoreAction{{
+		Action: RestoreActionDeleted,
+		Kind:   calicoGlobalNetworkSetGVK.Kind + "." + calicoGlobalNetworkSetGVK.Group,
+		Object: controlPlaneNetworkSet,
+	}}, nil
+}
+
+// deleteManaged deletes object when it exists and is managed by the controller.
+// Missing objects and kinds which are not served are skipped.
+func (p *Proxy) deleteManaged(ctx context.Context, key types.NamespacedName, obj client.Object, opts ...client.DeleteOption) (bool, error) {
+	if err := p.client.Get(ctx, key, obj); err != nil {
+		if apierrors.IsNotFound(err) || meta.IsNoMatchError(err) {
+			return false, nil
+		}
+		return false, err
+	}
+
+	labels := obj.GetLabels()
+	if labels[utils.LabelManagedBy] != utils.ControllerName && labels[utils.LabelEdpointSliceManagedBy] != utils.ControllerName {
+		return false, nil
+	}
+
+	if err := p.client.Delete(ctx, obj, opts...); err != nil {
+		return false, client.IgnoreNotFound(err)
+	}
+	return true, nil
+}
+
+// PodEndpoints returns number of ready pod endpoints published for the service by endpointslice controller.
+func (p *Proxy) PodEndpoints(ctx context.Context, serviceKey types.NamespacedName) (int, error) {
+	endpointSlices, err := p.getEndpointSlices(ctx, serviceKey)
+	if err != nil {
+		return 0, err
+	}
+
+	var ready int
+	for _, endpointSlice := range endpointSlices {
+		for _, endpoint := range endpointSlice.Endpoints {
+			if endpoint.Conditions.Ready == nil || *endpoint.Conditions.Ready {
+				ready++
+			}
+		}
+	}
+	return ready, nil
+}
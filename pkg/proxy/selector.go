@@ -0,0 +1,41 @@
+package proxy
+
+import (
+	"encoding/json"
+
+	"github.com/CharlieR-o-o-t/eks-webhook-proxy/pkg/utils"
+	v1 "k8s.io/api/core/v1"
+)
+
+// originalSelector returns webhook service selector,
+// if it is already removed, the selector is taken from stash annotation.
+func originalSelector(serviceOrigin *v1.Service) map[string]string {
+	if len(serviceOrigin.Spec.Selector) > 0 {
+		return serviceOrigin.Spec.Selector
+	}
+
+	stashed, ok := serviceOrigin.Annotations[utils.AnnotationStashedSelector]
+	if !ok {
+		return nil
+	}
+
+	var selector map[string]string
+	if err := json.Unmarshal([]byte(stashed), &selector); err != nil {
+		return nil
+	}
+	return selector
+}
+
+// stashSelector persists service selector into annotation, so it survives selector removal.
+func stashSelector(serviceOrigin *v1.Service) error {
+	stashed, err := json.Marshal(serviceOrigin.Spec.Selector)
+	if err != nil {
+		return err
+	}
+
+	if serviceOrigin.Annotations == nil {
+		serviceOrigin.Annotations = make(map[string]string)
+	}
+	serviceOrigin.Annotations[utils.AnnotationStashedSelector] = string(stashed)
+	return nil
+}
@@ -2,19 +2,26 @@ package proxy
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"slices"
+	"sort"
 
-	"sigs.k8s.io/controller-runtime/pkg/client"
-	"github.com/go-logr/logr"
-	v1 "k8s.io/api/core/v1"
+	"github.com/CharlieR-o-o-t/eks-webhook-proxy/config"
+	"github.com/CharlieR-o-o-t/eks-webhook-proxy/pkg/metrics"
+	"github.com/CharlieR-o-o-t/eks-webhook-proxy/pkg/tracing"
 	"github.com/CharlieR-o-o-t/eks-webhook-proxy/pkg/utils"
+	"github.com/go-logr/logr"
+	"go.opentelemetry.io/otel/attribute"
 	admissionv1 "k8s.io/api/admissionregistration/v1"
-	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	v1 "k8s.io/api/core/v1"
 	networkingv1 "k8s.io/api/networking/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
-	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
 )
 
@@ -27,18 +34,36 @@ var (
 	// ErrServiceNotFound this error should not be reconciled.
 	// in case service specified in webhook is missing, it will be just skipped by kube-api.
 	ErrServiceNotFound = errors.New("service not found")
+	// ErrServiceNotProxied means that service should work without proxy
+	// (not ClusterIP or namespace is excluded), it should not be reconciled.
+	ErrServiceNotProxied = errors.New("service is not proxied")
+	// ErrPodEndpointsNotReady means that selector of released service is restored, but its pod endpoints
+	// are not ready yet, proxy objects are kept until they are.
+	ErrPodEndpointsNotReady = errors.New("pod endpoints are not ready")
+	// ErrServicesFailed means that some services were not ensured in a pass over all webhook services.
+	ErrServicesFailed = errors.New("webhook services are not ensured")
+
+	// errNetworkPolicy is reported with EventReasonNetworkPolicyFailed instead of EventReasonProxyFailed.
+	errNetworkPolicy = errors.New("failed to ensure network policy")
 )
 
 // EnsureServiceProxy takes a ClusterIP Service and creates (or ensures the existence of)
 // a corresponding Service of type NodePort.
 // This allows publishing the webhook Service to the routable machine network
 // rather than the pod CIDR.
-func (p *Proxy) EnsureServiceProxy(ctx context.Context, serviceRef *admissionv1.ServiceReference) (*v1.Service, error) {
-	serviceNetRestriction := p.config.Proxy.Restricted
+func (p *Proxy) EnsureServiceProxy(ctx context.Context, serviceRef *admissionv1.ServiceReference) (_ *v1.Service, err error) {
 	serviceKey := types.NamespacedName{Namespace: serviceRef.Namespace, Name: serviceRef.Name}
 
+	ctx, span := tracing.Start(ctx, "EnsureServiceProxy", tracing.Service(serviceKey))
+	defer func() { endSpan(span, err) }()
+
 	log := p.log.WithValues("service", serviceKey)
 
+	if p.IsExcluded(serviceKey.Namespace) {
+		log.V(5).Info("namespace is excluded, skipping")
+		return nil, ErrServiceNotProxied
+	}
+
 	var serviceOrigin = new(v1.Service)
 	if err := p.client.Get(ctx, serviceKey, serviceOrigin); err != nil {
 		if apierrors.IsNotFound(err) {
@@ -50,30 +75,83 @@ func (p *Proxy) EnsureServiceProxy(ctx context.Context, serviceRef *admissionv1.
 
 	if serviceOrigin.Spec.Type != v1.ServiceTypeClusterIP {
 		log.V(5).Info("service is not ClusterIP, should for without proxy, skipping")
-		return nil, nil
+		return nil, ErrServiceNotProxied
 	}
 
-	if val, ok := serviceOrigin.Labels[utils.LabelServiceProxyIgnoreRestriction]; ok {
-		serviceNetRestriction = val != "true"
-		log = log.WithValues("restricted", val)
-	}
+	settings := p.resolveSettings(ctx, serviceOrigin)
+	log = log.WithValues("restricted", settings.restricted)
+	span.SetAttributes(attribute.Bool("webhookproxy.restricted", settings.restricted))
 
-	serviceProxy, err := p.ensureProxyService(ctx, serviceOrigin, serviceNetRestriction, log)
+	serviceProxy, err := p.ensureProxyService(ctx, serviceOrigin, settings, log)
 	if err != nil {
 		log.Error(err, "failed to ensure proxy service")
 		return nil, err
 	}
 
-	if serviceNetRestriction {
-		if err := p.ensureNetworkPolicy(ctx, serviceOrigin, log); err != nil {
+	if settings.restricted {
+		// Cutover is not done without network policy in restricted mode.
+		if err := p.ensureNetworkPolicy(ctx, serviceOrigin, serviceProxy, settings, log); err != nil {
 			log.Error(err, "failed to ensure network policy for service")
+			return nil, fmt.Errorf("%w, %w", errNetworkPolicy, err)
 		}
 	}
 
+	if err := p.deleteStaleNetworkPolicies(ctx, serviceOrigin, settings, log); err != nil {
+		log.Error(err, "failed to delete stale network policies of service")
+		return nil, err
+	}
+
 	return serviceProxy, nil
 }
 
-func (p *Proxy) ensureProxyService(ctx context.Context, serviceOrigin *v1.Service, netRestriction bool, logger logr.Logger) (*v1.Service, error) {
+// RefreshNetworkPolicies re-ensures network policies of all restricted proxied services,
+// policies of services which are not restricted any more are deleted.
+// Used when allowed source CIDRs or node addresses are changed.
+func (p *Proxy) RefreshNetworkPolicies(ctx context.Context) error {
+	var proxyServices = new(v1.ServiceList)
+	if err := p.client.List(ctx, proxyServices,
+		client.MatchingLabels{utils.LabelManagedBy: utils.ControllerName},
+	); err != nil {
+		return fmt.Errorf("unable to list proxy services, %w", err)
+	}
+
+	var errs []error
+	for _, proxyService := range proxyServices.Items {
+		originName, ok := proxyService.Labels[utils.LabelServiceProxyOf]
+		if !ok {
+			continue
+		}
+
+		serviceKey := types.NamespacedName{Namespace: proxyService.Namespace, Name: originName}
+		log := p.log.WithValues("service", serviceKey)
+
+		var serviceOrigin = new(v1.Service)
+		if err := p.client.Get(ctx, serviceKey, serviceOrigin); err != nil {
+			if !apierrors.IsNotFound(err) {
+				errs = append(errs, err)
+			}
+			continue
+		}
+
+		settings := p.resolveSettings(ctx, serviceOrigin)
+		if settings.restricted {
+			if err := p.ensureNetworkPolicy(ctx, serviceOrigin, &proxyService, settings, log); err != nil {
+				errs = append(errs, fmt.Errorf("unable to refresh network policy for service %s, %w", serviceKey, err))
+				continue
+			}
+		}
+
+		if err := p.deleteStaleNetworkPolicies(ctx, serviceOrigin, settings, log); err != nil {
+			errs = append(errs, fmt.Errorf("unable to delete stale network policies for service %s, %w", serviceKey, err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+func (p *Proxy) ensureProxyService(ctx context.Context, serviceOrigin *v1.Service, settings *serviceSettings, logger logr.Logger) (_ *v1.Service, err error) {
+	ctx, span := tracing.Start(ctx, "ensureProxyService")
+	defer func() { tracing.End(span, err) }()
 
 	serviceProxyObj := &v1.Service{
 		ObjectMeta: metav1.ObjectMeta{
@@ -85,52 +163,80 @@ func (p *Proxy) ensureProxyService(ctx context.Context, serviceOrigin *v1.Servic
 		},
 	}
 
-	op, err := controllerutil.CreateOrUpdate(ctx, p.client,
-		serviceProxyObj,
-		func() error {
-			serviceProxyObj.Labels = map[string]string{
-				utils.LabelManagedBy:      utils.ControllerName,
-				utils.LabelServiceProxyOf: serviceOrigin.Name,
-			}
-
-			// All ports from origin service will be proxied with nodePort service.
-			serviceProxyObj.Spec.Ports = serviceOrigin.Spec.Ports
-
-			if serviceOrigin.Spec.Selector != nil {
-				serviceProxyObj.Spec.Selector = serviceOrigin.Spec.Selector
-			}
-
-			// Publish nodePort only on nodes webhook pod are running.
-			if netRestriction {
-				serviceProxyObj.Spec.ExternalTrafficPolicy = v1.ServiceExternalTrafficPolicyTypeLocal
-			} else {
-				serviceProxyObj.Spec.ExternalTrafficPolicy = v1.ServiceExternalTrafficPolicyTypeCluster
-			}
-
-			if instance, ok := serviceOrigin.Labels[utils.LabelAppInstance]; ok {
-				serviceProxyObj.Labels[utils.LabelPartOf] = instance
-			}
-
-			return controllerutil.SetControllerReference(serviceOrigin, serviceProxyObj, p.client.Scheme())
-		},
-	)
+	if err := p.buildProxyService(serviceProxyObj, serviceOrigin, settings); err != nil {
+		return nil, err
+	}
+	// Node ports are not applied, they are allocated on create and kept by the API server.
+	op, err := p.apply(ctx, serviceProxyObj, true, serviceOrigin)
 	if err != nil {
 		logger.Error(err, "failed to ensure proxy service")
 		return nil, err
 	}
 
+	if op == controllerutil.OperationResultCreated || op == OperationResultPlanned {
+		p.recordEvent(ctx, serviceOrigin, v1.EventTypeNormal, EventReasonProxyServiceCreated,
+			"created NodePort proxy service %s", serviceProxyObj.Name)
+	}
+
+	span.SetAttributes(tracing.Operation(op))
 	logger.V(4).Info("proxy service has been ensured", "operation", op)
 	return serviceProxyObj, nil
 }
 
+// buildProxyService sets NodePort proxy service fields derived from webhook service, node ports are kept.
+func (p *Proxy) buildProxyService(serviceProxyObj, serviceOrigin *v1.Service, settings *serviceSettings) error {
+	serviceProxyObj.Labels = map[string]string{
+		utils.LabelManagedBy:      utils.ControllerName,
+		utils.LabelServiceProxyOf: serviceOrigin.Name,
+	}
+
+	// Ports from origin service will be proxied with nodePort service,
+	// unless limited by annotation.
+	serviceProxyObj.Spec.Ports = settings.filterPorts(serviceOrigin.Spec.Ports)
+
+	// Selector is taken from stash, when it is already removed from origin service.
+	if selector := originalSelector(serviceOrigin); selector != nil {
+		serviceProxyObj.Spec.Selector = selector
+	}
+
+	// Publish nodePort only on nodes webhook pod are running, unless Cluster policy is configured for restricted webhooks.
+	if settings.restricted {
+		serviceProxyObj.Spec.ExternalTrafficPolicy = v1.ServiceExternalTrafficPolicy(p.cfg().Proxy.RestrictedTrafficPolicy)
+	} else {
+		serviceProxyObj.Spec.ExternalTrafficPolicy = v1.ServiceExternalTrafficPolicyTypeCluster
+	}
+
+	if instance, ok := serviceOrigin.Labels[utils.LabelAppInstance]; ok {
+		serviceProxyObj.Labels[utils.LabelPartOf] = instance
+	}
+
+	return controllerutil.SetControllerReference(serviceOrigin, serviceProxyObj, p.client.Scheme())
+}
+
 // UnbindPodEndpoints will unbind pod endpoints from webhook service.
 // after that only nodePort proxy will handle service traffic.
-func (p *Proxy) UnbindPodEndpoints(ctx context.Context, serviceRef *admissionv1.ServiceReference) error {
+func (p *Proxy) UnbindPodEndpoints(ctx context.Context, serviceRef *admissionv1.ServiceReference) (err error) {
+	ctx, span := tracing.Start(ctx, "UnbindPodEndpoints",
+		tracing.Service(types.NamespacedName{Namespace: serviceRef.Namespace, Name: serviceRef.Name}),
+	)
+	defer func() { tracing.End(span, err) }()
+
 	var serviceOrigin = new(v1.Service)
 	if err := p.client.Get(ctx, types.NamespacedName{Namespace: serviceRef.Namespace, Name: serviceRef.Name}, serviceOrigin); err != nil {
 		return client.IgnoreNotFound(err)
 	}
 
+	// Selector and pod endpoints are kept, webhook traffic must not depend on proxy objects which are not written.
+	if p.cfg().Proxy.ObserveOnly {
+		if len(serviceOrigin.Spec.Selector) > 0 {
+			p.log.Info("observe-only mode, skipping selector removal and pod endpoints cleanup",
+				"service", types.NamespacedName{Namespace: serviceOrigin.Namespace, Name: serviceOrigin.Name})
+			p.recordEvent(ctx, serviceOrigin, v1.EventTypeNormal, EventReasonSelectorStripPlanned,
+				"selector would be removed, pod endpoints would be unbound and traffic would go through proxy")
+		}
+		return nil
+	}
+
 	if err := p.removeSelector(ctx, serviceOrigin); err != nil {
 		return fmt.Errorf("unable to remove selector from service, %w", err)
 	}
@@ -145,9 +251,65 @@ func (p *Proxy) UnbindPodEndpoints(ctx context.Context, serviceRef *admissionv1.
 	return nil
 }
 
+// removeSelector stashes service selector into annotation and removes it from the service.
+// Webhook service is owned by other field managers: the annotation is applied without force,
+// the selector is removed with optimistic lock patch, apply can not remove fields of other managers.
 func (p *Proxy) removeSelector(ctx context.Context, serviceOrigin *v1.Service) error {
-	serviceOrigin.Spec.Selector = nil
-	return p.client.Update(ctx, serviceOrigin)
+	if len(serviceOrigin.Spec.Selector) == 0 {
+		return nil
+	}
+
+	// Merge patch removes the selector regardless of its managers, they may set it back on their next write.
+	if conflicts := selectorConflicts(serviceOrigin); len(conflicts) > 0 {
+		p.reportConflicts(ctx, serviceOrigin, "Service", conflicts, true, serviceOrigin)
+	}
+
+	stash := &v1.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: serviceOrigin.Name, Namespace: serviceOrigin.Namespace},
+		Spec:       v1.ServiceSpec{Selector: serviceOrigin.Spec.Selector},
+	}
+	if err := stashSelector(stash); err != nil {
+		return err
+	}
+	stash.Spec.Selector = nil
+	if _, err := p.apply(ctx, stash, false, serviceOrigin); err != nil {
+		return err
+	}
+
+	original := stash.DeepCopy()
+	stash.Spec.Selector = nil
+	if err := p.client.Patch(ctx, stash, client.MergeFromWithOptions(original, client.MergeFromWithOptimisticLock{}),
+		client.FieldOwner(FieldManager)); err != nil {
+		return err
+	}
+	*serviceOrigin = *stash
+
+	metrics.SelectorOperations.WithLabelValues(metrics.SelectorStrip).Inc()
+	p.recordEvent(ctx, serviceOrigin, v1.EventTypeNormal, EventReasonSelectorStripped,
+		"removed selector, pod endpoints are unbound and traffic goes through proxy, selector is kept in %s annotation",
+		utils.AnnotationStashedSelector)
+	return nil
+}
+
+// selectorConflicts returns other field managers of service selector, in server-side apply conflict format.
+func selectorConflicts(service *v1.Service) []string {
+	var conflicts []string
+	for _, entry := range service.ManagedFields {
+		if entry.Manager == FieldManager || entry.FieldsV1 == nil {
+			continue
+		}
+		var fields struct {
+			Spec map[string]json.RawMessage `json:"f:spec"`
+		}
+		if err := json.Unmarshal(entry.FieldsV1.Raw, &fields); err != nil {
+			continue
+		}
+		if _, ok := fields.Spec["f:selector"]; ok {
+			conflicts = append(conflicts, fmt.Sprintf("conflict with %q using %s: .spec.selector", entry.Manager, entry.APIVersion))
+		}
+	}
+	sort.Strings(conflicts)
+	return conflicts
 }
 
 func (p *Proxy) cleanPodEndpointSlices(ctx context.Context, serviceOrigin *v1.Service) error {
@@ -178,7 +340,28 @@ func (p *Proxy) cleanPodEndpoints(ctx context.Context, serviceOrigin *v1.Service
 	return nil
 }
 
-func (p *Proxy) ensureNetworkPolicy(ctx context.Context, serviceOrigin *v1.Service, logger logr.Logger) error {
+// ensureNetworkPolicy restricts webhook pods ingress with the configured policy backend.
+// Policy is built from the stashed selector, so it survives selector removal.
+func (p *Proxy) ensureNetworkPolicy(ctx context.Context, serviceOrigin, serviceProxy *v1.Service, settings *serviceSettings, logger logr.Logger) (err error) {
+	ctx, span := tracing.Start(ctx, "ensureNetworkPolicy",
+		attribute.String("webhookproxy.policy_backend", p.cfg().Proxy.PolicyBackend),
+	)
+	defer func() { tracing.End(span, err) }()
+
+	target, err := p.getPolicyTarget(ctx, serviceOrigin, serviceProxy, settings)
+	if err != nil {
+		return err
+	}
+
+	switch p.cfg().Proxy.PolicyBackend {
+	case config.PolicyBackendCalico:
+		return p.ensureCalicoNetworkPolicy(ctx, serviceOrigin, target, logger)
+	default:
+		return p.ensureKubernetesNetworkPolicy(ctx, serviceOrigin, target, logger)
+	}
+}
+
+func (p *Proxy) ensureKubernetesNetworkPolicy(ctx context.Context, serviceOrigin *v1.Service, target *policyTarget, logger logr.Logger) error {
 	networPolicy := &networkingv1.NetworkPolicy{
 		ObjectMeta: metav1.ObjectMeta{
 			Name:      getProxyName(serviceOrigin.Name, serviceNameHashLen),
@@ -186,9 +369,35 @@ func (p *Proxy) ensureNetworkPolicy(ctx context.Context, serviceOrigin *v1.Servi
 		},
 	}
 
+	if err := p.buildKubernetesNetworkPolicy(networPolicy, serviceOrigin, target); err != nil {
+		return err
+	}
+	op, err := p.apply(ctx, networPolicy, true, serviceOrigin)
+	if err != nil {
+		logger.Error(err, "failed to ensure proxy service")
+		return err
+	}
+
+	if op == controllerutil.OperationResultCreated || op == OperationResultPlanned {
+		p.recordEvent(ctx, serviceOrigin, v1.EventTypeNormal, EventReasonNetworkPolicyCreated,
+			"created network policy %s", networPolicy.Name)
+	}
+
+	tracing.SetAttributes(ctx, tracing.Operation(op))
+	logger.V(4).Info("network policy has been ensured", "operation", op)
+	return nil
+}
+
+// buildKubernetesNetworkPolicy sets networking.k8s.io/v1 NetworkPolicy allowing webhook ports from source CIDRs.
+func (p *Proxy) buildKubernetesNetworkPolicy(networPolicy *networkingv1.NetworkPolicy, serviceOrigin *v1.Service, target *policyTarget) error {
 	// Ingress rules из CIDR
-	from := make([]networkingv1.NetworkPolicyPeer, 0, len(p.config.Proxy.AllowedSrcCIDRs))
-	for _, cidr := range p.config.Proxy.AllowedSrcCIDRs {
+	sourceCIDRs := slices.Concat(target.sourceCIDRs, target.nodeCIDRs)
+	// Ingress rule without peers would allow everyone.
+	if len(sourceCIDRs) == 0 {
+		return ErrNoSourceCIDRs
+	}
+	from := make([]networkingv1.NetworkPolicyPeer, 0, len(sourceCIDRs))
+	for _, cidr := range sourceCIDRs {
 		from = append(from, networkingv1.NetworkPolicyPeer{
 			IPBlock: &networkingv1.IPBlock{
 				CIDR: cidr,
@@ -196,53 +405,32 @@ func (p *Proxy) ensureNetworkPolicy(ctx context.Context, serviceOrigin *v1.Servi
 		})
 	}
 
-	op, err := controllerutil.CreateOrUpdate(ctx, p.client,
-		networPolicy,
-		func() error {
-			networPolicy.Labels = map[string]string{
-				utils.LabelManagedBy:      utils.ControllerName,
-				utils.LabelServiceProxyOf: serviceOrigin.Name,
-			}
-
-			networPolicy.Spec.PodSelector.MatchLabels = serviceOrigin.Spec.Selector
-
-			if instance, ok := serviceOrigin.Labels[utils.LabelAppInstance]; ok {
-				networPolicy.Labels[utils.LabelPartOf] = instance
-			}
-
-			networkPolicyIngressRule := networkingv1.NetworkPolicyIngressRule{
-				From: from,
-			}
-
-			// Collecting ports from original service.
-			ports := make([]networkingv1.NetworkPolicyPort, 0, len(serviceOrigin.Spec.Ports))
-			for _, servicePort := range serviceOrigin.Spec.Ports {
-				ingressProtocol := servicePort.Protocol
-
-				ingressPort := networkingv1.NetworkPolicyPort{
-					Protocol: &ingressProtocol,
-				}
+	networPolicy.Labels = map[string]string{
+		utils.LabelManagedBy:      utils.ControllerName,
+		utils.LabelServiceProxyOf: serviceOrigin.Name,
+	}
 
-				targetPort := servicePort.TargetPort
-				if !utils.IsTargetPortSet(targetPort) {
-					targetPort = intstr.FromInt32(servicePort.Port)
-				}
-				ingressPort.Port = &targetPort
+	networPolicy.Spec.PodSelector.MatchLabels = target.selector
 
-				ports = append(ports, ingressPort)
-			}
-			networkPolicyIngressRule.Ports = ports
+	if instance, ok := serviceOrigin.Labels[utils.LabelAppInstance]; ok {
+		networPolicy.Labels[utils.LabelPartOf] = instance
+	}
 
-			networPolicy.Spec.Ingress = []networkingv1.NetworkPolicyIngressRule{networkPolicyIngressRule}
+	networkPolicyIngressRule := networkingv1.NetworkPolicyIngressRule{
+		From: from,
+	}
 
-			return controllerutil.SetControllerReference(serviceOrigin, networPolicy, p.client.Scheme())
-		},
-	)
-	if err != nil {
-		logger.Error(err, "failed to ensure proxy service")
-		return err
+	// Collecting ports from original service.
+	ports := make([]networkingv1.NetworkPolicyPort, 0, len(target.ports))
+	for _, targetPort := range target.ports {
+		ports = append(ports, networkingv1.NetworkPolicyPort{
+			Protocol: ptr.To(targetPort.protocol),
+			Port:     ptr.To(targetPort.port),
+		})
 	}
+	networkPolicyIngressRule.Ports = ports
 
-	logger.V(4).Info("network policy has been ensured", "operation", op)
-	return nil
+	networPolicy.Spec.Ingress = []networkingv1.NetworkPolicyIngressRule{networkPolicyIngressRule}
+
+	return controllerutil.SetControllerReference(serviceOrigin, networPolicy, p.client.Scheme())
 }
@@ -0,0 +1,232 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/CharlieR-o-o-t/eks-webhook-proxy/config"
+	"github.com/CharlieR-o-o-t/eks-webhook-proxy/pkg/utils"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	EventReasonInvalidAnnotation = "InvalidAnnotation"
+)
+
+// serviceSettings are proxy settings resolved for a single webhook service.
+// Precedence: service annotations, namespace annotations, global config.
+type serviceSettings struct {
+	restricted bool
+	// allowedSrcCIDRs replaces static CIDRs from config, derived CIDRs are still merged.
+	allowedSrcCIDRs []string
+	// customCIDRs is set when allowedSrcCIDRs is overridden by annotation.
+	customCIDRs bool
+	// nodeSelector limits nodes published in proxy endpoint slice, nil means all nodes.
+	nodeSelector labels.Selector
+	// ports limits proxied service ports by name or number, nil means all ports.
+	ports map[string]struct{}
+}
+
+// resolveSettings merges global config with namespace and service annotations.
+// Invalid annotation values are ignored, they are reported with warning event once per value.
+func (p *Proxy) resolveSettings(ctx context.Context, serviceOrigin *v1.Service) *serviceSettings {
+	cfg := p.cfg()
+	settings := &serviceSettings{
+		restricted:      cfg.Proxy.Restricted,
+		allowedSrcCIDRs: cfg.Proxy.AllowedSrcCIDRs,
+	}
+	// Global selector is validated with config.
+	if selector, err := labels.Parse(cfg.Proxy.NodeSelector); err == nil && !selector.Empty() {
+		settings.nodeSelector = selector
+	}
+
+	var namespace = new(v1.Namespace)
+	if err := p.client.Get(ctx, types.NamespacedName{Name: serviceOrigin.Namespace}, namespace); err != nil {
+		p.log.V(4).Info("unable to get namespace, namespace overrides are skipped", "namespace", serviceOrigin.Namespace, "err", err.Error())
+	} else {
+		p.applyAnnotations(settings, namespace, namespace.Annotations, serviceOrigin)
+	}
+
+	// Legacy label is kept for compatibility, annotation takes precedence over it.
+	if val, ok := serviceOrigin.Labels[utils.LabelServiceProxyIgnoreRestriction]; ok {
+		settings.restricted = val != "true"
+	}
+	p.applyAnnotations(settings, serviceOrigin, serviceOrigin.Annotations, serviceOrigin)
+
+	return settings
+}
+
+func (p *Proxy) applyAnnotations(settings *serviceSettings, obj client.Object, annotations map[string]string, serviceOrigin *v1.Service) {
+	invalid := make(map[string]error)
+
+	if val, ok := annotations[utils.AnnotationProxyRestricted]; ok {
+		restricted, err := strconv.ParseBool(val)
+		if err != nil {
+			invalid[utils.AnnotationProxyRestricted] = err
+		} else {
+			settings.restricted = restricted
+		}
+	}
+
+	if val, ok := annotations[utils.AnnotationProxyAllowedCIDRs]; ok {
+		cidrs, err := parseCIDRs(val)
+		if err != nil {
+			invalid[utils.AnnotationProxyAllowedCIDRs] = err
+		} else {
+			settings.allowedSrcCIDRs = cidrs
+			settings.customCIDRs = true
+		}
+	}
+
+	if val, ok := annotations[utils.AnnotationProxyNodeSelector]; ok {
+		selector, err := labels.Parse(val)
+		if err != nil {
+			invalid[utils.AnnotationProxyNodeSelector] = err
+		} else {
+			settings.nodeSelector = selector
+		}
+	}
+
+	if val, ok := annotations[utils.AnnotationProxyPorts]; ok {
+		ports, err := parsePorts(val, serviceOrigin.Spec.Ports)
+		if err != nil {
+			invalid[utils.AnnotationProxyPorts] = err
+		} else {
+			settings.ports = ports
+		}
+	}
+
+	p.warnInvalidAnnotations(obj, annotations, invalid)
+}
+
+// warnInvalidAnnotations records warning event for invalid annotation values which are not reported yet,
+// settings are resolved on every reconcile, status update and CLI command.
+func (p *Proxy) warnInvalidAnnotations(obj client.Object, annotations map[string]string, invalid map[string]error) {
+	for _, annotation := range p.warnings.changed(obj.GetUID(), annotations, invalid) {
+		p.recorder.Eventf(obj, v1.EventTypeWarning, EventReasonInvalidAnnotation,
+			"annotation %s has invalid value %q and is ignored: %v", annotation, annotations[annotation], invalid[annotation])
+	}
+}
+
+// annotationWarnings holds invalid annotation values already reported, by object.
+type annotationWarnings struct {
+	mu     sync.Mutex
+	values map[types.UID]map[string]string
+}
+
+func newAnnotationWarnings() *annotationWarnings {
+	return &annotationWarnings{values: make(map[types.UID]map[string]string)}
+}
+
+// changed remembers invalid annotations of the object and returns ones with values not reported yet.
+// Annotations which are fixed or removed are forgotten, so the same invalid value set again is reported.
+func (w *annotationWarnings) changed(uid types.UID, annotations map[string]string, invalid map[string]error) []string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	reported := w.values[uid]
+	current := make(map[string]string, len(invalid))
+	var changed []string
+	for annotation := range invalid {
+		current[annotation] = annotations[annotation]
+		if value, ok := reported[annotation]; !ok || value != annotations[annotation] {
+			changed = append(changed, annotation)
+		}
+	}
+
+	if len(current) == 0 {
+		delete(w.values, uid)
+	} else {
+		w.values[uid] = current
+	}
+	sort.Strings(changed)
+	return changed
+}
+
+// filterPorts returns service ports selected by settings.
+func (s *serviceSettings) filterPorts(servicePorts []v1.ServicePort) []v1.ServicePort {
+	if s.ports == nil {
+		return servicePorts
+	}
+
+	filtered := make([]v1.ServicePort, 0, len(servicePorts))
+	for _, servicePort := range servicePorts {
+		if portSelected(s.ports, servicePort) {
+			filtered = append(filtered, servicePort)
+		}
+	}
+	return filtered
+}
+
+func portSelected(ports map[string]struct{}, servicePort v1.ServicePort) bool {
+	for entry := range ports {
+		if portMatches(entry, servicePort) {
+			return true
+		}
+	}
+	return false
+}
+
+func portMatches(entry string, servicePort v1.ServicePort) bool {
+	return entry == strconv.Itoa(int(servicePort.Port)) ||
+		(servicePort.Name != "" && entry == servicePort.Name)
+}
+
+// parseCIDRs parses comma separated CIDR list, empty list is not allowed.
+// Entries are validated the same way as allowed CIDRs of the configuration.
+func parseCIDRs(val string) ([]string, error) {
+	var cidrs []string
+	for _, entry := range splitList(val) {
+		if err := config.ValidateCIDR(entry); err != nil {
+			return nil, err
+		}
+		cidrs = append(cidrs, entry)
+	}
+	if len(cidrs) == 0 {
+		return nil, fmt.Errorf("CIDR list is empty")
+	}
+	return cidrs, nil
+}
+
+// parsePorts parses comma separated list of service port names or numbers,
+// every entry must match a port of the service.
+func parsePorts(val string, servicePorts []v1.ServicePort) (map[string]struct{}, error) {
+	ports := make(map[string]struct{})
+	for _, entry := range splitList(val) {
+		ports[entry] = struct{}{}
+	}
+	if len(ports) == 0 {
+		return nil, fmt.Errorf("port list is empty")
+	}
+
+	for entry := range ports {
+		var found bool
+		for _, servicePort := range servicePorts {
+			if portMatches(entry, servicePort) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("service has no port %q", entry)
+		}
+	}
+	return ports, nil
+}
+
+func splitList(val string) []string {
+	var entries []string
+	for _, entry := range strings.Split(val, ",") {
+		if entry = strings.TrimSpace(entry); entry != "" {
+			entries = append(entries, entry)
+		}
+	}
+	return entries
+}
@@ -0,0 +1,140 @@
+package proxy
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/CharlieR-o-o-t/eks-webhook-proxy/config"
+	"github.com/CharlieR-o-o-t/eks-webhook-proxy/pkg/utils"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+var globalCIDRs = []string{"10.0.0.0/8"}
+
+func newSettingsProxy(namespaceAnnotations map[string]string) (*Proxy, *record.FakeRecorder) {
+	namespace := &v1.Namespace{
+		ObjectMeta: metav1.ObjectMeta{Name: "webhooks", UID: "namespace-uid", Annotations: namespaceAnnotations},
+	}
+	recorder := record.NewFakeRecorder(10)
+	cfg := &config.Config{Proxy: config.Proxy{AllowedSrcCIDRs: globalCIDRs}}
+	c := fake.NewClientBuilder().WithObjects(namespace).Build()
+	return New(c, c, recorder, config.NewStore(cfg), nil, nil), recorder
+}
+
+func newSettingsService(labels, annotations map[string]string) *v1.Service {
+	return &v1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace:   "webhooks",
+			Name:        "webhook",
+			UID:         "service-uid",
+			Labels:      labels,
+			Annotations: annotations,
+		},
+		Spec: v1.ServiceSpec{Ports: []v1.ServicePort{{Name: "https", Port: 443}}},
+	}
+}
+
+func TestResolveSettingsPrecedence(t *testing.T) {
+	ignoreRestriction := map[string]string{utils.LabelServiceProxyIgnoreRestriction: "true"}
+
+	tests := []struct {
+		name                 string
+		namespaceAnnotations map[string]string
+		labels               map[string]string
+		annotations          map[string]string
+		wantRestricted       bool
+		wantCIDRs            []string
+	}{
+		{
+			name:      "global",
+			wantCIDRs: globalCIDRs,
+		},
+		{
+			name: "namespace annotation over global",
+			namespaceAnnotations: map[string]string{
+				utils.AnnotationProxyRestricted:   "true",
+				utils.AnnotationProxyAllowedCIDRs: "172.16.0.0/12",
+			},
+			wantRestricted: true,
+			wantCIDRs:      []string{"172.16.0.0/12"},
+		},
+		{
+			name:                 "service label over namespace annotation",
+			namespaceAnnotations: map[string]string{utils.AnnotationProxyRestricted: "true"},
+			labels:               ignoreRestriction,
+			wantCIDRs:            globalCIDRs,
+		},
+		{
+			name:                 "service annotation over service label",
+			namespaceAnnotations: map[string]string{utils.AnnotationProxyAllowedCIDRs: "172.16.0.0/12"},
+			labels:               ignoreRestriction,
+			annotations: map[string]string{
+				utils.AnnotationProxyRestricted:   "true",
+				utils.AnnotationProxyAllowedCIDRs: "192.168.0.0/16, 10.1.0.0/16",
+			},
+			wantRestricted: true,
+			wantCIDRs:      []string{"192.168.0.0/16", "10.1.0.0/16"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, _ := newSettingsProxy(tt.namespaceAnnotations)
+			settings := p.resolveSettings(context.Background(), newSettingsService(tt.labels, tt.annotations))
+
+			if settings.restricted != tt.wantRestricted {
+				t.Errorf("restricted = %v, want %v", settings.restricted, tt.wantRestricted)
+			}
+			if !reflect.DeepEqual(settings.allowedSrcCIDRs, tt.wantCIDRs) {
+				t.Errorf("allowedSrcCIDRs = %v, want %v", settings.allowedSrcCIDRs, tt.wantCIDRs)
+			}
+		})
+	}
+}
+
+func TestResolveSettingsInvalidCIDRs(t *testing.T) {
+	tests := []struct {
+		name  string
+		value string
+	}{
+		{name: "host bits", value: "10.0.0.5/8"},
+		{name: "IPv6", value: "fd00::/8"},
+		{name: "not a CIDR", value: "10.0.0.1"},
+		{name: "empty", value: " , "},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, recorder := newSettingsProxy(map[string]string{utils.AnnotationProxyAllowedCIDRs: "172.16.0.0/12"})
+			service := newSettingsService(nil, map[string]string{utils.AnnotationProxyAllowedCIDRs: tt.value})
+
+			// Invalid value is ignored, the namespace annotation applies.
+			settings := p.resolveSettings(context.Background(), service)
+			if want := []string{"172.16.0.0/12"}; !reflect.DeepEqual(settings.allowedSrcCIDRs, want) {
+				t.Errorf("allowedSrcCIDRs = %v, want %v", settings.allowedSrcCIDRs, want)
+			}
+
+			select {
+			case event := <-recorder.Events:
+				if !strings.Contains(event, EventReasonInvalidAnnotation) || !strings.Contains(event, utils.AnnotationProxyAllowedCIDRs) {
+					t.Errorf("event = %q, want %s of %s", event, EventReasonInvalidAnnotation, utils.AnnotationProxyAllowedCIDRs)
+				}
+			default:
+				t.Fatal("invalid annotation is not reported")
+			}
+
+			// Same invalid value is reported once.
+			p.resolveSettings(context.Background(), service)
+			select {
+			case event := <-recorder.Events:
+				t.Errorf("invalid annotation is reported again: %q", event)
+			default:
+			}
+		})
+	}
+}
@@ -0,0 +1,223 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/CharlieR-o-o-t/eks-webhook-proxy/api/v1alpha1"
+	"github.com/CharlieR-o-o-t/eks-webhook-proxy/config"
+	"github.com/CharlieR-o-o-t/eks-webhook-proxy/pkg/utils"
+	v1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+)
+
+// BuildStatus observes proxy objects of webhook service and builds WebhookProxy status.
+func (p *Proxy) BuildStatus(ctx context.Context, serviceOrigin *v1.Service, webhooks []v1alpha1.WebhookReference) (*v1alpha1.WebhookProxyStatus, error) {
+	settings := p.resolveSettings(ctx, serviceOrigin)
+
+	status := &v1alpha1.WebhookProxyStatus{
+		Webhooks:   webhooks,
+		Restricted: settings.restricted,
+		Phase:      v1alpha1.PhasePending,
+	}
+
+	proxyName := getProxyName(serviceOrigin.Name, serviceNameHashLen)
+
+	var proxyService = new(v1.Service)
+	if err := p.client.Get(ctx, types.NamespacedName{Namespace: serviceOrigin.Namespace, Name: proxyName}, proxyService); err != nil {
+		if !apierrors.IsNotFound(err) {
+			return nil, err
+		}
+		proxyService = nil
+	}
+
+	if proxyService != nil {
+		status.ProxyService = proxyService.Name
+		for _, port := range proxyService.Spec.Ports {
+			status.NodePorts = append(status.NodePorts, v1alpha1.NodePort{
+				Name:     port.Name,
+				Protocol: port.Protocol,
+				Port:     port.Port,
+				NodePort: port.NodePort,
+			})
+		}
+
+		var proxyEndpointSlice = new(discoveryv1.EndpointSlice)
+		proxyEndpointSliceKey := types.NamespacedName{
+			Namespace: proxyService.Namespace,
+			Name:      getProxyName(proxyService.Name, serviceNameHashLen),
+		}
+		if err := p.client.Get(ctx, proxyEndpointSliceKey, proxyEndpointSlice); err != nil {
+			if !apierrors.IsNotFound(err) {
+				return nil, err
+			}
+		}
+		for _, endpoint := range proxyEndpointSlice.Endpoints {
+			nodeEndpoint := v1alpha1.NodeEndpoint{
+				NodeName: ptr.Deref(endpoint.NodeName, ""),
+				Ready:    endpoint.Conditions.Ready == nil || *endpoint.Conditions.Ready,
+			}
+			if len(endpoint.Addresses) > 0 {
+				nodeEndpoint.Address = endpoint.Addresses[0]
+			}
+			if nodeEndpoint.Ready {
+				status.ReadyEndpoints++
+			}
+			status.TotalEndpoints++
+			status.Endpoints = append(status.Endpoints, nodeEndpoint)
+		}
+	}
+
+	if settings.restricted {
+		policy, err := p.getPolicyReference(ctx, serviceOrigin)
+		if err != nil {
+			return nil, err
+		}
+		status.NetworkPolicy = policy
+	}
+
+	selectorStashed := len(serviceOrigin.Spec.Selector) == 0 &&
+		serviceOrigin.Annotations[utils.AnnotationStashedSelector] != ""
+
+	switch {
+	case status.ReadyEndpoints > 0 && selectorStashed:
+		status.Phase = v1alpha1.PhaseCutOver
+	case status.ReadyEndpoints > 0:
+		status.Phase = v1alpha1.PhaseProxyReady
+	}
+
+	setCondition(status, v1alpha1.ConditionReady, status.ReadyEndpoints > 0,
+		"EndpointsReady", fmt.Sprintf("%d of %d node endpoints are ready", status.ReadyEndpoints, status.TotalEndpoints))
+
+	serviceKey := types.NamespacedName{Namespace: serviceOrigin.Namespace, Name: serviceOrigin.Name}
+	failure, failed := p.failures.get(serviceKey)
+
+	switch {
+	case failed:
+		setCondition(status, v1alpha1.ConditionDegraded, true, "ProxyFailed", failure)
+	case status.TotalEndpoints > status.ReadyEndpoints:
+		setCondition(status, v1alpha1.ConditionDegraded, true,
+			"EndpointsNotReady", fmt.Sprintf("%d node endpoints are not ready", status.TotalEndpoints-status.ReadyEndpoints))
+	case settings.restricted && status.NetworkPolicy == nil:
+		setCondition(status, v1alpha1.ConditionDegraded, true,
+			"NetworkPolicyMissing", "service is restricted, but network policy does not exist")
+	default:
+		setCondition(status, v1alpha1.ConditionDegraded, false, "AsExpected", "")
+	}
+
+	if selectorStashed {
+		setCondition(status, v1alpha1.ConditionSelectorStashed, true,
+			"SelectorRemoved", "service selector is removed and kept in "+utils.AnnotationStashedSelector)
+	} else {
+		setCondition(status, v1alpha1.ConditionSelectorStashed, false,
+			"SelectorPresent", "pod endpoints are still bound to the service")
+	}
+
+	return status, nil
+}
+
+// DeleteWebhookProxy deletes WebhookProxy of webhook service which is not proxied any more.
+func (p *Proxy) DeleteWebhookProxy(ctx context.Context, serviceKey types.NamespacedName) error {
+	deleted, err := p.deleteManaged(ctx, serviceKey, new(v1alpha1.WebhookProxy))
+	if err != nil {
+		return fmt.Errorf("unable to delete WebhookProxy %s, %w", serviceKey, err)
+	}
+	if deleted {
+		p.log.Info("webhook proxy has been deleted, service is not proxied", "service", serviceKey)
+	}
+	return nil
+}
+
+// EnsureWebhookProxy creates WebhookProxy for webhook service and updates its status.
+func (p *Proxy) EnsureWebhookProxy(ctx context.Context, serviceOrigin *v1.Service, status *v1alpha1.WebhookProxyStatus) error {
+	webhookProxy := &v1alpha1.WebhookProxy{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      serviceOrigin.Name,
+			Namespace: serviceOrigin.Namespace,
+		},
+	}
+
+	webhookProxy.Labels = map[string]string{
+		utils.LabelManagedBy:      utils.ControllerName,
+		utils.LabelServiceProxyOf: serviceOrigin.Name,
+	}
+	webhookProxy.Spec.ServiceName = serviceOrigin.Name
+	if err := controllerutil.SetControllerReference(serviceOrigin, webhookProxy, p.client.Scheme()); err != nil {
+		return err
+	}
+	if _, err := p.apply(ctx, webhookProxy, true, nil); err != nil {
+		return fmt.Errorf("unable to ensure webhook proxy, %w", err)
+	}
+
+	// Merge into existing conditions, to keep transition times of unchanged ones.
+	conditions := webhookProxy.Status.Conditions
+	for _, condition := range status.Conditions {
+		meta.SetStatusCondition(&conditions, condition)
+	}
+	status.Conditions = conditions
+
+	// Status is applied from a new object, server fields of the response must not be sent back.
+	statusObj := &v1alpha1.WebhookProxy{
+		ObjectMeta: metav1.ObjectMeta{Name: webhookProxy.Name, Namespace: webhookProxy.Namespace},
+		Spec:       webhookProxy.Spec,
+		Status:     *status,
+	}
+	statusObj.SetGroupVersionKind(v1alpha1.GroupVersion.WithKind("WebhookProxy"))
+	if err := p.client.Status().Patch(ctx, statusObj, client.Apply,
+		client.FieldOwner(FieldManager), client.ForceOwnership); err != nil {
+		return fmt.Errorf("unable to update webhook proxy status, %w", err)
+	}
+
+	return nil
+}
+
+// getPolicyReference returns reference to the network policy of the service, nil if it does not exist.
+func (p *Proxy) getPolicyReference(ctx context.Context, serviceOrigin *v1.Service) (*v1alpha1.PolicyReference, error) {
+	key := types.NamespacedName{
+		Namespace: serviceOrigin.Namespace,
+		Name:      getProxyName(serviceOrigin.Name, serviceNameHashLen),
+	}
+
+	var obj client.Object = new(networkingv1.NetworkPolicy)
+	ref := &v1alpha1.PolicyReference{
+		APIVersion: networkingv1.SchemeGroupVersion.String(),
+		Kind:       "NetworkPolicy",
+		Name:       key.Name,
+	}
+	if p.cfg().Proxy.PolicyBackend == config.PolicyBackendCalico {
+		calicoPolicy := new(unstructured.Unstructured)
+		calicoPolicy.SetGroupVersionKind(calicoNetworkPolicyGVK)
+		obj = calicoPolicy
+		ref.APIVersion = calicoNetworkPolicyGVK.GroupVersion().String()
+	}
+
+	if err := p.client.Get(ctx, key, obj); err != nil {
+		if apierrors.IsNotFound(err) || meta.IsNoMatchError(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return ref, nil
+}
+
+func setCondition(status *v1alpha1.WebhookProxyStatus, conditionType string, value bool, reason, message string) {
+	conditionStatus := metav1.ConditionFalse
+	if value {
+		conditionStatus = metav1.ConditionTrue
+	}
+	meta.SetStatusCondition(&status.Conditions, metav1.Condition{
+		Type:    conditionType,
+		Status:  conditionStatus,
+		Reason:  reason,
+		Message: message,
+	})
+}
@@ -0,0 +1,248 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+
+	"github.com/CharlieR-o-o-t/eks-webhook-proxy/pkg/metrics"
+	"github.com/CharlieR-o-o-t/eks-webhook-proxy/pkg/utils"
+	v1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/utils/ptr"
+)
+
+// Trace hops, in the order kube-apiserver passes them.
+const (
+	TraceHopWebhook       = "Webhook"
+	TraceHopService       = "Service"
+	TraceHopProxyService  = "ProxyService"
+	TraceHopNodePort      = "NodePort"
+	TraceHopNetworkPolicy = "NetworkPolicy"
+	TraceHopEndpointSlice = "EndpointSlice"
+	TraceHopNode          = "Node"
+	TraceHopPod           = "Pod"
+)
+
+// Trace results.
+const (
+	TraceResultOK      = "OK"
+	TraceResultSkipped = "Skipped"
+	TraceResultFailed  = "Failed"
+)
+
+// traceSkipReasons maps endpoint skip reasons to trace reasons and messages.
+var traceSkipReasons = map[string][2]string{
+	metrics.SkipReasonNoNodeName:   {"NoNodeName", "endpoint has no nodeName, its node is unknown"},
+	metrics.SkipReasonNodeSelector: {"NodeSelector", "node does not match proxy node selector"},
+	metrics.SkipReasonNoNodeIP:     {"NoNodeIP", "node InternalIP is unknown"},
+}
+
+// TraceStep is a single hop of the path from kube-apiserver to webhook pod.
+type TraceStep struct {
+	Hop     string `json:"hop"`
+	Object  string `json:"object"`
+	Result  string `json:"result"`
+	Reason  string `json:"reason,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+// TraceService walks the path of webhook service port the way controllers build it:
+// service, proxy service, node port, proxy endpoint slice, nodes and pods.
+// Walk stops at the hop which breaks the path.
+func (p *Proxy) TraceService(ctx context.Context, serviceKey types.NamespacedName, port int32) ([]TraceStep, error) {
+	var steps []TraceStep
+	step := func(hop, object, result, reason, messageFmt string, args ...interface{}) {
+		steps = append(steps, TraceStep{
+			Hop:     hop,
+			Object:  object,
+			Result:  result,
+			Reason:  reason,
+			Message: fmt.Sprintf(messageFmt, args...),
+		})
+	}
+
+	if p.IsExcluded(serviceKey.Namespace) {
+		step(TraceHopService, serviceKey.String(), TraceResultSkipped, "NamespaceExcluded",
+			"namespace is excluded, service is called through ClusterIP")
+		return steps, nil
+	}
+
+	var serviceOrigin = new(v1.Service)
+	if err := p.client.Get(ctx, serviceKey, serviceOrigin); err != nil {
+		if !apierrors.IsNotFound(err) {
+			return nil, err
+		}
+		step(TraceHopService, serviceKey.String(), TraceResultFailed, "ServiceNotFound", "service does not exist")
+		return steps, nil
+	}
+
+	if serviceOrigin.Spec.Type != v1.ServiceTypeClusterIP {
+		step(TraceHopService, serviceKey.String(), TraceResultSkipped, "NotClusterIP",
+			"service type is %s, it is reachable without proxy", serviceOrigin.Spec.Type)
+		return steps, nil
+	}
+
+	servicePort, found := findServicePort(serviceOrigin.Spec.Ports, port)
+	if !found {
+		step(TraceHopService, serviceKey.String(), TraceResultFailed, "PortNotFound", "service has no TCP port %d", port)
+		return steps, nil
+	}
+
+	selectorState := "selector is present, pod endpoints are still bound"
+	if len(serviceOrigin.Spec.Selector) == 0 && serviceOrigin.Annotations[utils.AnnotationStashedSelector] != "" {
+		selectorState = "selector is stashed, traffic goes through proxy only"
+	}
+	step(TraceHopService, serviceKey.String(), TraceResultOK, "", "port %d, %s", port, selectorState)
+
+	settings := p.resolveSettings(ctx, serviceOrigin)
+
+	proxyServiceKey := types.NamespacedName{
+		Namespace: serviceOrigin.Namespace,
+		Name:      getProxyName(serviceOrigin.Name, serviceNameHashLen),
+	}
+	var proxyService = new(v1.Service)
+	if err := p.client.Get(ctx, proxyServiceKey, proxyService); err != nil {
+		if !apierrors.IsNotFound(err) {
+			return nil, err
+		}
+		step(TraceHopProxyService, proxyServiceKey.String(), TraceResultFailed, "ProxyServiceNotFound",
+			"proxy service is not created, check events of the webhook service")
+		return steps, nil
+	}
+	step(TraceHopProxyService, proxyServiceKey.String(), TraceResultOK, "",
+		"type %s, restricted %t", proxyService.Spec.Type, settings.restricted)
+
+	var nodePort int32
+	for _, proxyPort := range proxyService.Spec.Ports {
+		if proxyPort.Port == port && proxyPort.Protocol == v1.ProtocolTCP {
+			nodePort = proxyPort.NodePort
+		}
+	}
+	switch {
+	case len(settings.filterPorts([]v1.ServicePort{servicePort})) == 0:
+		step(TraceHopNodePort, strconv.Itoa(int(port)), TraceResultFailed, "PortNotProxied",
+			"port is not selected by %s annotation", utils.AnnotationProxyPorts)
+		return steps, nil
+	case nodePort == 0:
+		step(TraceHopNodePort, strconv.Itoa(int(port)), TraceResultFailed, "PortNotProxied",
+			"proxy service has no node port for port %d", port)
+		return steps, nil
+	}
+	step(TraceHopNodePort, strconv.Itoa(int(nodePort)), TraceResultOK, "",
+		"service port %d is published on node port %d", port, nodePort)
+
+	if settings.restricted {
+		policy, err := p.getPolicyReference(ctx, serviceOrigin)
+		if err != nil {
+			return nil, err
+		}
+		if policy == nil {
+			step(TraceHopNetworkPolicy, proxyServiceKey.Name, TraceResultFailed, "NetworkPolicyMissing",
+				"service is restricted, but network policy does not exist, selector is not removed")
+		} else {
+			step(TraceHopNetworkPolicy, proxyServiceKey.Name, TraceResultOK, "", "%s %s", policy.Kind, policy.APIVersion)
+		}
+	}
+
+	// Webhook endpoints are taken from endpoint slices of proxy service, the way EnsureProxyEndpointSlices does.
+	endpointSlices, err := p.getEndpointSlices(ctx, proxyServiceKey)
+	if err != nil {
+		return nil, err
+	}
+	var webhookEndpoints []discoveryv1.Endpoint
+	for _, endpointSlice := range endpointSlices {
+		webhookEndpoints = append(webhookEndpoints, endpointSlice.Endpoints...)
+	}
+
+	proxyEndpointSliceKey := types.NamespacedName{
+		Namespace: proxyService.Namespace,
+		Name:      getProxyName(proxyService.Name, serviceNameHashLen),
+	}
+	var proxyEndpointSlice = new(discoveryv1.EndpointSlice)
+	if err := p.client.Get(ctx, proxyEndpointSliceKey, proxyEndpointSlice); err != nil {
+		if !apierrors.IsNotFound(err) {
+			return nil, err
+		}
+		step(TraceHopEndpointSlice, proxyEndpointSliceKey.String(), TraceResultFailed, "EndpointSliceNotFound",
+			"proxy endpoint slice is not created")
+		return steps, nil
+	}
+
+	published := make(map[string]discoveryv1.Endpoint, len(proxyEndpointSlice.Endpoints))
+	var readyEndpoints int
+	for _, endpoint := range proxyEndpointSlice.Endpoints {
+		if len(endpoint.Addresses) > 0 {
+			published[endpoint.Addresses[0]] = endpoint
+		}
+		if ptr.Deref(endpoint.Conditions.Ready, true) {
+			readyEndpoints++
+		}
+	}
+	switch {
+	case len(webhookEndpoints) == 0:
+		step(TraceHopEndpointSlice, proxyEndpointSliceKey.String(), TraceResultFailed, "NoPodEndpoints",
+			"proxy service selector matches no pods")
+		return steps, nil
+	case readyEndpoints == 0:
+		step(TraceHopEndpointSlice, proxyEndpointSliceKey.String(), TraceResultFailed, "NoReadyEndpoints",
+			"%d of %d node endpoints are ready", readyEndpoints, len(proxyEndpointSlice.Endpoints))
+	default:
+		step(TraceHopEndpointSlice, proxyEndpointSliceKey.String(), TraceResultOK, "",
+			"%d of %d node endpoints are ready", readyEndpoints, len(proxyEndpointSlice.Endpoints))
+	}
+
+	allowedNodes, err := p.getAllowedNodes(ctx, settings.nodeSelector)
+	if err != nil {
+		return nil, err
+	}
+	ports := nodeEndpointPorts(proxyService)
+
+	for _, webhookEndpoint := range webhookEndpoints {
+		nodeName := ptr.Deref(webhookEndpoint.NodeName, "<none>")
+
+		endpoint, reason := p.proxyEndpoint(webhookEndpoint, ports, allowedNodes)
+		switch {
+		case endpoint == nil:
+			skip := traceSkipReasons[reason]
+			step(TraceHopNode, nodeName, TraceResultSkipped, skip[0], "%s", skip[1])
+		default:
+			address := net.JoinHostPort(endpoint.Addresses[0], strconv.Itoa(int(nodePort)))
+			publishedEndpoint, ok := published[endpoint.Addresses[0]]
+			switch {
+			case !ok:
+				step(TraceHopNode, nodeName, TraceResultFailed, "NotPublished",
+					"%s is missing in proxy endpoint slice, it is published on the next reconcile", address)
+			case !ptr.Deref(publishedEndpoint.Conditions.Ready, true) && ptr.Deref(webhookEndpoint.Conditions.Ready, true):
+				step(TraceHopNode, nodeName, TraceResultFailed, "NodePortUnreachable",
+					"%s is published not ready, endpoint probe fails", address)
+			default:
+				step(TraceHopNode, nodeName, TraceResultOK, "", "published as %s", address)
+			}
+		}
+
+		podName := "<unknown>"
+		if ref := webhookEndpoint.TargetRef; ref != nil && ref.Kind == "Pod" {
+			podName = types.NamespacedName{Namespace: ref.Namespace, Name: ref.Name}.String()
+		}
+		if ptr.Deref(webhookEndpoint.Conditions.Ready, true) {
+			step(TraceHopPod, podName, TraceResultOK, "", "addresses %v", webhookEndpoint.Addresses)
+		} else {
+			step(TraceHopPod, podName, TraceResultFailed, "PodNotReady", "addresses %v", webhookEndpoint.Addresses)
+		}
+	}
+
+	return steps, nil
+}
+
+func findServicePort(servicePorts []v1.ServicePort, port int32) (v1.ServicePort, bool) {
+	for _, servicePort := range servicePorts {
+		if servicePort.Port == port && servicePort.Protocol == v1.ProtocolTCP {
+			return servicePort, true
+		}
+	}
+	return v1.ServicePort{}, false
+}
@@ -0,0 +1,109 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/CharlieR-o-o-t/eks-webhook-proxy/pkg/metrics"
+	"github.com/CharlieR-o-o-t/eks-webhook-proxy/pkg/tracing"
+	"github.com/CharlieR-o-o-t/eks-webhook-proxy/pkg/webhookref"
+	"go.opentelemetry.io/otel/trace"
+	admissionv1 "k8s.io/api/admissionregistration/v1"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// EnsureWebhookService publishes webhook service through NodePort proxy:
+// ensures proxy service, proxy endpoint slices and unbinds pod endpoints from the service.
+// The result is kept for WebhookProxy status and the proxy_failed metric.
+func (p *Proxy) EnsureWebhookService(ctx context.Context, serviceRef *admissionv1.ServiceReference) (err error) {
+	serviceKey := types.NamespacedName{Namespace: serviceRef.Namespace, Name: serviceRef.Name}
+	ctx, span := tracing.Start(ctx, "EnsureWebhookService", tracing.Service(serviceKey))
+	defer func() {
+		endSpan(span, err)
+		p.recordFailure(ctx, serviceKey, err)
+	}()
+
+	serviceProxy, err := p.EnsureServiceProxy(ctx, serviceRef)
+	if err != nil {
+		return err
+	}
+
+	if err := p.EnsureProxyEndpointSlices(ctx, serviceProxy); err != nil {
+		return fmt.Errorf("unable to create proxy EndpointSlices, %w", err)
+	}
+
+	if err := p.UnbindPodEndpoints(ctx, serviceRef); err != nil {
+		return fmt.Errorf("unable to unbind Pod Endpoints from webhook service, %w", err)
+	}
+
+	return nil
+}
+
+// EnsureAllWebhookServices re-ensures every service referenced by selected webhooks,
+// used on startup and when configuration is changed. Errors of single services are joined
+// and wrapped with ErrServicesFailed, the pass over all services is completed in that case.
+func (p *Proxy) EnsureAllWebhookServices(ctx context.Context) error {
+	services, err := webhookref.ListSelected(ctx, p.client, p.cfg().Proxy.SelectionMode)
+	if err != nil {
+		return err
+	}
+
+	var errs []error
+	for serviceKey := range services {
+		serviceRef := &admissionv1.ServiceReference{
+			Namespace: serviceKey.Namespace,
+			Name:      serviceKey.Name,
+		}
+
+		if err := p.EnsureWebhookService(ctx, serviceRef); err != nil {
+			if errors.Is(err, ErrServiceNotFound) || errors.Is(err, ErrServiceNotProxied) {
+				continue
+			}
+			errs = append(errs, fmt.Errorf("service %s: %w", serviceKey, err))
+		}
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("%w: %d services, %w", ErrServicesFailed, len(errs), errors.Join(errs...))
+	}
+	return nil
+}
+
+// recordFailure keeps result of proxy ensure, services which are not proxied are not failures.
+// Failures are recorded as events on the service and its webhooks here only, controllers do not emit them.
+func (p *Proxy) recordFailure(ctx context.Context, serviceKey types.NamespacedName, err error) {
+	if errors.Is(err, ErrServiceNotFound) || errors.Is(err, ErrServiceNotProxied) {
+		err = nil
+	}
+	p.failures.set(serviceKey, err)
+
+	failed := 0.0
+	if err != nil {
+		failed = 1
+	}
+	metrics.ProxyFailed.WithLabelValues(serviceKey.Namespace, serviceKey.Name).Set(failed)
+	if err == nil {
+		return
+	}
+
+	var serviceOrigin = new(v1.Service)
+	if err := p.client.Get(ctx, serviceKey, serviceOrigin); err != nil {
+		return
+	}
+	reason := EventReasonProxyFailed
+	if errors.Is(err, errNetworkPolicy) {
+		reason = EventReasonNetworkPolicyFailed
+	}
+	p.recordEvent(ctx, serviceOrigin, v1.EventTypeWarning, reason, "unable to proxy webhook service: %v", err)
+}
+
+// endSpan ends proxy step span, services which are not proxied are not traced as errors.
+func endSpan(span trace.Span, err error) {
+	if errors.Is(err, ErrServiceNotFound) || errors.Is(err, ErrServiceNotProxied) {
+		span.SetAttributes(tracing.AttrSkipped.String(err.Error()))
+		err = nil
+	}
+	tracing.End(span, err)
+}
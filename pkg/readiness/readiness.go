@@ -0,0 +1,91 @@
+// Package readiness provides readyz checks, so the controller is not reported ready while proxies are stale.
+package readiness
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/CharlieR-o-o-t/eks-webhook-proxy/pkg/proxy"
+	"github.com/go-logr/logr"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/healthz"
+)
+
+const maxRetryDelay = time.Minute
+
+var (
+	ErrNotLeader               = errors.New("not elected as leader")
+	ErrInitialReconcilePending = errors.New("initial reconcile of webhook services is not completed")
+)
+
+// Leader returns readyz check passing once manager is elected as leader,
+// or immediately when leader election is disabled.
+func Leader(mgr ctrl.Manager) healthz.Checker {
+	return func(_ *http.Request) error {
+		select {
+		case <-mgr.Elected():
+			return nil
+		default:
+			return ErrNotLeader
+		}
+	}
+}
+
+// InitialReconcile ensures every webhook service once after caches are synced.
+// It runs on the leader only. The check passes once a pass over all services is completed,
+// services which failed are reported in WebhookProxy status and metrics and are retried by controllers,
+// so a single broken webhook service does not keep the controller not ready.
+// Passes which could not list webhook services are retried with backoff.
+type InitialReconcile struct {
+	Manager ctrl.Manager
+	Proxy   *proxy.Proxy
+	Log     logr.Logger
+
+	done atomic.Bool
+}
+
+// Start implements manager.Runnable.
+func (r *InitialReconcile) Start(ctx context.Context) error {
+	// Cache sync is interrupted only by shutdown.
+	if !r.Manager.GetCache().WaitForCacheSync(ctx) {
+		return nil
+	}
+
+	for delay := time.Second; ; delay = min(delay*2, maxRetryDelay) {
+		err := r.Proxy.EnsureAllWebhookServices(ctx)
+		if err == nil {
+			break
+		}
+		if errors.Is(err, proxy.ErrServicesFailed) {
+			r.Log.Error(err, "some webhook services are not ensured, they are retried by controllers")
+			break
+		}
+		r.Log.Error(err, "initial reconcile of webhook services failed, retrying", "delay", delay)
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(delay):
+		}
+	}
+
+	r.Log.Info("initial reconcile of webhook services is completed")
+	r.done.Store(true)
+	return nil
+}
+
+// NeedLeaderElection implements manager.LeaderElectionRunnable.
+func (r *InitialReconcile) NeedLeaderElection() bool {
+	return true
+}
+
+// Checker is a readyz check passing once initial reconcile is completed.
+func (r *InitialReconcile) Checker(_ *http.Request) error {
+	if !r.done.Load() {
+		return ErrInitialReconcilePending
+	}
+	return nil
+}
@@ -0,0 +1,152 @@
+package securitygroup
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	ec2types "github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/aws/smithy-go"
+)
+
+// EC2 manages security group rules with the AWS EC2 API.
+type EC2 struct {
+	client *ec2.Client
+}
+
+// NewEC2 creates EC2 provider using default AWS credentials chain (IRSA, instance profile, env).
+func NewEC2(ctx context.Context) (*EC2, error) {
+	cfg, err := awsconfig.LoadDefaultConfig(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("unable to load AWS config, %w", err)
+	}
+
+	return &EC2{client: ec2.NewFromConfig(cfg)}, nil
+}
+
+func (e *EC2) ListIngressRules(ctx context.Context, groupID string) ([]Rule, error) {
+	var rules []Rule
+
+	paginator := ec2.NewDescribeSecurityGroupRulesPaginator(e.client, &ec2.DescribeSecurityGroupRulesInput{
+		Filters: []ec2types.Filter{
+			{Name: aws.String("group-id"), Values: []string{groupID}},
+		},
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("unable to describe security group %s rules, %w", groupID, err)
+		}
+
+		for _, groupRule := range page.SecurityGroupRules {
+			if aws.ToBool(groupRule.IsEgress) ||
+				groupRule.ReferencedGroupInfo == nil ||
+				aws.ToInt32(groupRule.FromPort) != aws.ToInt32(groupRule.ToPort) {
+				continue
+			}
+
+			rules = append(rules, Rule{
+				Protocol:      fromIPProtocol(aws.ToString(groupRule.IpProtocol)),
+				Port:          aws.ToInt32(groupRule.FromPort),
+				SourceGroupID: aws.ToString(groupRule.ReferencedGroupInfo.GroupId),
+				Owned:         aws.ToString(groupRule.Description) == RuleDescription,
+			})
+		}
+	}
+
+	return rules, nil
+}
+
+func (e *EC2) AuthorizeIngress(ctx context.Context, groupID string, rules []Rule) error {
+	if len(rules) == 0 {
+		return nil
+	}
+
+	err := e.authorize(ctx, groupID, rules)
+	if !isDuplicate(err) {
+		return err
+	}
+
+	// Batch is rejected as a whole when any rule exists, e.g. one added in between by hand.
+	for _, rule := range rules {
+		if err := e.authorize(ctx, groupID, []Rule{rule}); err != nil && !isDuplicate(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+func (e *EC2) authorize(ctx context.Context, groupID string, rules []Rule) error {
+	_, err := e.client.AuthorizeSecurityGroupIngress(ctx, &ec2.AuthorizeSecurityGroupIngressInput{
+		GroupId:       aws.String(groupID),
+		IpPermissions: toIPPermissions(rules),
+	})
+	if err != nil {
+		return fmt.Errorf("unable to authorize ingress on security group %s, %w", groupID, err)
+	}
+	return nil
+}
+
+func isDuplicate(err error) bool {
+	var apiErr smithy.APIError
+	return errors.As(err, &apiErr) && apiErr.ErrorCode() == "InvalidPermission.Duplicate"
+}
+
+func (e *EC2) RevokeIngress(ctx context.Context, groupID string, rules []Rule) error {
+	if len(rules) == 0 {
+		return nil
+	}
+
+	_, err := e.client.RevokeSecurityGroupIngress(ctx, &ec2.RevokeSecurityGroupIngressInput{
+		GroupId:       aws.String(groupID),
+		IpPermissions: toIPPermissions(rules),
+	})
+	if err != nil {
+		return fmt.Errorf("unable to revoke ingress on security group %s, %w", groupID, err)
+	}
+	return nil
+}
+
+func toIPPermissions(rules []Rule) []ec2types.IpPermission {
+	permissions := make([]ec2types.IpPermission, 0, len(rules))
+	for _, rule := range rules {
+		permissions = append(permissions, ec2types.IpPermission{
+			IpProtocol: aws.String(toIPProtocol(rule.Protocol)),
+			FromPort:   aws.Int32(rule.Port),
+			ToPort:     aws.Int32(rule.Port),
+			UserIdGroupPairs: []ec2types.UserIdGroupPair{
+				{
+					GroupId:     aws.String(rule.SourceGroupID),
+					Description: aws.String(RuleDescription),
+				},
+			},
+		})
+	}
+	return permissions
+}
+
+// toIPProtocol converts kubernetes protocol (TCP/UDP/SCTP) into EC2 protocol name.
+func toIPProtocol(protocol string) string {
+	switch protocol {
+	case "UDP":
+		return "udp"
+	case "SCTP":
+		return "132"
+	default:
+		return "tcp"
+	}
+}
+
+func fromIPProtocol(protocol string) string {
+	switch protocol {
+	case "udp", "17":
+		return "UDP"
+	case "132":
+		return "SCTP"
+	default:
+		return "TCP"
+	}
+}
@@ -0,0 +1,66 @@
+package securitygroup
+
+import (
+	"context"
+	"sync"
+)
+
+// Fake is in-memory Provider implementation, used in tests.
+type Fake struct {
+	mu     sync.Mutex
+	groups map[string]map[Rule]struct{}
+}
+
+func NewFake() *Fake {
+	return &Fake{
+		groups: make(map[string]map[Rule]struct{}),
+	}
+}
+
+func (f *Fake) ListIngressRules(_ context.Context, groupID string) ([]Rule, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return sortedRules(f.groups[groupID]), nil
+}
+
+func (f *Fake) AuthorizeIngress(_ context.Context, groupID string, rules []Rule) error {
+	f.add(groupID, rules, true)
+	return nil
+}
+
+// AddRules adds rules not owned by the controller, as if they were added by hand.
+func (f *Fake) AddRules(groupID string, rules []Rule) {
+	f.add(groupID, rules, false)
+}
+
+func (f *Fake) add(groupID string, rules []Rule, owned bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.groups[groupID] == nil {
+		f.groups[groupID] = make(map[Rule]struct{})
+	}
+	for _, rule := range rules {
+		rule.Owned = false
+		if _, ok := f.groups[groupID][rule]; ok {
+			continue
+		}
+		rule.Owned = true
+		if _, ok := f.groups[groupID][rule]; ok {
+			continue
+		}
+		rule.Owned = owned
+		f.groups[groupID][rule] = struct{}{}
+	}
+}
+
+func (f *Fake) RevokeIngress(_ context.Context, groupID string, rules []Rule) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, rule := range rules {
+		rule.Owned = false
+		delete(f.groups[groupID], rule)
+		rule.Owned = true
+		delete(f.groups[groupID], rule)
+	}
+	return nil
+}
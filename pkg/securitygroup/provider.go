@@ -0,0 +1,116 @@
+package securitygroup
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/CharlieR-o-o-t/eks-webhook-proxy/pkg/utils"
+	v1 "k8s.io/api/core/v1"
+)
+
+const (
+	// RuleDescription marks ingress rules owned by the controller.
+	// Rules without it are never revoked.
+	RuleDescription = utils.ControllerName
+)
+
+// Rule is a single ingress permission on the node security group.
+type Rule struct {
+	Protocol      string
+	Port          int32
+	SourceGroupID string
+	// Owned is set on listed rules described with RuleDescription, only they are revoked.
+	Owned bool
+}
+
+func (r Rule) String() string {
+	return fmt.Sprintf("%s/%d from %s", r.Protocol, r.Port, r.SourceGroupID)
+}
+
+// Provider manages ingress rules of a cloud security group.
+type Provider interface {
+	// ListIngressRules returns single port ingress rules from source security groups,
+	// rules owned by the controller are marked Owned.
+	ListIngressRules(ctx context.Context, groupID string) ([]Rule, error)
+	// AuthorizeIngress adds ingress rules to the group, rules which already exist are skipped.
+	AuthorizeIngress(ctx context.Context, groupID string, rules []Rule) error
+	// RevokeIngress removes ingress rules from the group.
+	RevokeIngress(ctx context.Context, groupID string, rules []Rule) error
+}
+
+// DesiredRules computes ingress rules required for NodePorts of proxy services.
+func DesiredRules(services []v1.Service, sourceGroupID string) []Rule {
+	set := make(map[Rule]struct{})
+	for _, service := range services {
+		for _, port := range service.Spec.Ports {
+			if port.NodePort == 0 {
+				continue
+			}
+			protocol := port.Protocol
+			if protocol == "" {
+				protocol = v1.ProtocolTCP
+			}
+			set[Rule{
+				Protocol:      string(protocol),
+				Port:          port.NodePort,
+				SourceGroupID: sourceGroupID,
+			}] = struct{}{}
+		}
+	}
+
+	return sortedRules(set)
+}
+
+// Diff returns rules which should be authorized and revoked to move from current to desired.
+// Rules added by others are never authorized again and never revoked.
+func Diff(current, desired []Rule) (authorize, revoke []Rule) {
+	// owned by rule without the Owned mark.
+	currentSet := make(map[Rule]bool, len(current))
+	for _, rule := range current {
+		owned := rule.Owned
+		rule.Owned = false
+		currentSet[rule] = currentSet[rule] || owned
+	}
+	desiredSet := toSet(desired)
+
+	toAuthorize := make(map[Rule]struct{})
+	for rule := range desiredSet {
+		if _, ok := currentSet[rule]; !ok {
+			toAuthorize[rule] = struct{}{}
+		}
+	}
+	toRevoke := make(map[Rule]struct{})
+	for rule, owned := range currentSet {
+		if _, ok := desiredSet[rule]; !ok && owned {
+			toRevoke[rule] = struct{}{}
+		}
+	}
+
+	return sortedRules(toAuthorize), sortedRules(toRevoke)
+}
+
+func toSet(rules []Rule) map[Rule]struct{} {
+	set := make(map[Rule]struct{}, len(rules))
+	for _, rule := range rules {
+		set[rule] = struct{}{}
+	}
+	return set
+}
+
+func sortedRules(set map[Rule]struct{}) []Rule {
+	rules := make([]Rule, 0, len(set))
+	for rule := range set {
+		rules = append(rules, rule)
+	}
+	sort.Slice(rules, func(i, j int) bool {
+		if rules[i].Port != rules[j].Port {
+			return rules[i].Port < rules[j].Port
+		}
+		if rules[i].Protocol != rules[j].Protocol {
+			return rules[i].Protocol < rules[j].Protocol
+		}
+		return rules[i].SourceGroupID < rules[j].SourceGroupID
+	})
+	return rules
+}
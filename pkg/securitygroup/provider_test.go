@@ -0,0 +1,152 @@
+package securitygroup
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+)
+
+func proxyService(ports ...v1.ServicePort) v1.Service {
+	return v1.Service{Spec: v1.ServiceSpec{Type: v1.ServiceTypeNodePort, Ports: ports}}
+}
+
+func TestDesiredRules(t *testing.T) {
+	services := []v1.Service{
+		proxyService(
+			v1.ServicePort{Port: 443, NodePort: 30443, Protocol: v1.ProtocolTCP},
+			// Protocol defaults to TCP.
+			v1.ServicePort{Port: 8443, NodePort: 30100},
+		),
+		proxyService(
+			// Node port is not allocated yet.
+			v1.ServicePort{Port: 443},
+			v1.ServicePort{Port: 53, NodePort: 30053, Protocol: v1.ProtocolUDP},
+		),
+		// Same node port is collapsed into a single rule.
+		proxyService(v1.ServicePort{Port: 443, NodePort: 30443, Protocol: v1.ProtocolTCP}),
+	}
+
+	want := []Rule{
+		{Protocol: "UDP", Port: 30053, SourceGroupID: "sg-cluster"},
+		{Protocol: "TCP", Port: 30100, SourceGroupID: "sg-cluster"},
+		{Protocol: "TCP", Port: 30443, SourceGroupID: "sg-cluster"},
+	}
+	if got := DesiredRules(services, "sg-cluster"); !reflect.DeepEqual(got, want) {
+		t.Errorf("DesiredRules() = %v, want %v", got, want)
+	}
+
+	if got := DesiredRules(nil, "sg-cluster"); len(got) != 0 {
+		t.Errorf("DesiredRules(nil) = %v, want no rules", got)
+	}
+}
+
+func owned(rule Rule) Rule {
+	rule.Owned = true
+	return rule
+}
+
+func TestDiff(t *testing.T) {
+	kept := Rule{Protocol: "TCP", Port: 30443, SourceGroupID: "sg-cluster"}
+	added := Rule{Protocol: "TCP", Port: 30100, SourceGroupID: "sg-cluster"}
+	stale := Rule{Protocol: "TCP", Port: 30200, SourceGroupID: "sg-cluster"}
+	// Source group is part of the rule, changed cluster group replaces rules.
+	moved := Rule{Protocol: "TCP", Port: 30443, SourceGroupID: "sg-old"}
+
+	tests := []struct {
+		name          string
+		current       []Rule
+		desired       []Rule
+		wantAuthorize []Rule
+		wantRevoke    []Rule
+	}{
+		{
+			name:    "in sync",
+			current: []Rule{owned(kept)},
+			desired: []Rule{kept},
+		},
+		{
+			name:          "empty group",
+			desired:       []Rule{kept, added},
+			wantAuthorize: []Rule{added, kept},
+		},
+		{
+			name:          "added and stale",
+			current:       []Rule{owned(kept), owned(stale)},
+			desired:       []Rule{added, kept},
+			wantAuthorize: []Rule{added},
+			wantRevoke:    []Rule{stale},
+		},
+		{
+			name:          "source group changed",
+			current:       []Rule{owned(moved)},
+			desired:       []Rule{kept},
+			wantAuthorize: []Rule{kept},
+			wantRevoke:    []Rule{moved},
+		},
+		{
+			name:       "no proxy services",
+			current:    []Rule{owned(kept), owned(stale)},
+			wantRevoke: []Rule{stale, kept},
+		},
+		{
+			// Rules added by hand are neither duplicated nor revoked.
+			name:          "not owned",
+			current:       []Rule{kept, stale},
+			desired:       []Rule{added, kept},
+			wantAuthorize: []Rule{added},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			authorize, revoke := Diff(tt.current, tt.desired)
+			if len(authorize) != 0 || len(tt.wantAuthorize) != 0 {
+				if !reflect.DeepEqual(authorize, tt.wantAuthorize) {
+					t.Errorf("authorize = %v, want %v", authorize, tt.wantAuthorize)
+				}
+			}
+			if len(revoke) != 0 || len(tt.wantRevoke) != 0 {
+				if !reflect.DeepEqual(revoke, tt.wantRevoke) {
+					t.Errorf("revoke = %v, want %v", revoke, tt.wantRevoke)
+				}
+			}
+		})
+	}
+}
+
+func TestFake(t *testing.T) {
+	ctx := context.Background()
+	fake := NewFake()
+	first := Rule{Protocol: "TCP", Port: 30443, SourceGroupID: "sg-cluster"}
+	second := Rule{Protocol: "TCP", Port: 30100, SourceGroupID: "sg-cluster"}
+
+	if err := fake.AuthorizeIngress(ctx, "sg-node", []Rule{first, second}); err != nil {
+		t.Fatal(err)
+	}
+	if err := fake.RevokeIngress(ctx, "sg-node", []Rule{first}); err != nil {
+		t.Fatal(err)
+	}
+
+	rules, err := fake.ListIngressRules(ctx, "sg-node")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []Rule{owned(second)}; !reflect.DeepEqual(rules, want) {
+		t.Errorf("ListIngressRules() = %v, want %v", rules, want)
+	}
+
+	// Rule added by hand is kept as not owned.
+	fake.AddRules("sg-node", []Rule{first})
+	if err := fake.AuthorizeIngress(ctx, "sg-node", []Rule{first}); err != nil {
+		t.Fatal(err)
+	}
+	rules, err = fake.ListIngressRules(ctx, "sg-node")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []Rule{owned(second), first}; !reflect.DeepEqual(rules, want) {
+		t.Errorf("ListIngressRules() = %v, want %v", rules, want)
+	}
+}
@@ -0,0 +1,106 @@
+// Package tlscheck performs TLS handshake with webhook backends the way kube-apiserver does,
+// verifying the serving certificate against webhook caBundle and service DNS name.
+package tlscheck
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+)
+
+// Result reasons.
+const (
+	ReasonVerified         = "Verified"
+	ReasonExpiringSoon     = "ExpiringSoon"
+	ReasonExpired          = "Expired"
+	ReasonUnknownAuthority = "UnknownAuthority"
+	ReasonHostnameMismatch = "HostnameMismatch"
+	ReasonInvalidCABundle  = "InvalidCABundle"
+	ReasonHandshakeFailed  = "HandshakeFailed"
+)
+
+// Result is the outcome of a certificate check.
+type Result struct {
+	Reason  string
+	Message string
+	// NotAfter is the expiry of the serving certificate, zero if it was not received.
+	NotAfter time.Time
+}
+
+// Valid tells if kube-apiserver would accept the certificate.
+func (r Result) Valid() bool {
+	return r.Reason == ReasonVerified || r.Reason == ReasonExpiringSoon
+}
+
+// Check dials address, performs TLS handshake for serverName and verifies the serving certificate
+// against caBundle (system roots when empty). Certificates expiring within expiryWarning are reported as ExpiringSoon.
+func Check(ctx context.Context, address, serverName string, caBundle []byte, timeout, expiryWarning time.Duration) Result {
+	var roots *x509.CertPool
+	if len(caBundle) > 0 {
+		roots = x509.NewCertPool()
+		if !roots.AppendCertsFromPEM(caBundle) {
+			return Result{Reason: ReasonInvalidCABundle, Message: "caBundle does not contain PEM certificates"}
+		}
+	}
+
+	dialer := &tls.Dialer{
+		NetDialer: &net.Dialer{Timeout: timeout},
+		Config: &tls.Config{
+			ServerName: serverName,
+			RootCAs:    roots,
+			MinVersion: tls.VersionTLS12,
+		},
+	}
+
+	conn, err := dialer.DialContext(ctx, "tcp", address)
+	if err != nil {
+		return classify(err)
+	}
+	defer conn.Close()
+
+	peerCertificates := conn.(*tls.Conn).ConnectionState().PeerCertificates
+	if len(peerCertificates) == 0 {
+		return Result{Reason: ReasonHandshakeFailed, Message: "no serving certificate received"}
+	}
+	notAfter := peerCertificates[0].NotAfter
+
+	if remaining := time.Until(notAfter); remaining < expiryWarning {
+		return Result{
+			Reason:   ReasonExpiringSoon,
+			Message:  fmt.Sprintf("serving certificate expires at %s, in %s", notAfter.Format(time.RFC3339), remaining.Round(time.Minute)),
+			NotAfter: notAfter,
+		}
+	}
+
+	return Result{
+		Reason:   ReasonVerified,
+		Message:  fmt.Sprintf("serving certificate is valid for %s until %s", serverName, notAfter.Format(time.RFC3339)),
+		NotAfter: notAfter,
+	}
+}
+
+func classify(err error) Result {
+	result := Result{Reason: ReasonHandshakeFailed, Message: err.Error()}
+
+	var verificationErr *tls.CertificateVerificationError
+	if errors.As(err, &verificationErr) && len(verificationErr.UnverifiedCertificates) > 0 {
+		result.NotAfter = verificationErr.UnverifiedCertificates[0].NotAfter
+	}
+
+	var unknownAuthorityErr x509.UnknownAuthorityError
+	var hostnameErr x509.HostnameError
+	var invalidErr x509.CertificateInvalidError
+	switch {
+	case errors.As(err, &unknownAuthorityErr):
+		result.Reason = ReasonUnknownAuthority
+	case errors.As(err, &hostnameErr):
+		result.Reason = ReasonHostnameMismatch
+	case errors.As(err, &invalidErr) && invalidErr.Reason == x509.Expired:
+		result.Reason = ReasonExpired
+	}
+	return result
+}
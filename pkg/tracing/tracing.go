@@ -0,0 +1,117 @@
+// Package tracing configures OpenTelemetry tracing of controller reconciles and proxy steps.
+// When tracing is disabled the global no-op tracer provider is used, so spans cost nothing.
+package tracing
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/CharlieR-o-o-t/eks-webhook-proxy/config"
+	"github.com/CharlieR-o-o-t/eks-webhook-proxy/pkg/utils"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+const (
+	tracerName = "github.com/CharlieR-o-o-t/eks-webhook-proxy"
+
+	AttrController = attribute.Key("webhookproxy.controller")
+	AttrRequest    = attribute.Key("webhookproxy.request")
+	AttrService    = attribute.Key("webhookproxy.service")
+	AttrWebhook    = attribute.Key("webhookproxy.webhook")
+	AttrOperation  = attribute.Key("webhookproxy.operation")
+	AttrEndpoints  = attribute.Key("webhookproxy.endpoints")
+	AttrSkipped    = attribute.Key("webhookproxy.skipped")
+)
+
+// Setup installs global tracer provider exporting spans with OTLP over HTTP.
+// Returned function flushes pending spans and must be called on shutdown.
+func Setup(ctx context.Context, cfg config.Tracing) (func(context.Context) error, error) {
+	if !cfg.Enabled {
+		return func(context.Context) error { return nil }, nil
+	}
+
+	opts := []otlptracehttp.Option{otlptracehttp.WithEndpoint(cfg.Endpoint)}
+	if cfg.Insecure {
+		opts = append(opts, otlptracehttp.WithInsecure())
+	}
+
+	exporter, err := otlptracehttp.New(ctx, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create OTLP trace exporter, %w", err)
+	}
+
+	provider := NewProvider(exporter, cfg.SampleRatio)
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+
+	return provider.Shutdown, nil
+}
+
+// NewProvider creates tracer provider exporting spans to exporter.
+// Tests may pass an in-memory exporter (sdk/trace/tracetest) and need no collector.
+func NewProvider(exporter sdktrace.SpanExporter, sampleRatio float64) *sdktrace.TracerProvider {
+	return sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(sampleRatio))),
+		sdktrace.WithResource(resource.NewSchemaless(
+			attribute.String("service.name", utils.ControllerName),
+		)),
+	)
+}
+
+// Start starts span with the global tracer provider.
+func Start(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return otel.Tracer(tracerName).Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// End records err on span, when set, and ends span.
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// SetAttributes adds attributes to the span in context.
+func SetAttributes(ctx context.Context, attrs ...attribute.KeyValue) {
+	trace.SpanFromContext(ctx).SetAttributes(attrs...)
+}
+
+// Service returns service attribute.
+func Service(key types.NamespacedName) attribute.KeyValue {
+	return AttrService.String(key.String())
+}
+
+// Webhook returns webhook attribute of mutating, validating configuration or CRD, "<kind>/<name>".
+func Webhook(kind, name string) attribute.KeyValue {
+	return AttrWebhook.String(kind + "/" + name)
+}
+
+// Operation returns CreateOrUpdate result attribute.
+func Operation(op controllerutil.OperationResult) attribute.KeyValue {
+	return AttrOperation.String(string(op))
+}
+
+// Reconciler wraps reconciler, every reconcile runs in its own span.
+func Reconciler(controllerName string, r reconcile.Reconciler) reconcile.Reconciler {
+	return reconcile.Func(func(ctx context.Context, req reconcile.Request) (reconcile.Result, error) {
+		ctx, span := Start(ctx, controllerName+".Reconcile",
+			AttrController.String(controllerName),
+			AttrRequest.String(req.String()),
+		)
+		result, err := r.Reconcile(ctx, req)
+		End(span, err)
+		return result, err
+	})
+}
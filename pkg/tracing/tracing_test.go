@@ -0,0 +1,124 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/CharlieR-o-o-t/eks-webhook-proxy/config"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+// record installs global tracer provider recording ended spans, previous provider is restored with the test.
+func record(t *testing.T) *tracetest.SpanRecorder {
+	t.Helper()
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+
+	previous := otel.GetTracerProvider()
+	otel.SetTracerProvider(provider)
+	t.Cleanup(func() {
+		otel.SetTracerProvider(previous)
+		_ = provider.Shutdown(context.Background())
+	})
+	return recorder
+}
+
+func attributes(span sdktrace.ReadOnlySpan) map[attribute.Key]attribute.Value {
+	attrs := make(map[attribute.Key]attribute.Value)
+	for _, attr := range span.Attributes() {
+		attrs[attr.Key] = attr.Value
+	}
+	return attrs
+}
+
+func TestReconcilerSpans(t *testing.T) {
+	recorder := record(t)
+
+	service := types.NamespacedName{Namespace: "webhooks", Name: "webhook"}
+	stepErr := errors.New("apply failed")
+
+	r := Reconciler("mutation-controller", reconcile.Func(func(ctx context.Context, req reconcile.Request) (reconcile.Result, error) {
+		SetAttributes(ctx, Webhook("MutatingWebhookConfiguration", req.Name))
+
+		_, span := Start(ctx, "EnsureProxyEndpointSlices", Service(service))
+		span.SetAttributes(Operation(controllerutil.OperationResultCreated), AttrEndpoints.Int(2))
+		End(span, nil)
+
+		_, span = Start(ctx, "EnsureServiceProxy", Service(service))
+		End(span, stepErr)
+		return reconcile.Result{}, stepErr
+	}))
+
+	req := reconcile.Request{NamespacedName: types.NamespacedName{Name: "webhook-config"}}
+	if _, err := r.Reconcile(context.Background(), req); !errors.Is(err, stepErr) {
+		t.Fatalf("Reconcile() error = %v, want %v", err, stepErr)
+	}
+
+	spans := recorder.Ended()
+	if len(spans) != 3 {
+		t.Fatalf("recorded %d spans, want 3", len(spans))
+	}
+	endpoints, proxy, reconcileSpan := spans[0], spans[1], spans[2]
+
+	if got := reconcileSpan.Name(); got != "mutation-controller.Reconcile" {
+		t.Errorf("reconcile span name = %q", got)
+	}
+	attrs := attributes(reconcileSpan)
+	for key, want := range map[attribute.Key]string{
+		AttrController: "mutation-controller",
+		AttrRequest:    "/webhook-config",
+		AttrWebhook:    "MutatingWebhookConfiguration/webhook-config",
+	} {
+		if got := attrs[key].AsString(); got != want {
+			t.Errorf("reconcile span %s = %q, want %q", key, got, want)
+		}
+	}
+	if got := reconcileSpan.Status().Code; got != codes.Error {
+		t.Errorf("reconcile span status = %v, want error", got)
+	}
+
+	for _, step := range []sdktrace.ReadOnlySpan{endpoints, proxy} {
+		if step.Parent().SpanID() != reconcileSpan.SpanContext().SpanID() {
+			t.Errorf("step span %s is not a child of reconcile span", step.Name())
+		}
+		if got := attributes(step)[AttrService].AsString(); got != service.String() {
+			t.Errorf("step span %s service = %q, want %q", step.Name(), got, service)
+		}
+	}
+
+	attrs = attributes(endpoints)
+	if got := attrs[AttrOperation].AsString(); got != string(controllerutil.OperationResultCreated) {
+		t.Errorf("endpoints span operation = %q", got)
+	}
+	if got := attrs[AttrEndpoints].AsInt64(); got != 2 {
+		t.Errorf("endpoints span endpoints = %d, want 2", got)
+	}
+	if got := endpoints.Status().Code; got == codes.Error {
+		t.Errorf("endpoints span status = %v, want no error", got)
+	}
+
+	if got := proxy.Status(); got.Code != codes.Error || got.Description != stepErr.Error() {
+		t.Errorf("proxy span status = %v, want error %q", got, stepErr)
+	}
+	if events := proxy.Events(); len(events) != 1 || events[0].Name != "exception" {
+		t.Errorf("proxy span events = %v, want recorded error", events)
+	}
+}
+
+func TestSetupDisabled(t *testing.T) {
+	shutdown, err := Setup(context.Background(), config.Tracing{})
+	if err != nil {
+		t.Fatalf("Setup() error = %v", err)
+	}
+	if err := shutdown(context.Background()); err != nil {
+		t.Errorf("shutdown() error = %v", err)
+	}
+}
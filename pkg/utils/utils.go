@@ -14,6 +14,24 @@ const (
 	LabelWebhookPort                   = "service.infra.io/webhook-port"
 	LabelServiceProxyIgnoreRestriction = "service.infra.io/proxy-ignore-restriction"
 	LabelEndpointSliceServiceName      = "kubernetes.io/service-name"
+	LabelNetworkSet                    = "service.infra.io/network-set"
+
+	// AnnotationStashedSelector keeps webhook service selector removed by the controller.
+	AnnotationStashedSelector = "service.infra.io/stashed-selector"
+
+	// Proxy overrides, set on Service or Namespace.
+	AnnotationProxyRestricted   = "service.infra.io/proxy-restricted"
+	AnnotationProxyAllowedCIDRs = "service.infra.io/proxy-allowed-cidrs"
+	AnnotationProxyNodeSelector = "service.infra.io/proxy-node-selector"
+	AnnotationProxyPorts        = "service.infra.io/proxy-ports"
+
+	// Webhook selection, set on MutatingWebhookConfiguration, ValidatingWebhookConfiguration or CRD.
+	// AnnotationProxy opts object in or out, AnnotationProxyWebhooks lists proxied webhooks of configuration.
+	AnnotationProxy         = "service.infra.io/proxy"
+	AnnotationProxyWebhooks = "service.infra.io/proxy-webhooks"
+
+	// AnnotationCanary is set on webhook configuration, it holds object template submitted with dryRun=All.
+	AnnotationCanary = "service.infra.io/proxy-canary"
 
 	LabelKeyEndpointSliceController = "endpointslice-controller.k8s.io"
 	ControllerName                  = "eks-webhook-proxy"
@@ -0,0 +1,95 @@
+package webhookref
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/CharlieR-o-o-t/eks-webhook-proxy/api/v1alpha1"
+	admissionv1 "k8s.io/api/admissionregistration/v1"
+	apiextv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// DefaultServicePort is used by kube-apiserver when webhook service port is not set.
+const DefaultServicePort = 443
+
+// ClientConfig is the client configuration of a webhook calling the service.
+type ClientConfig struct {
+	// Webhook identifies the webhook, <kind>/<name> or <kind>/<name>/<webhook>.
+	Webhook  string
+	CABundle []byte
+	// Port is the service port called by kube-apiserver.
+	Port int32
+}
+
+// ClientConfigs returns client configurations of referenced webhooks calling the service.
+// Objects which are already deleted are skipped.
+func ClientConfigs(ctx context.Context, reader client.Reader, service types.NamespacedName, refs []v1alpha1.WebhookReference) ([]ClientConfig, error) {
+	var configs []ClientConfig
+
+	calls := func(ref *admissionv1.ServiceReference) bool {
+		return ref != nil && ref.Namespace == service.Namespace && ref.Name == service.Name
+	}
+
+	for _, ref := range refs {
+		switch ref.Kind {
+		case KindMutating:
+			var obj = new(admissionv1.MutatingWebhookConfiguration)
+			if err := reader.Get(ctx, types.NamespacedName{Name: ref.Name}, obj); err != nil {
+				if apierrors.IsNotFound(err) {
+					continue
+				}
+				return nil, fmt.Errorf("unable to get mutating webhook %s, %w", ref.Name, err)
+			}
+			for _, webhook := range obj.Webhooks {
+				if calls(webhook.ClientConfig.Service) {
+					configs = append(configs, ClientConfig{
+						Webhook:  fmt.Sprintf("%s/%s/%s", ref.Kind, ref.Name, webhook.Name),
+						CABundle: webhook.ClientConfig.CABundle,
+						Port:     ptr.Deref(webhook.ClientConfig.Service.Port, DefaultServicePort),
+					})
+				}
+			}
+		case KindValidating:
+			var obj = new(admissionv1.ValidatingWebhookConfiguration)
+			if err := reader.Get(ctx, types.NamespacedName{Name: ref.Name}, obj); err != nil {
+				if apierrors.IsNotFound(err) {
+					continue
+				}
+				return nil, fmt.Errorf("unable to get validating webhook %s, %w", ref.Name, err)
+			}
+			for _, webhook := range obj.Webhooks {
+				if calls(webhook.ClientConfig.Service) {
+					configs = append(configs, ClientConfig{
+						Webhook:  fmt.Sprintf("%s/%s/%s", ref.Kind, ref.Name, webhook.Name),
+						CABundle: webhook.ClientConfig.CABundle,
+						Port:     ptr.Deref(webhook.ClientConfig.Service.Port, DefaultServicePort),
+					})
+				}
+			}
+		case KindCRD:
+			var obj = new(apiextv1.CustomResourceDefinition)
+			if err := reader.Get(ctx, types.NamespacedName{Name: ref.Name}, obj); err != nil {
+				if apierrors.IsNotFound(err) {
+					continue
+				}
+				return nil, fmt.Errorf("unable to get custom resource definition %s, %w", ref.Name, err)
+			}
+			conversionService := ConversionService(obj)
+			if conversionService == nil ||
+				conversionService.Namespace != service.Namespace || conversionService.Name != service.Name {
+				continue
+			}
+			configs = append(configs, ClientConfig{
+				Webhook:  fmt.Sprintf("%s/%s", ref.Kind, ref.Name),
+				CABundle: obj.Spec.Conversion.Webhook.ClientConfig.CABundle,
+				Port:     ptr.Deref(conversionService.Port, DefaultServicePort),
+			})
+		}
+	}
+
+	return configs, nil
+}
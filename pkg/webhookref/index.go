@@ -0,0 +1,86 @@
+package webhookref
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/CharlieR-o-o-t/eks-webhook-proxy/api/v1alpha1"
+	admissionv1 "k8s.io/api/admissionregistration/v1"
+	apiextv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// IndexService indexes webhook configurations and CRDs by referenced services, "namespace/name".
+const IndexService = "webhookref.service"
+
+// SetupIndexes registers IndexService in the cache, it must be called before the cache is started.
+func SetupIndexes(ctx context.Context, indexer client.FieldIndexer) error {
+	if err := indexer.IndexField(ctx, &admissionv1.MutatingWebhookConfiguration{}, IndexService, func(obj client.Object) []string {
+		services := make(ServiceMap)
+		services.AddMutating(obj.(*admissionv1.MutatingWebhookConfiguration))
+		return services.keys()
+	}); err != nil {
+		return fmt.Errorf("unable to index mutating webhooks, %w", err)
+	}
+
+	if err := indexer.IndexField(ctx, &admissionv1.ValidatingWebhookConfiguration{}, IndexService, func(obj client.Object) []string {
+		services := make(ServiceMap)
+		services.AddValidating(obj.(*admissionv1.ValidatingWebhookConfiguration))
+		return services.keys()
+	}); err != nil {
+		return fmt.Errorf("unable to index validating webhooks, %w", err)
+	}
+
+	if err := indexer.IndexField(ctx, &apiextv1.CustomResourceDefinition{}, IndexService, func(obj client.Object) []string {
+		services := make(ServiceMap)
+		services.AddCRD(obj.(*apiextv1.CustomResourceDefinition))
+		return services.keys()
+	}); err != nil {
+		return fmt.Errorf("unable to index custom resource definitions, %w", err)
+	}
+
+	return nil
+}
+
+// ListForService returns references of the service from webhooks proxied in selection mode, see Selected.
+// Only objects referencing the service are listed, reader must be the cache with IndexService.
+func ListForService(ctx context.Context, reader client.Reader, mode string, service types.NamespacedName) ([]v1alpha1.WebhookReference, error) {
+	services := make(ServiceMap)
+	matching := client.MatchingFields{IndexService: service.String()}
+
+	var mutatingList = new(admissionv1.MutatingWebhookConfigurationList)
+	if err := reader.List(ctx, mutatingList, matching); err != nil {
+		return nil, fmt.Errorf("unable to list mutating webhooks, %w", err)
+	}
+	for _, mutating := range mutatingList.Items {
+		services.addMutating(&mutating, mode)
+	}
+
+	var validatingList = new(admissionv1.ValidatingWebhookConfigurationList)
+	if err := reader.List(ctx, validatingList, matching); err != nil {
+		return nil, fmt.Errorf("unable to list validating webhooks, %w", err)
+	}
+	for _, validating := range validatingList.Items {
+		services.addValidating(&validating, mode)
+	}
+
+	var crdList = new(apiextv1.CustomResourceDefinitionList)
+	if err := reader.List(ctx, crdList, matching); err != nil {
+		return nil, fmt.Errorf("unable to list custom resource definitions, %w", err)
+	}
+	for _, crd := range crdList.Items {
+		services.addCRD(&crd, mode)
+	}
+
+	services.sort()
+	return services[service], nil
+}
+
+func (m ServiceMap) keys() []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key.String())
+	}
+	return keys
+}
@@ -0,0 +1,54 @@
+package webhookref
+
+import (
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/CharlieR-o-o-t/eks-webhook-proxy/config"
+	"github.com/CharlieR-o-o-t/eks-webhook-proxy/pkg/utils"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// Selected tells if webhook of mutating, validating configuration or CRD is proxied in selection mode.
+// webhookName is empty for CRD conversion webhook, utils.AnnotationProxyWebhooks does not apply to it.
+//
+// In opt-in mode object is selected by utils.AnnotationProxy "true" or by utils.AnnotationProxyWebhooks.
+// In opt-out mode every object is selected, unless utils.AnnotationProxy is "false".
+// When utils.AnnotationProxyWebhooks is set, only the listed webhooks of configuration are selected.
+func Selected(mode string, obj client.Object, webhookName string) bool {
+	if mode == config.SelectionModeAll {
+		return true
+	}
+
+	annotations := obj.GetAnnotations()
+	enabled, err := strconv.ParseBool(annotations[utils.AnnotationProxy])
+	if err == nil && !enabled {
+		return false
+	}
+
+	webhooks, listed := selectedWebhooks(annotations)
+	if mode == config.SelectionModeOptIn && !enabled && !listed {
+		return false
+	}
+	if listed && webhookName != "" {
+		return slices.Contains(webhooks, webhookName)
+	}
+	return true
+}
+
+// selectedWebhooks returns webhook names listed in utils.AnnotationProxyWebhooks.
+func selectedWebhooks(annotations map[string]string) ([]string, bool) {
+	value, ok := annotations[utils.AnnotationProxyWebhooks]
+	if !ok {
+		return nil, false
+	}
+
+	var webhooks []string
+	for _, name := range strings.Split(value, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			webhooks = append(webhooks, name)
+		}
+	}
+	return webhooks, true
+}
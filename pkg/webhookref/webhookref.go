@@ -0,0 +1,146 @@
+package webhookref
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/CharlieR-o-o-t/eks-webhook-proxy/api/v1alpha1"
+	"github.com/CharlieR-o-o-t/eks-webhook-proxy/config"
+	admissionv1 "k8s.io/api/admissionregistration/v1"
+	apiextv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	KindMutating   = "MutatingWebhookConfiguration"
+	KindValidating = "ValidatingWebhookConfiguration"
+	KindCRD        = "CustomResourceDefinition"
+)
+
+// ServiceMap maps webhook service to objects referencing it.
+type ServiceMap map[types.NamespacedName][]v1alpha1.WebhookReference
+
+// List collects service references from all mutating, validating and conversion webhooks.
+func List(ctx context.Context, reader client.Reader) (ServiceMap, error) {
+	return ListSelected(ctx, reader, config.SelectionModeAll)
+}
+
+// ListSelected collects service references from webhooks proxied in selection mode, see Selected.
+func ListSelected(ctx context.Context, reader client.Reader, mode string) (ServiceMap, error) {
+	services := make(ServiceMap)
+
+	var mutatingList = new(admissionv1.MutatingWebhookConfigurationList)
+	if err := reader.List(ctx, mutatingList); err != nil {
+		return nil, fmt.Errorf("unable to list mutating webhooks, %w", err)
+	}
+	for _, mutating := range mutatingList.Items {
+		services.addMutating(&mutating, mode)
+	}
+
+	var validatingList = new(admissionv1.ValidatingWebhookConfigurationList)
+	if err := reader.List(ctx, validatingList); err != nil {
+		return nil, fmt.Errorf("unable to list validating webhooks, %w", err)
+	}
+	for _, validating := range validatingList.Items {
+		services.addValidating(&validating, mode)
+	}
+
+	var crdList = new(apiextv1.CustomResourceDefinitionList)
+	if err := reader.List(ctx, crdList); err != nil {
+		return nil, fmt.Errorf("unable to list custom resource definitions, %w", err)
+	}
+	for _, crd := range crdList.Items {
+		services.addCRD(&crd, mode)
+	}
+
+	services.sort()
+	return services, nil
+}
+
+func (m ServiceMap) AddMutating(obj *admissionv1.MutatingWebhookConfiguration) {
+	m.addMutating(obj, config.SelectionModeAll)
+}
+
+func (m ServiceMap) AddValidating(obj *admissionv1.ValidatingWebhookConfiguration) {
+	m.addValidating(obj, config.SelectionModeAll)
+}
+
+func (m ServiceMap) AddCRD(obj *apiextv1.CustomResourceDefinition) {
+	m.addCRD(obj, config.SelectionModeAll)
+}
+
+// AddSelected adds services of mutating, validating configuration or CRD webhooks proxied in selection mode.
+func (m ServiceMap) AddSelected(obj client.Object, mode string) {
+	switch obj := obj.(type) {
+	case *admissionv1.MutatingWebhookConfiguration:
+		m.addMutating(obj, mode)
+	case *admissionv1.ValidatingWebhookConfiguration:
+		m.addValidating(obj, mode)
+	case *apiextv1.CustomResourceDefinition:
+		m.addCRD(obj, mode)
+	}
+}
+
+func (m ServiceMap) addMutating(obj *admissionv1.MutatingWebhookConfiguration, mode string) {
+	for _, webhook := range obj.Webhooks {
+		if Selected(mode, obj, webhook.Name) {
+			m.add(KindMutating, obj.Name, webhook.Name, webhook.ClientConfig.Service)
+		}
+	}
+}
+
+func (m ServiceMap) addValidating(obj *admissionv1.ValidatingWebhookConfiguration, mode string) {
+	for _, webhook := range obj.Webhooks {
+		if Selected(mode, obj, webhook.Name) {
+			m.add(KindValidating, obj.Name, webhook.Name, webhook.ClientConfig.Service)
+		}
+	}
+}
+
+func (m ServiceMap) addCRD(obj *apiextv1.CustomResourceDefinition, mode string) {
+	service := ConversionService(obj)
+	if service == nil || !Selected(mode, obj, "") {
+		return
+	}
+	key := types.NamespacedName{Namespace: service.Namespace, Name: service.Name}
+	m[key] = append(m[key], v1alpha1.WebhookReference{Kind: KindCRD, Name: obj.Name})
+}
+
+// ConversionService returns conversion webhook service of CRD, nil if it is not set.
+func ConversionService(obj *apiextv1.CustomResourceDefinition) *apiextv1.ServiceReference {
+	if obj.Spec.Conversion == nil ||
+		obj.Spec.Conversion.Webhook == nil ||
+		obj.Spec.Conversion.Webhook.ClientConfig == nil {
+		return nil
+	}
+	return obj.Spec.Conversion.Webhook.ClientConfig.Service
+}
+
+func (m ServiceMap) add(kind, name, webhookName string, service *admissionv1.ServiceReference) {
+	if service == nil {
+		return
+	}
+	key := types.NamespacedName{Namespace: service.Namespace, Name: service.Name}
+
+	refs := m[key]
+	for i := range refs {
+		if refs[i].Kind == kind && refs[i].Name == name {
+			refs[i].Webhooks = append(refs[i].Webhooks, webhookName)
+			return
+		}
+	}
+	m[key] = append(refs, v1alpha1.WebhookReference{Kind: kind, Name: name, Webhooks: []string{webhookName}})
+}
+
+func (m ServiceMap) sort() {
+	for _, refs := range m {
+		sort.Slice(refs, func(i, j int) bool {
+			if refs[i].Kind != refs[j].Kind {
+				return refs[i].Kind < refs[j].Kind
+			}
+			return refs[i].Name < refs[j].Name
+		})
+	}
+}